@@ -0,0 +1,56 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/sampler/memrecord"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// analyzeCmd represents the analyze command
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze <trace file>",
+	Short: "使用--save-trace保存的追踪文件重新计算RTH与MRC，可以更换计算方式与最大RTH时间",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("参数错误")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		recorder, err := memrecord.NewReplayRecorder(args[0], memrecord.TraceFormatRM)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		rq := &memrecord.RunRequest{
+			BaseRequest: memrecord.BaseRequest{
+				Name: "analyze",
+			},
+		}
+		err = runSample(recorder, rq)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	sampleCmd.AddCommand(analyzeCmd)
+}
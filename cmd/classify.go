@@ -0,0 +1,150 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/classifier"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	sweepThreshold string
+	sweepFrom      float64
+	sweepTo        float64
+	sweepStep      float64
+)
+
+var characteristicOrder = []classifier.MemoryCharacteristic{
+	classifier.MemoryCharacteristicBully,
+	classifier.MemoryCharacteristicSquanderer,
+	classifier.MemoryCharacteristicNonCritical,
+	classifier.MemoryCharacteristicMedium,
+	classifier.MemoryCharacteristicSensitive,
+}
+
+// classifyCmd represents the classify command
+var classifyCmd = &cobra.Command{
+	Use:   "classify <all ways perfstat.csv> <two ways perfstat.csv>",
+	Short: "使用记录的全部way与2个way时的perfstat.csv离线分类，可以用--config指定其他阈值，或者用--sweep观察阈值变化时分类的变化",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("参数错误")
+		}
+		if sweepThreshold != "" && (sweepStep <= 0 || sweepTo < sweepFrom) {
+			return fmt.Errorf("扫描范围错误")
+		}
+		// 模型的叶子节点直接给出分类，不使用阈值，扫描不会改变任何结果
+		if sweepThreshold != "" && core.RootConfig.Algorithm.Classify.ModelPath != "" {
+			return fmt.Errorf("使用模型分类时阈值不起作用，无法扫描，请去掉modelpath配置")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		allWays, err := os.Open(args[0])
+		if err != nil {
+			return errors.Wrap(err, "打开文件出错")
+		}
+		defer func() {
+			_ = allWays.Close()
+		}()
+		twoWays, err := os.Open(args[1])
+		if err != nil {
+			return errors.Wrap(err, "打开文件出错")
+		}
+		defer func() {
+			_ = twoWays.Close()
+		}()
+		processes, err := classifier.LoadOfflineProcesses(allWays, twoWays)
+		if err != nil {
+			return err
+		}
+
+		explainer, err := classifier.NewExplainer(core.RootConfig.Algorithm.Classify)
+		if err != nil {
+			return err
+		}
+		if sweepThreshold == "" {
+			printClassification(processes, explainer, core.RootConfig.Algorithm.Classify)
+			return nil
+		}
+		return sweepClassification(processes, explainer, core.RootConfig.Algorithm.Classify)
+	},
+}
+
+func printClassification(processes []*classifier.OfflineProcess, explainer classifier.Explainer, config core.ClassifyConfig) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "进程组\tpid\t分类\t依据")
+	for _, p := range processes {
+		e := explainer.Explain(p.AllWays, p.TwoWays, config)
+		_, _ = fmt.Fprintf(writer, "%s\t%d\t%s\t%s\n", p.GroupId, p.Pid, e.Characteristic, e)
+	}
+	_ = writer.Flush()
+}
+
+// 逐个取扫描范围内的阈值进行分类，输出每种分类的进程数量，以及与当前配置相比分类改变的进程
+func sweepClassification(processes []*classifier.OfflineProcess, explainer classifier.Explainer, config core.ClassifyConfig) error {
+	baseline := make([]classifier.MemoryCharacteristic, len(processes))
+	for i, p := range processes {
+		baseline[i] = explainer.Explain(p.AllWays, p.TwoWays, config).Characteristic
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := []string{sweepThreshold}
+	for _, c := range characteristicOrder {
+		header = append(header, string(c))
+	}
+	header = append(header, "改变")
+	_, _ = fmt.Fprintln(writer, strings.Join(header, "\t"))
+	// 按步数计算阈值，避免浮点累加的误差
+	for i := 0; sweepFrom+float64(i)*sweepStep <= sweepTo+sweepStep*1e-9; i++ {
+		value := sweepFrom + float64(i)*sweepStep
+		sweepConfig := config
+		if err := classifier.SetThreshold(&sweepConfig, sweepThreshold, value); err != nil {
+			return err
+		}
+		count := map[classifier.MemoryCharacteristic]int{}
+		var changed []string
+		for j, p := range processes {
+			c := explainer.Explain(p.AllWays, p.TwoWays, sweepConfig).Characteristic
+			count[c]++
+			if c != baseline[j] {
+				changed = append(changed, fmt.Sprintf("%s/%d:%s->%s", p.GroupId, p.Pid, baseline[j], c))
+			}
+		}
+		row := []string{fmt.Sprintf("%.6g", value)}
+		for _, c := range characteristicOrder {
+			row = append(row, fmt.Sprintf("%d", count[c]))
+		}
+		row = append(row, strings.Join(changed, " "))
+		_, _ = fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(classifyCmd)
+
+	classifyCmd.Flags().StringVar(&sweepThreshold, "sweep", "", "扫描的阈值名称，与配置文件algorithm.classify中的键相同，如ipclow")
+	classifyCmd.Flags().Float64Var(&sweepFrom, "from", 0, "扫描的起始值")
+	classifyCmd.Flags().Float64Var(&sweepTo, "to", 0, "扫描的结束值，包括此值")
+	classifyCmd.Flags().Float64Var(&sweepStep, "step", 0.1, "扫描的步长")
+}
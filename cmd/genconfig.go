@@ -33,7 +33,11 @@ var genconfigCmd = &cobra.Command{
 			fmt.Println("创建输出文件失败", err)
 			os.Exit(1)
 		}
-		marshal, err := yaml.Marshal(core.RootConfig)
+		config := *core.RootConfig
+		if len(config.Algorithm.Classify.Rules) == 0 {
+			config.Algorithm.Classify.Rules = core.DefaultClassifyRules
+		}
+		marshal, err := yaml.Marshal(&config)
 		if err != nil {
 			fmt.Println("序列化失败", err)
 		}
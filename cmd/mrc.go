@@ -19,6 +19,7 @@ import (
 	"encoding/csv"
 	"fmt"
 	"github.com/packagewjx/resourcemanager/internal/algorithm"
+	"github.com/packagewjx/resourcemanager/internal/core"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"os"
@@ -27,17 +28,27 @@ import (
 
 var (
 	precision int
+	mrcModel  string
 )
 
 // mrcCmd represents the mrc command
 var mrcCmd = &cobra.Command{
 	Use:   "mrc <rth.csv> <cache size> <out file>",
-	Short: "使用RTH Csv文件，使用AET模型，计算MRC，输出到指定CSV",
+	Short: "使用RTH Csv文件，使用AET或者足迹模型，计算MRC，输出到指定CSV",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 3 {
 			return fmt.Errorf("参数数量不对")
 		}
-		return nil
+		// 没有指定时使用配置文件中的模型
+		if !cmd.Flags().Changed("model") {
+			mrcModel = string(core.RootConfig.MemTrace.MRCModel)
+		}
+		switch core.MRCModelType(mrcModel) {
+		case core.MRCModelAET, core.MRCModelFootprint:
+			return nil
+		default:
+			return fmt.Errorf("不支持的MRC模型 %s", mrcModel)
+		}
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		f, err := os.Open(args[0])
@@ -47,7 +58,7 @@ var mrcCmd = &cobra.Command{
 		defer func() {
 			_ = f.Close()
 		}()
-		model, err := algorithm.NewAETModelFromFile(f)
+		model, err := algorithm.NewMRCModelFromFile(core.MRCModelType(mrcModel), f)
 		if err != nil {
 			return errors.Wrap(err, "解析RTH文件出错")
 		}
@@ -84,4 +95,6 @@ func init() {
 	rootCmd.AddCommand(mrcCmd)
 
 	mrcCmd.Flags().IntVarP(&precision, "precision", "p", 2, "Miss Rate精度")
+	mrcCmd.Flags().StringVarP(&mrcModel, "model", "m", string(core.RootConfig.MemTrace.MRCModel),
+		"MRC模型，aet为Average Eviction Time模型，footprint为HOTL平均足迹模型")
 }
@@ -18,6 +18,7 @@ package cmd
 import (
 	"fmt"
 	"github.com/fsnotify/fsnotify"
+	"github.com/packagewjx/resourcemanager/internal/classifier"
 	"github.com/packagewjx/resourcemanager/internal/core"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -73,14 +74,22 @@ func readConfig() {
 		log.Println("读取配置出错", err)
 	}
 	log.Println("读取到配置", core.RootConfig)
+	if _, err = classifier.NewExplainer(core.RootConfig.Algorithm.Classify); err != nil {
+		log.Fatalln(err)
+	}
 
 	viper.WatchConfig()
 	viper.OnConfigChange(func(in fsnotify.Event) {
 		if in.Op == fsnotify.Write {
 			log.Printf("配置文件已更改，正在重新读取")
 			_ = viper.ReadInConfig()
+			// 解析列表时会保留原有的多余元素，因此先清空规则
+			core.RootConfig.Algorithm.Classify.Rules = nil
 			_ = viper.UnmarshalExact(core.RootConfig)
 			log.Println("读取到配置", core.RootConfig)
+			if _, err := classifier.NewExplainer(core.RootConfig.Algorithm.Classify); err != nil {
+				log.Println(err)
+			}
 		}
 	})
 }
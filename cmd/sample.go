@@ -26,12 +26,20 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
-var useShenModel bool
+var (
+	useShenModel  bool
+	saveTrace     string
+	statsInterval time.Duration
+	sampleTids    []int
+	reservoirSize int
+)
 
 // sampleCmd represents the sample command
 var sampleCmd = &cobra.Command{
@@ -51,39 +59,83 @@ func init() {
 	sampleCmd.PersistentFlags().IntP("write-threshold", "w", core.RootConfig.MemTrace.PinConfig.WriteThreshold,
 		"消费数据阈值")
 	_ = viper.BindPFlag("memtrace.writethreshold", sampleCmd.PersistentFlags().Lookup("write-threshold"))
+	sampleCmd.PersistentFlags().String("sampler", string(core.RootConfig.MemTrace.Sampler),
+		"地址采样方式，可选pin、perf与perfevent")
+	_ = viper.BindPFlag("memtrace.sampler", sampleCmd.PersistentFlags().Lookup("sampler"))
+	sampleCmd.PersistentFlags().Uint64("sample-period", core.RootConfig.MemTrace.PerfEventConfig.SamplePeriod,
+		"perfevent采样方式的采样周期")
+	_ = viper.BindPFlag("memtrace.perfeventconfig.sampleperiod", sampleCmd.PersistentFlags().Lookup("sample-period"))
+	sampleCmd.PersistentFlags().Duration("duration", core.RootConfig.MemTrace.PerfEventConfig.Duration,
+		"perfevent采样方式的采样时长，为0时不限制")
+	_ = viper.BindPFlag("memtrace.perfeventconfig.duration", sampleCmd.PersistentFlags().Lookup("duration"))
 	sampleCmd.PersistentFlags().IntP("stop-at", "s", core.RootConfig.MemTrace.TraceCount,
 		"采集内存数据总数")
 	_ = viper.BindPFlag("memtrace.tracecount", sampleCmd.PersistentFlags().Lookup("stop-at"))
-
-	sampleCmd.PersistentFlags().BoolVarP(&useShenModel, "useShenModel", "d", false, "")
+	sampleCmd.PersistentFlags().String("composition", string(core.RootConfig.MemTrace.MRCComposition),
+		"多线程MRC合成方式，weighted为按线程指令数加权平均，interleaved为所有线程交织后整体计算")
+	_ = viper.BindPFlag("memtrace.mrccomposition", sampleCmd.PersistentFlags().Lookup("composition"))
+	sampleCmd.PersistentFlags().String("model", string(core.RootConfig.MemTrace.MRCModel),
+		"MRC模型，aet为Average Eviction Time模型，footprint为HOTL平均足迹模型")
+	_ = viper.BindPFlag("memtrace.mrcmodel", sampleCmd.PersistentFlags().Lookup("model"))
+
+	sampleCmd.PersistentFlags().String("calculator", string(core.RootConfig.MemTrace.RthCalculatorType),
+		"RTH计算方式，可选full与reservoir")
+	_ = viper.BindPFlag("memtrace.rthcalculatortype", sampleCmd.PersistentFlags().Lookup("calculator"))
+	// start命令已绑定memtrace.reservoirsize，这里直接读取参数
+	sampleCmd.PersistentFlags().IntVar(&reservoirSize, "reservoir-size", core.RootConfig.MemTrace.ReservoirSize,
+		"reservoir计算方式的样本数量")
+	sampleCmd.PersistentFlags().StringVar(&saveTrace, "save-trace", "",
+		"将采集到的地址保存到压缩追踪文件中，之后可以使用sample analyze重新计算")
+	sampleCmd.PersistentFlags().DurationVar(&statsInterval, "stats-interval", 0,
+		"每隔一段时间输出一次地址处理速度，为0时不输出")
+	sampleCmd.PersistentFlags().IntSliceVar(&sampleTids, "tids", nil,
+		"只分析这些线程的访存地址，为空时分析所有线程")
+	sampleCmd.PersistentFlags().BoolVarP(&useShenModel, "useShenModel", "d", false,
+		"额外使用Shen模型近似计算Reuse Distance Histogram，并输出对应的MRC")
 }
 
 func executeSampleCommand(rq interface{}) error {
-	var recorder memrecord.MemRecorder
-	var err error
-	memTraceConfig := core.RootConfig.MemTrace
-	if memTraceConfig.Sampler == core.MemTraceSamplerPerf {
-		recorder, err = memrecord.NewPerfRecorder(memTraceConfig.PerfRecordConfig.OverflowCount,
-			memTraceConfig.PerfRecordConfig.SwitchOutput,
-			memTraceConfig.PerfRecordConfig.PerfExecPath)
-	} else {
-		recorder, err = memrecord.NewPinMemRecorder(&memrecord.Config{
-			BufferSize:     memTraceConfig.PinConfig.BufferSize,
-			WriteThreshold: memTraceConfig.PinConfig.WriteThreshold,
-			PinToolPath:    memTraceConfig.PinConfig.PinToolPath,
-			ConcurrentMax:  memTraceConfig.ConcurrentMax,
-			TraceCount:     memTraceConfig.TraceCount,
-		})
-	}
+	recorder, err := memrecord.NewMemRecorderFromRootConfig()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	return runSample(recorder, rq)
+}
 
-	var consumer memrecord.CacheLineAddressConsumer
-	consumer = memrecord.NewRTHCalculatorConsumer(memrecord.GetCalculatorFromRootConfig())
+// 使用recorder执行采样请求，并输出RTH与MRC
+func runSample(recorder memrecord.MemRecorder, rq interface{}) error {
+	var err error
+	if sampleCmd.PersistentFlags().Changed("reservoir-size") {
+		core.RootConfig.MemTrace.ReservoirSize = reservoirSize
+	}
+
+	consumer := memrecord.GetConsumerFromRootConfig()
+	var pipeline memrecord.CacheLineAddressConsumer = consumer
+	var traceWriter *memrecord.TraceFileWriter
+	if saveTrace != "" {
+		traceWriter, err = memrecord.CreateTraceFile(saveTrace)
+		if err != nil {
+			return err
+		}
+		pipeline = memrecord.NewMultiplexConsumer(consumer, traceWriter)
+	}
+	if len(sampleTids) != 0 {
+		pipeline = memrecord.NewTidFilter(pipeline, sampleTids)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	// 记录结束后停止输出处理速度
+	stopStats := func() {}
+	if statsInterval > 0 {
+		stats := memrecord.NewStatsConsumer(pipeline)
+		pipeline = stats
+		var statsCtx context.Context
+		statsCtx, stopStats = context.WithCancel(ctx)
+		defer stopStats()
+		go stats.Report(statsCtx, statsInterval,
+			log.New(os.Stdout, "Sample: ", log.LstdFlags|log.Lshortfile|log.Lmsgprefix))
+	}
 	// 注册信号处理
 	sigCh := make(chan os.Signal)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -95,21 +147,37 @@ func executeSampleCommand(rq interface{}) error {
 	var ch <-chan *memrecord.Result
 	switch v := rq.(type) {
 	case *memrecord.AttachRequest:
-		v.Consumer = consumer
-		ch, _ = recorder.RecordProcess(ctx, v)
+		v.Consumer = pipeline
+		ch, err = recorder.RecordProcess(ctx, v)
 	case *memrecord.RunRequest:
-		v.Consumer = consumer
-		ch, _ = recorder.RecordCommand(ctx, v)
+		v.Consumer = pipeline
+		ch, err = recorder.RecordCommand(ctx, v)
 	default:
 		panic("错误类型")
 	}
+	if err != nil {
+		_ = memrecord.CloseConsumer(pipeline)
+		return err
+	}
 
 	m := <-ch
+	stopStats()
+	if traceWriter != nil {
+		traceWriter.SetResult(m)
+	}
+	// 同时关闭追踪文件，写入剩余的数据与索引
+	if err = memrecord.CloseConsumer(pipeline); err != nil {
+		return err
+	}
 	if m.Err != nil {
 		return m.Err
 	}
 
-	return rthOutput(consumer.(memrecord.RTHCalculatorConsumer), m)
+	err = rthOutput(consumer, m)
+	if err != nil || !useShenModel {
+		return err
+	}
+	return shenOutput(ctx, consumer)
 }
 
 func rthOutput(consumer memrecord.RTHCalculatorConsumer, m *memrecord.Result) error {
@@ -122,11 +190,18 @@ func rthOutput(consumer memrecord.RTHCalculatorConsumer, m *memrecord.Result) er
 		algorithm.WriteAsCsv(calculator.GetRTH(core.RootConfig.MemTrace.MaxRthTime), outFile)
 		_ = outFile.Close()
 	}
-	// 输出加权平均MRC
+	if process := consumer.GetProcessCalculator(); process != nil {
+		outFile, err := os.Create("sample_process.mcf.rth.csv")
+		if err != nil {
+			return errors.Wrap(err, "无法创建输出文件")
+		}
+		algorithm.WriteAsCsv(process.GetRTH(core.RootConfig.MemTrace.MaxRthTime), outFile)
+		_ = outFile.Close()
+	}
+	// 按配置的合成方式输出进程MRC
 	numWays, numSets, _ := utils.GetL3Cap()
-	mrc := resourcemanager.WeightedAverageMRC(threadTrace, m.ThreadInstructionCount, m.TotalInstructions,
-		core.RootConfig.MemTrace.MaxRthTime, numWays*numSets*2)
-	outFile, err := os.Create("sample_weighted_mrc.csv")
+	mrc := resourcemanager.ProcessMRC(consumer, m, core.RootConfig.MemTrace.MaxRthTime, numWays*numSets*2)
+	outFile, err := os.Create(fmt.Sprintf("sample_%s_mrc.csv", core.RootConfig.MemTrace.MRCComposition))
 	if err != nil {
 		return errors.Wrap(err, "无法创建输出文件")
 	}
@@ -136,3 +211,41 @@ func rthOutput(consumer memrecord.RTHCalculatorConsumer, m *memrecord.Result) er
 	_ = outFile.Close()
 	return nil
 }
+
+// 使用Shen模型近似计算每个线程的MRC，有进程整体的RTH时也计算进程的MRC
+func shenOutput(ctx context.Context, consumer memrecord.RTHCalculatorConsumer) error {
+	numWays, numSets, _ := utils.GetL3Cap()
+	cacheSize := numWays * numSets * 2
+	const numBuckets = 4096
+
+	write := func(name string, calculator algorithm.RTHCalculator) error {
+		model := algorithm.NewShenModel(calculator.GetRTH(core.RootConfig.MemTrace.MaxRthTime))
+		model.SetProgressFunc(func(done, total int) {
+			if done%(64*1024) == 0 || done == total {
+				fmt.Printf("%s: Shen模型计算进度 %d/%d\n", name, done, total)
+			}
+		})
+		rdh, err := model.ApproximateReuseDistanceHistogram(ctx, cacheSize, numBuckets)
+		if err != nil {
+			return errors.Wrap(err, "Shen模型计算出错")
+		}
+		outFile, err := os.Create(fmt.Sprintf("sample_%s_shen_mrc.csv", name))
+		if err != nil {
+			return errors.Wrap(err, "无法创建输出文件")
+		}
+		for c, miss := range rdh.MRC(cacheSize) {
+			_, _ = fmt.Fprintf(outFile, "%d,%.4f\n", c, miss)
+		}
+		return outFile.Close()
+	}
+
+	for tid, calculator := range consumer.GetCalculatorMap() {
+		if err := write(fmt.Sprintf("%d", tid), calculator); err != nil {
+			return err
+		}
+	}
+	if process := consumer.GetProcessCalculator(); process != nil {
+		return write("process", process)
+	}
+	return nil
+}
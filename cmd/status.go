@@ -0,0 +1,73 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/resourcemanager"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status [status file]",
+	Short: "查看运行中的ResourceManager每个进程的分类结果与分类依据，默认读取manager.statusfile",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("参数错误")
+		}
+		if len(args) == 0 && core.RootConfig.Manager.StatusFile == "" {
+			return fmt.Errorf("没有配置manager.statusfile，请指定状态文件")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := core.RootConfig.Manager.StatusFile
+		if len(args) == 1 {
+			path = args[0]
+		}
+		status, err := resourcemanager.ReadStatus(path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("状态更新于 %s\n", status.Time.Format("2006-01-02 15:04:05"))
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(writer, "进程组\t状态\tpid\t分类\t依据")
+		for _, group := range status.Groups {
+			if group.Explanation != nil {
+				_, _ = fmt.Fprintf(writer, "%s\t%s\t-\t%s\t%s\n", group.Id, group.State, group.Characteristic,
+					group.Explanation)
+			}
+			for _, p := range group.Processes {
+				reason := "-"
+				if p.Explanation != nil {
+					reason = p.Explanation.String()
+				} else if p.Inherited {
+					reason = "继承自进程组"
+				}
+				_, _ = fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%s\n", group.Id, group.State, p.Pid, p.Characteristic, reason)
+			}
+		}
+		return writer.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
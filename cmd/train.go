@@ -0,0 +1,100 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/classifier"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var (
+	trainOutput         string
+	trainMaxDepth       int
+	trainMinSamplesLeaf int
+)
+
+// trainCmd represents the train command
+var trainCmd = &cobra.Command{
+	Use:   "train <all ways perfstat.csv> <two ways perfstat.csv>",
+	Short: "使用记录的全部way与2个way时的perfstat.csv训练决策树分类模型，标注为全部way文件中的characteristic列，可以手工修改后再训练",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("参数错误")
+		}
+		if trainMaxDepth < 1 || trainMinSamplesLeaf < 1 {
+			return fmt.Errorf("max-depth与min-samples-leaf必须大于0")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		allWays, err := os.Open(args[0])
+		if err != nil {
+			return errors.Wrap(err, "打开文件出错")
+		}
+		defer func() {
+			_ = allWays.Close()
+		}()
+		twoWays, err := os.Open(args[1])
+		if err != nil {
+			return errors.Wrap(err, "打开文件出错")
+		}
+		defer func() {
+			_ = twoWays.Close()
+		}()
+		processes, err := classifier.LoadOfflineProcesses(allWays, twoWays)
+		if err != nil {
+			return err
+		}
+
+		samples := make([]*classifier.TrainingSample, 0, len(processes))
+		for _, p := range processes {
+			if p.Label == "" {
+				return fmt.Errorf("进程组 %s 的进程 %d 没有标注", p.GroupId, p.Pid)
+			}
+			samples = append(samples, &classifier.TrainingSample{AllWays: p.AllWays, TwoWays: p.TwoWays, Label: p.Label})
+		}
+		model, err := classifier.TrainDecisionTree(samples, trainMaxDepth, trainMinSamplesLeaf)
+		if err != nil {
+			return errors.Wrap(err, "训练模型出错")
+		}
+		if err = model.Save(trainOutput); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("保存模型到 %s 出错", trainOutput))
+		}
+
+		correct := 0
+		for _, sample := range samples {
+			if model.Explain(sample.AllWays, sample.TwoWays, core.RootConfig.Algorithm.Classify).Characteristic == sample.Label {
+				correct++
+			}
+		}
+		fmt.Printf("模型已保存到 %s，训练样本 %d 个，训练集准确率 %.2f%%\n", trainOutput, len(samples),
+			float64(correct)*100/float64(len(samples)))
+		fmt.Printf("在配置文件中设置algorithm.classify.modelpath为 %s 以使用此模型\n", trainOutput)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(trainCmd)
+
+	trainCmd.Flags().StringVarP(&trainOutput, "output", "o", "model.json", "模型的输出路径")
+	trainCmd.Flags().IntVar(&trainMaxDepth, "max-depth", 4, "决策树的最大深度")
+	trainCmd.Flags().IntVar(&trainMinSamplesLeaf, "min-samples-leaf", 2, "每个叶子节点最少的样本数")
+}
@@ -3,6 +3,7 @@ package algorithm
 import (
 	"github.com/packagewjx/resourcemanager/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
@@ -125,12 +126,21 @@ func TestAetImpl_MRC(t *testing.T) {
 
 func TestBA(t *testing.T) {
 	trace, err := utils.ParseCTFTrace("/home/wjx/Workspace/valgrind-tracegen/inst/out")
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return
+	}
 	rth := FullTraceCalculator()
 	for _, addrList := range trace {
 		rth.Update(addrList)
 	}
-	out, _ := os.Create("mcf.rth.csv")
+	// 输出写到临时目录，不留在包目录中
+	out, err := ioutil.TempFile("", "mcf.rth.*.csv")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		_ = os.Remove(out.Name())
+	}()
 	WriteAsCsv(rth.GetRTH(100000), out)
 	_ = out.Close()
 }
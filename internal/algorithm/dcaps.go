@@ -13,9 +13,18 @@ import (
 
 // DCAPS算法输入。以进程为单位进行分配的计算。
 type ProgramMetric struct {
-	Pid      int
-	MRC      []float32
-	PerfStat *perf.StatResult
+	Pid       int
+	MRC       []float32
+	PerfStat  *perf.StatResult
+	GroupPids []int // 进程组整体分配时组内的所有进程，与Pid分配到同一个CLOS。为空时只分配Pid
+}
+
+// 分配到同一个CLOS的所有进程
+func (p *ProgramMetric) pids() []int {
+	if len(p.GroupPids) == 0 {
+		return []int{p.Pid}
+	}
+	return p.GroupPids
 }
 
 type predictSystemMetric struct {
@@ -319,7 +328,12 @@ func readFromOldSchemes(programs []*ProgramMetric, oldSchemes []*pqos.CLOSScheme
 	schemes = make([]*pqos.CLOSScheme, numClos)
 	schemeMap = make([]int, len(programs))
 	for _, scheme := range oldSchemes {
-		schemes[scheme.CLOSNum] = scheme
+		// 只沿用way与带宽的设置，进程在计算完成后按schemeMap重新填充，不修改oldSchemes
+		schemes[scheme.CLOSNum] = &pqos.CLOSScheme{
+			CLOSNum:     scheme.CLOSNum,
+			WayBit:      scheme.WayBit,
+			MemThrottle: scheme.MemThrottle,
+		}
 	}
 	// 填充空的CLOS
 	for i := 0; i < len(schemes); i++ {
@@ -335,7 +349,9 @@ func readFromOldSchemes(programs []*ProgramMetric, oldSchemes []*pqos.CLOSScheme
 	// 将schemeMap赋值。programs中有的，但是oldScheme中没有的，赋值为0即可。oldSchemes中有的而programs没有的则不需要处理
 	pidIdxMap := make(map[int]int)
 	for pi, program := range programs {
-		pidIdxMap[program.Pid] = pi
+		for _, pid := range program.pids() {
+			pidIdxMap[pid] = pi
+		}
 	}
 	for _, scheme := range oldSchemes {
 		for _, process := range scheme.Processes {
@@ -406,7 +422,7 @@ func DCAPS(programs []*ProgramMetric, oldSchemes []*pqos.CLOSScheme, numWays, nu
 
 	// 组装结果
 	for pi, s := range bestSchemeMap {
-		bestScheme[s].Processes = append(bestScheme[s].Processes, programs[pi].Pid)
+		bestScheme[s].Processes = append(bestScheme[s].Processes, programs[pi].pids()...)
 	}
 
 	return bestScheme
@@ -438,7 +438,25 @@ func TestReadFromOldScheme(t *testing.T) {
 	assert.Equal(t, 4, len(readSchemes))
 	for i := 0; i < len(readSchemes); i++ {
 		assert.Equal(t, i, readSchemes[i].CLOSNum)
+		assert.Empty(t, readSchemes[i].Processes)
 	}
+	assert.Equal(t, 0xFF, readSchemes[2].WayBit)
+	assert.Equal(t, []int{1, 2, 3}, schemes[1].Processes)
+}
+
+func TestReadFromOldSchemeGroup(t *testing.T) {
+	programs := []*ProgramMetric{
+		{Pid: 1, GroupPids: []int{1, 2, 3}},
+		{Pid: 4},
+	}
+	schemes := []*pqos.CLOSScheme{
+		{CLOSNum: 2, WayBit: 0xFF, MemThrottle: 100, Processes: []int{2}},
+		{CLOSNum: 3, WayBit: 0x700, MemThrottle: 100, Processes: []int{4}},
+	}
+	_, schemeMap := readFromOldSchemes(programs, schemes, 11, 4)
+	assert.Equal(t, []int{2, 3}, schemeMap)
+	assert.Equal(t, []int{1, 2, 3}, programs[0].pids())
+	assert.Equal(t, []int{4}, programs[1].pids())
 }
 
 func TestSchemeVisitedKey(t *testing.T) {
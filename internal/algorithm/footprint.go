@@ -0,0 +1,156 @@
+package algorithm
+
+import (
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"sort"
+)
+
+/*
+A Higher Order Theory of Locality
+Xiaoya Xiang, Chen Ding, et. al.
+*/
+
+// 基于平均足迹（average footprint）的MRC模型。
+// 足迹fp(w)为长度为w的窗口内平均访问的不同数据数量，在稳态下fp(w) = E[min(rt, w)]，其中从未再使用的数据rt为无穷。
+// 填满大小为c的缓存所需的时间（fill time）为fp的反函数，Miss Rate为足迹在fill time处的导数。
+// 与AETModel使用相同的RTH，但足迹与fill time均以浮点数连续计算，超出最大再使用时间的部分按尾部概率线性外推，
+// 因此缓存足够大时Miss Rate能够下降到0，而不是停留在最大再使用时间处的值。
+type footprintImpl struct {
+	pGreater []float64 // pGreater[t]为P(rt > t)，t取值为0到最大再使用时间
+	fp       []float64 // fp[w]为窗口长度为w时的足迹，w取值为0到最大再使用时间+1
+}
+
+var _ AETModel = &footprintImpl{}
+
+// 使用RTH构建足迹模型。RTH格式与NewAETModel一致：第0位为没有再使用的数量，最后一位为超过最大再使用时间的数量。
+// 不会修改rth的内容。
+func NewFootprintModel(rth []int) AETModel {
+	maxTime := len(rth) - 2
+	if maxTime < 0 {
+		maxTime = 0
+	}
+	total := 0
+	for _, c := range rth {
+		total += c
+	}
+
+	pGreater := make([]float64, maxTime+1)
+	if total == 0 {
+		// 没有样本时认为全部都是Miss
+		for t := range pGreater {
+			pGreater[t] = 1
+		}
+	} else {
+		// 没有再使用与超过最大再使用时间的样本，对任何t都计入rt > t
+		greater := total
+		for t := 0; t <= maxTime; t++ {
+			if t > 0 {
+				greater -= rth[t]
+			}
+			pGreater[t] = float64(greater) / float64(total)
+		}
+	}
+
+	fp := make([]float64, maxTime+2)
+	for w := 1; w < len(fp); w++ {
+		fp[w] = fp[w-1] + pGreater[w-1]
+	}
+	return &footprintImpl{
+		pGreater: pGreater,
+		fp:       fp,
+	}
+}
+
+func (f *footprintImpl) tail() float64 {
+	return f.pGreater[len(f.pGreater)-1]
+}
+
+// 窗口长度为w时的平均足迹，w可以为小数
+func (f *footprintImpl) footprint(w float64) float64 {
+	last := len(f.fp) - 1
+	if w >= float64(last) {
+		return f.fp[last] + (w-float64(last))*f.tail()
+	}
+	i := int(w)
+	return f.fp[i] + (w-float64(i))*f.pGreater[i]
+}
+
+// 填满cacheSize个缓存行所需的时间，单位为访问次数。缓存永远无法填满时返回正无穷
+func (f *footprintImpl) fillTime(cacheSize int) float64 {
+	c := float64(cacheSize)
+	last := len(f.fp) - 1
+	if c > f.fp[last] {
+		if f.tail() == 0 {
+			return math.Inf(1)
+		}
+		return float64(last) + (c-f.fp[last])/f.tail()
+	}
+	i := sort.SearchFloat64s(f.fp, c)
+	if i == 0 {
+		return 0
+	}
+	return float64(i-1) + (c-f.fp[i-1])/f.pGreater[i-1]
+}
+
+func (f *footprintImpl) ProbabilityReuseTimeGreaterThan(t int) float32 {
+	if t < 0 {
+		return 1
+	} else if t >= len(f.pGreater) {
+		return float32(f.tail())
+	}
+	return float32(f.pGreater[t])
+}
+
+// 返回fill time向上取整的结果。缓存永远无法填满时返回math.MaxInt32
+func (f *footprintImpl) AET(cacheSize int) int {
+	ft := f.fillTime(cacheSize)
+	if math.IsInf(ft, 1) || ft > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(math.Ceil(ft))
+}
+
+func (f *footprintImpl) MR(cacheSize int) float32 {
+	ft := f.fillTime(cacheSize)
+	if math.IsInf(ft, 1) {
+		return 0
+	}
+	// 足迹在fill time处的导数，即fill time所在区间的斜率
+	if ft >= float64(len(f.pGreater)) {
+		return float32(f.tail())
+	}
+	return float32(f.pGreater[int(ft)])
+}
+
+func (f *footprintImpl) MRC(cacheSize int) []float32 {
+	result := make([]float32, cacheSize+1)
+	for c := 0; c <= cacheSize; c++ {
+		result[c] = f.MR(c)
+	}
+	return result
+}
+
+// 根据模型类型构建MRC模型
+func NewMRCModel(modelType core.MRCModelType, rth []int) (AETModel, error) {
+	switch modelType {
+	case core.MRCModelAET:
+		return NewAETModel(rth), nil
+	case core.MRCModelFootprint:
+		return NewFootprintModel(rth), nil
+	default:
+		return nil, fmt.Errorf("不支持的MRC模型类型%s", modelType)
+	}
+}
+
+// 读取RTH Csv文件，并构建指定类型的MRC模型
+func NewMRCModelFromFile(modelType core.MRCModelType, file io.Reader) (AETModel, error) {
+	rth, err := readRTHCsv(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取RTH数据出错")
+	}
+	return NewMRCModel(modelType, rth)
+}
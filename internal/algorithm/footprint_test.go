@@ -0,0 +1,72 @@
+package algorithm
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFootprintModel(t *testing.T) {
+	model, err := NewMRCModelFromFile(core.MRCModelFootprint, strings.NewReader(case1))
+	assert.NoError(t, err)
+	impl := model.(*footprintImpl)
+	aet, _ := NewAETModelFromFile(strings.NewReader(case1))
+
+	// 与AET模型使用相同的RTH，P的计算结果一致
+	for _, rt := range []int{0, 1, 10, 41, 50, 100} {
+		assert.InDelta(t, aet.ProbabilityReuseTimeGreaterThan(rt), model.ProbabilityReuseTimeGreaterThan(rt), 0.000001)
+	}
+
+	assert.Equal(t, float64(0), impl.footprint(0))
+	for c := 1; c < 20; c++ {
+		ft := impl.fillTime(c)
+		assert.InDelta(t, float64(c), impl.footprint(ft), 0.000001)
+		// 稳态下两个模型给出的Miss Rate接近，差别只在离散化方式
+		assert.InDelta(t, aet.MR(c), model.MR(c), 0.05)
+	}
+
+	mrc := model.MRC(100)
+	assert.Len(t, mrc, 101)
+	assert.Equal(t, float32(1), mrc[0])
+	for c := 1; c < len(mrc); c++ {
+		assert.LessOrEqual(t, mrc[c], mrc[c-1])
+	}
+	// 超出最大再使用时间后按尾部概率外推
+	assert.InDelta(t, model.ProbabilityReuseTimeGreaterThan(100), mrc[100], 0.000001)
+}
+
+func TestFootprintModelCyclic(t *testing.T) {
+	// 循环访问100个缓存行，缓存小于100时全部Miss，不小于100时全部命中
+	addr := make([]uint64, 0, 5000)
+	for i := 0; i < 50; i++ {
+		for j := uint64(0); j < 100; j++ {
+			addr = append(addr, j<<6)
+		}
+	}
+	calculator := FullTraceCalculator()
+	calculator.Update(addr)
+	model, err := NewMRCModel(core.MRCModelFootprint, calculator.GetRTH(1000))
+	assert.NoError(t, err)
+	mrc := model.MRC(200)
+	for c := 0; c < 100; c++ {
+		assert.Equal(t, float32(1), mrc[c], "缓存大小 %d", c)
+	}
+	for c := 100; c <= 200; c++ {
+		assert.Equal(t, float32(0), mrc[c], "缓存大小 %d", c)
+	}
+	assert.Equal(t, 100, model.AET(100))
+	assert.Equal(t, math.MaxInt32, model.AET(101))
+}
+
+func TestNewMRCModel(t *testing.T) {
+	model, err := NewMRCModel(core.MRCModelAET, []int{0, 1, 0})
+	assert.NoError(t, err)
+	assert.IsType(t, &aetImpl{}, model)
+	model, err = NewMRCModel(core.MRCModelFootprint, []int{0, 1, 0})
+	assert.NoError(t, err)
+	assert.IsType(t, &footprintImpl{}, model)
+	_, err = NewMRCModel("unknown", []int{0, 1, 0})
+	assert.Error(t, err)
+}
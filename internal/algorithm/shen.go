@@ -2,13 +2,12 @@ package algorithm
 
 import (
 	"context"
+	"fmt"
 	"go.uber.org/atomic"
-	"log"
 	"math"
 	"math/big"
 	"runtime"
 	"sync"
-	"time"
 )
 
 /*
@@ -22,18 +21,36 @@ func init() {
 	maxCpu := runtime.NumCPU() - 1
 	if maxCpu > 8 {
 		maxCpu = 8
+	} else if maxCpu < 1 {
+		// 单核时容量为0会导致计算协程永远阻塞
+		maxCpu = 1
 	}
 	concurrentControl = make(chan struct{}, maxCpu)
 }
 
+// 进度回调。done为已完成的工作量，total为总工作量
+type ProgressFunc func(done, total int)
+
 type ShenModel struct {
-	rth []int
+	rth      []int
+	progress ProgressFunc
 }
 
 func NewShenModel(rth []int) *ShenModel {
 	return &ShenModel{rth: rth}
 }
 
+// 设置计算进度回调，为nil时不汇报进度
+func (m *ShenModel) SetProgressFunc(f ProgressFunc) {
+	m.progress = f
+}
+
+func (m *ShenModel) reportProgress(done, total int) {
+	if m.progress != nil {
+		m.progress(done, total)
+	}
+}
+
 //ReuseDistanceHistogram 根据当前的所有地址，计算出现在的Reuse Time Histogram
 func (m *ShenModel) ReuseDistanceHistogram() []float64 {
 	rthSum := 0
@@ -59,21 +76,7 @@ func (m *ShenModel) ReuseDistanceHistogram() []float64 {
 	}
 	c := newCombination(N)
 
-	// 进度汇报
 	cnt := atomic.NewInt32(0)
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		tick := time.Tick(time.Second)
-		for {
-			select {
-			case <-tick:
-				log.Printf("prk计算进度：%10d/%10d\n", cnt.Load(), N)
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
-
 	wg := sync.WaitGroup{}
 	result := make([]float64, N+1)
 	for d := 1; d <= N; d++ {
@@ -81,13 +84,12 @@ func (m *ShenModel) ReuseDistanceHistogram() []float64 {
 		go func(d int) {
 			concurrentControl <- struct{}{}
 			result[d] = m.prk(d, N, pt, p3, c)
-			cnt.Inc()
+			m.reportProgress(int(cnt.Inc()), N)
 			wg.Done()
 			<-concurrentControl
 		}(d)
 	}
 	wg.Wait()
-	cancel()
 	return result
 }
 
@@ -125,27 +127,11 @@ func newCombination(n int) *combination {
 	last := []*big.Float{bigFloat1}
 	var c []*big.Float
 
-	// 报告进度使用
-	cnt := atomic.NewInt32(0)
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		tick := time.Tick(500 * time.Millisecond)
-		for {
-			select {
-			case <-tick:
-				log.Printf("组合数计算已完成：%10d\n", cnt.Load())
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
-
 	// 使用组合数性质加法，减少浮点数阶乘乘法
 	calFunc := func(start, end int) {
 		for i := start; i < end; i++ {
 			c[i] = big.NewFloat(0)
 			c[i].Add(last[i], last[i-1])
-			cnt.Inc()
 		}
 	}
 	for curr := 2; curr <= n; curr++ {
@@ -182,7 +168,6 @@ func newCombination(n int) *combination {
 		}
 		last = c
 	}
-	cancel()
 	return (*combination)(&last)
 }
 
@@ -200,3 +185,157 @@ func (c *combination) k(k int) *big.Float {
 		return (*c)[k]
 	}
 }
+
+// 近似计算得到的Reuse Distance Histogram，距离按桶聚合
+type ReuseDistanceHistogram struct {
+	BucketWidth int       // 每个桶覆盖的距离范围
+	MaxDistance int       // 大于这个距离的概率全部计入最后一个桶
+	Histogram   []float64 // Histogram[i]为距离位于[i*BucketWidth, (i+1)*BucketWidth)的概率，最后一个桶为大于MaxDistance的概率
+	ColdMiss    float64   // 没有再使用或者再使用时间超过最大值的概率
+}
+
+// 根据Reuse Distance Histogram计算LRU缓存的Miss Rate Curve。桶内的概率视为均匀分布
+func (h *ReuseDistanceHistogram) MRC(cacheSize int) []float32 {
+	// suffix[i]为距离不小于第i个桶起点的概率
+	suffix := make([]float64, len(h.Histogram)+1)
+	for i := len(h.Histogram) - 1; i >= 0; i-- {
+		suffix[i] = suffix[i+1] + h.Histogram[i]
+	}
+	overflow := h.Histogram[len(h.Histogram)-1]
+	result := make([]float32, cacheSize+1)
+	for c := 0; c <= cacheSize; c++ {
+		var miss float64
+		if c > h.MaxDistance {
+			miss = overflow
+		} else {
+			bucket := c / h.BucketWidth
+			miss = suffix[bucket+1] + h.Histogram[bucket]*float64((bucket+1)*h.BucketWidth-c)/float64(h.BucketWidth)
+		}
+		result[c] = float32(math.Min(1, miss+h.ColdMiss))
+	}
+	return result
+}
+
+const (
+	// 二项分布方差大于此值时使用正态分布近似，否则使用对数空间的精确概率
+	shenNormalApproxVariance = 25
+	// 正态近似时只计算均值附近这么多个标准差的范围，之外的概率可以忽略
+	shenNormalApproxSigmaRange = 8
+	// 每处理这么多个再使用时间汇报一次进度并检查是否被取消
+	shenProgressInterval = 1024
+)
+
+// 近似计算Reuse Distance Histogram，可用于真实规模（上亿次访问）的RTH。
+// 与ReuseDistanceHistogram使用相同的概率模型，但将每个再使用时间对应的二项分布B(N, p3)用正态分布或者对数空间的
+// 精确概率近似，并且将距离按桶聚合，内存占用只与len(rth)和numBuckets有关。
+// maxDistance一般为缓存行的数量，大于这个距离的概率将合并到最后一个桶。
+func (m *ShenModel) ApproximateReuseDistanceHistogram(ctx context.Context, maxDistance, numBuckets int) (*ReuseDistanceHistogram, error) {
+	if len(m.rth) < 3 {
+		return nil, fmt.Errorf("RTH长度过短")
+	}
+	if numBuckets < 1 || maxDistance < 1 {
+		return nil, fmt.Errorf("maxDistance与numBuckets必须大于0")
+	}
+	rthSum := 0
+	for _, i := range m.rth {
+		rthSum += i
+	}
+	if rthSum < 2 {
+		return nil, fmt.Errorf("RTH样本数量过少")
+	}
+	N := float64(rthSum)
+	pt := make([]float64, len(m.rth))
+	for i, v := range m.rth {
+		pt[i] = float64(v) / N
+	}
+	// p3[t]的计算与ReuseDistanceHistogram一致，使用后缀和避免重复求和
+	ptPostFixSum := make([]float64, len(pt)+1)
+	for i := len(pt) - 1; i > 0; i-- {
+		ptPostFixSum[i] = pt[i] + ptPostFixSum[i+1]
+	}
+	p3 := make([]float64, len(m.rth))
+	for t := 1; t < len(m.rth)-1; t++ {
+		p3[t] = p3[t-1] + 1/(N-1)*ptPostFixSum[t+1]
+	}
+
+	width := (maxDistance + numBuckets) / numBuckets
+	h := &ReuseDistanceHistogram{
+		BucketWidth: width,
+		MaxDistance: maxDistance,
+		Histogram:   make([]float64, (maxDistance+width)/width+1),
+		ColdMiss:    pt[0] + pt[len(pt)-1],
+	}
+	overflow := len(h.Histogram) - 1
+	// 将距离为k的概率mass加入直方图
+	add := func(k int, mass float64) {
+		if k > maxDistance {
+			h.Histogram[overflow] += mass
+		} else {
+			h.Histogram[k/width] += mass
+		}
+	}
+
+	total := len(m.rth) - 2
+	for delta := 1; delta <= total; delta++ {
+		if delta%shenProgressInterval == 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			default:
+			}
+			m.reportProgress(delta, total)
+		}
+		if pt[delta] == 0 {
+			continue
+		}
+		p := p3[delta]
+		if p >= 1 {
+			add(rthSum, pt[delta])
+			continue
+		}
+		mean := N * p
+		variance := mean * (1 - p)
+		if variance >= shenNormalApproxVariance {
+			// 正态近似，带连续性修正。按桶边界计算累积分布之差
+			sigma := math.Sqrt(variance)
+			lo := int(math.Max(1, mean-shenNormalApproxSigmaRange*sigma))
+			hi := int(math.Min(N, mean+shenNormalApproxSigmaRange*sigma))
+			cdf := func(k int) float64 {
+				return 0.5 * math.Erfc(-(float64(k)-0.5-mean)/(sigma*math.Sqrt2))
+			}
+			if lo > maxDistance {
+				h.Histogram[overflow] += pt[delta] * (cdf(hi+1) - cdf(lo))
+				continue
+			}
+			for from := lo; from <= hi && from <= maxDistance; {
+				to := (from/width+1)*width - 1
+				if to > hi {
+					to = hi
+				}
+				if to > maxDistance {
+					to = maxDistance
+				}
+				add(from, pt[delta]*(cdf(to+1)-cdf(from)))
+				from = to + 1
+			}
+			if hi > maxDistance {
+				h.Histogram[overflow] += pt[delta] * (cdf(hi+1) - cdf(maxDistance+1))
+			}
+		} else {
+			// 方差较小时精确计算概率质量函数，取对数避免溢出
+			sigma := math.Sqrt(variance)
+			lo := int(math.Max(1, mean-10*sigma-10))
+			hi := int(math.Min(N, mean+10*sigma+10))
+			lgN, _ := math.Lgamma(N + 1)
+			logP, log1mP := math.Log(p), math.Log1p(-p)
+			for k := lo; k <= hi; k++ {
+				lgK, _ := math.Lgamma(float64(k) + 1)
+				lgNK, _ := math.Lgamma(N - float64(k) + 1)
+				logPmf := lgN - lgK - lgNK + float64(k)*logP + (N-float64(k))*log1mP
+				add(k, pt[delta]*math.Exp(logPmf))
+			}
+		}
+	}
+	m.reportProgress(total, total)
+	return h, nil
+}
@@ -1,6 +1,7 @@
 package algorithm
 
 import (
+	"context"
 	"github.com/packagewjx/resourcemanager/internal/utils"
 	"github.com/packagewjx/resourcemanager/test"
 	"github.com/stretchr/testify/assert"
@@ -76,6 +77,71 @@ func TestShenModelWithRth(t *testing.T) {
 	assert.NotZero(t, len(rdh))
 }
 
+func TestApproximateReuseDistanceHistogram(t *testing.T) {
+	addr := make([]uint64, 600)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < len(addr); i++ {
+		addr[i] = r.Uint64() & 0x1FC0
+	}
+	calculator := FullTraceCalculator()
+	calculator.Update(addr)
+	model := NewShenModel(calculator.GetRTH(600))
+
+	exact := model.ReuseDistanceHistogram()
+	const maxDistance = 200
+	approx, err := model.ApproximateReuseDistanceHistogram(context.Background(), maxDistance, maxDistance)
+	assert.NoError(t, err)
+	sum := approx.ColdMiss
+	for _, f := range approx.Histogram {
+		assert.False(t, math.IsNaN(f))
+		sum += f
+	}
+	assert.InDelta(t, 1, sum, 0.01)
+
+	// 与精确计算的MRC比较
+	mrc := approx.MRC(maxDistance)
+	coldMiss := approx.ColdMiss
+	for c := 0; c <= maxDistance; c += 10 {
+		expect := coldMiss
+		for d := c; d < len(exact); d++ {
+			expect += exact[d]
+		}
+		assert.InDelta(t, expect, mrc[c], 0.02, "缓存大小 %d", c)
+	}
+}
+
+func TestApproximateReuseDistanceHistogramLarge(t *testing.T) {
+	// 1亿次访问规模的RTH
+	rth := make([]int, 100002)
+	r := rand.New(rand.NewSource(1))
+	for i := range rth {
+		rth[i] = 500 + r.Intn(1000)
+	}
+	model := NewShenModel(rth)
+	progressCalled := 0
+	model.SetProgressFunc(func(done, total int) {
+		progressCalled++
+		assert.LessOrEqual(t, done, total)
+	})
+	h, err := model.ApproximateReuseDistanceHistogram(context.Background(), 225280, 4096)
+	assert.NoError(t, err)
+	assert.NotZero(t, progressCalled)
+	sum := h.ColdMiss
+	for _, f := range h.Histogram {
+		sum += f
+	}
+	assert.InDelta(t, 1, sum, 0.01)
+	mrc := h.MRC(225280)
+	for i := 1; i < len(mrc); i++ {
+		assert.LessOrEqual(t, mrc[i], mrc[i-1]+1e-6)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = model.ApproximateReuseDistanceHistogram(ctx, 225280, 4096)
+	assert.Equal(t, context.Canceled, err)
+}
+
 func BenchmarkCombination(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		newCombination(10000)
@@ -0,0 +1,35 @@
+package classifier
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"math"
+	"time"
+)
+
+// 进程的内存带宽强度，与MemoryCharacteristic描述的缓存行为相互独立
+type BandwidthIntensity string
+
+var (
+	BandwidthIntensityUnknown BandwidthIntensity = ""
+	BandwidthIntensityLow     BandwidthIntensity = "low"
+	BandwidthIntensityHigh    BandwidthIntensity = "high"
+)
+
+// 由LLC缺失数估计duration内的内存带宽，单位为MB/s。每次缺失从内存读取一个缓存行，不包括写回与预取，因此是带宽的下限
+func EstimateBandwidth(stat *perf.StatResult, duration time.Duration, lineBytes int) float64 {
+	if stat == nil || duration <= 0 {
+		return math.NaN()
+	}
+	return float64(stat.LLCMiss) * float64(lineBytes) / duration.Seconds() / (1 << 20)
+}
+
+// 带宽达到high时为带宽密集，无法估计带宽时为未知
+func bandwidthIntensity(bandwidth, high float64) BandwidthIntensity {
+	if math.IsNaN(bandwidth) {
+		return BandwidthIntensityUnknown
+	}
+	if bandwidth >= high {
+		return BandwidthIntensityHigh
+	}
+	return BandwidthIntensityLow
+}
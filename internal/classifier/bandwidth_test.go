@@ -0,0 +1,20 @@
+package classifier
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEstimateBandwidth(t *testing.T) {
+	stat := &perf.StatResult{LLCMiss: 1 << 20}
+	assert.InDelta(t, 32.0, EstimateBandwidth(stat, 2*time.Second, 64), 1e-9)
+	assert.True(t, math.IsNaN(EstimateBandwidth(stat, 0, 64)))
+	assert.True(t, math.IsNaN(EstimateBandwidth(nil, time.Second, 64)))
+
+	assert.Equal(t, BandwidthIntensityHigh, bandwidthIntensity(2000, 2000))
+	assert.Equal(t, BandwidthIntensityLow, bandwidthIntensity(32, 2000))
+	assert.Equal(t, BandwidthIntensityUnknown, bandwidthIntensity(math.NaN(), 2000))
+}
@@ -6,10 +6,11 @@ import (
 	"github.com/packagewjx/resourcemanager/internal/core"
 	"github.com/packagewjx/resourcemanager/internal/pqos"
 	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/packagewjx/resourcemanager/internal/utils"
 	"github.com/pkg/errors"
 	"log"
-	"math"
 	"os"
+	"time"
 )
 
 type MemoryCharacteristic string
@@ -23,21 +24,49 @@ var (
 	MemoryCharacteristicSensitive   MemoryCharacteristic = "sensitive"
 )
 
+// 是否为可以作为分类结果的内存特征
+func isKnownCharacteristic(c MemoryCharacteristic) bool {
+	switch c {
+	case MemoryCharacteristicNonCritical, MemoryCharacteristicSquanderer, MemoryCharacteristicBully,
+		MemoryCharacteristicMedium, MemoryCharacteristicSensitive:
+		return true
+	default:
+		return false
+	}
+}
+
 type Config struct {
+	// 在model模式下提供进程的MRC，没有MRC时返回nil。为nil时使用CMT测得的缓存占用进行估计
+	MRCProvider func(pid int) []float32
 }
 
 type Result struct {
-	Group     *core.ProcessGroup
-	Error     error
-	Processes []*ProcessResult
+	Group       *core.ProcessGroup
+	Error       error
+	Processes   []*ProcessResult
+	Policy      core.GroupPolicy
+	DominantPid int // 全部way时指令数最多的进程
+	// 进程组整体的分类结果，per-process策略下Characteristic为空
+	Characteristic     MemoryCharacteristic
+	StatResultAllWays  *perf.StatResult
+	Explanation        *Explanation
+	Bandwidth          float64
+	BandwidthIntensity BandwidthIntensity
 }
 
 type ProcessResult struct {
-	Pid               int
-	Error             error
-	Characteristic    MemoryCharacteristic
-	StatResultAllWays *perf.StatResult
-	StatResultTwoWays *perf.StatResult
+	Pid                int
+	Error              error
+	Characteristic     MemoryCharacteristic
+	StatResultAllWays  *perf.StatResult
+	StatResultTwoWays  *perf.StatResult
+	Mode               core.ClassifyMode
+	RestrictedWays     int  // StatResultTwoWays测量或者估计时的way数。gentle模式提前停止时大于2
+	TwoWaysEstimated   bool // StatResultTwoWays是估计值而不是测量值
+	Explanation        *Explanation
+	Duration           time.Duration // StatResultAllWays的测量时间
+	Bandwidth          float64       // 全部way时由LLC缺失估计的内存带宽，单位为MB/s，无法估计时为NaN
+	BandwidthIntensity BandwidthIntensity
 }
 
 type Classifier interface {
@@ -45,13 +74,24 @@ type Classifier interface {
 	Classify(ctx context.Context, group *core.ProcessGroup) <-chan *Result
 }
 
-func New(_ *Config) (Classifier, error) {
+func New(config *Config) (Classifier, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	explainer, err := NewExplainer(core.RootConfig.Algorithm.Classify)
+	if err != nil {
+		return nil, err
+	}
 	return &impl{
-		logger: log.New(os.Stdout, fmt.Sprintf("Classifier: "), log.Lmsgprefix|log.LstdFlags|log.Lshortfile),
+		config:    *config,
+		explainer: explainer,
+		logger:    log.New(os.Stdout, fmt.Sprintf("Classifier: "), log.Lmsgprefix|log.LstdFlags|log.Lshortfile),
 	}, nil
 }
 
 type impl struct {
+	config        Config
+	explainer     Explainer // 创建时读取规则或者模型，修改后需要重新创建分类器
 	reservoirSize int
 	logger        *log.Logger
 }
@@ -62,12 +102,13 @@ func (c *impl) Classify(ctx context.Context, group *core.ProcessGroup) <-chan *R
 	resultCh := make(chan *Result, 1)
 	go func(group *core.ProcessGroup) {
 		defer close(resultCh)
-		c.logger.Printf("开始对进程组 %s 执行分类", group.Id)
+		c.logger.Printf("开始对进程组 %s 执行分类，模式为 %s", group.Id, core.RootConfig.Algorithm.Classify.Mode)
 		processResults := c.perfProcesses(ctx, group)
 		errCount := 0
 		for _, result := range processResults {
 			if result.Error == nil {
 				result.Characteristic = c.determineCharacteristic(result)
+				c.determineBandwidth(result)
 			} else {
 				c.logger.Printf("进程组 %s 进程 %d 分类出错：%v", group.Id, result.Pid, result.Error)
 				errCount++
@@ -79,6 +120,8 @@ func (c *impl) Classify(ctx context.Context, group *core.ProcessGroup) <-chan *R
 		}
 		if errCount == len(processResults) {
 			res.Error = fmt.Errorf("采样全部出现错误")
+		} else {
+			c.determineGroup(res)
 		}
 		c.logger.Printf("进程组 %s 分类结束", group.Id)
 		resultCh <- res
@@ -87,15 +130,29 @@ func (c *impl) Classify(ctx context.Context, group *core.ProcessGroup) <-chan *R
 }
 
 func (c *impl) perfProcesses(ctx context.Context, group *core.ProcessGroup) []*ProcessResult {
-	// 首先在全缓存way时测试一次
+	mode := core.RootConfig.Algorithm.Classify.Mode
 	processResults := make([]*ProcessResult, len(group.Pid))
 	for i := 0; i < len(processResults); i++ {
 		processResults[i] = &ProcessResult{
 			Pid:            group.Pid[i],
 			Characteristic: MemoryCharacteristicToDetermine,
+			Mode:           mode,
+			RestrictedWays: 2,
 		}
 	}
+	switch mode {
+	case core.ClassifyModeModel:
+		c.modelProcesses(ctx, group, processResults)
+	case core.ClassifyModeGentle:
+		c.gentleProcesses(ctx, group, processResults)
+	default:
+		c.probeProcesses(ctx, group, processResults)
+	}
+	return processResults
+}
 
+// 将进程组限制到2个way测量一次，再恢复全部way测量一次
+func (c *impl) probeProcesses(ctx context.Context, group *core.ProcessGroup, processResults []*ProcessResult) {
 	c.logger.Printf("正在对进程组 %s 进行缓存way为2的perf stat", group.Id)
 	err := pqos.SetCLOSScheme([]*pqos.CLOSScheme{
 		{
@@ -109,9 +166,9 @@ func (c *impl) perfProcesses(ctx context.Context, group *core.ProcessGroup) []*P
 		for _, result := range processResults {
 			result.Error = errors.Wrap(err, "无法设置缓存")
 		}
-		return processResults
+		return
 	}
-	perfCh := perf.NewPerfStatRunner(group).Start(ctx)
+	perfCh := perf.NewStatRunnerFromRootConfig(group).Start(ctx)
 	perfResult := <-perfCh
 	for i, pid := range group.Pid {
 		perfProcessResult := perfResult[pid]
@@ -129,68 +186,35 @@ func (c *impl) perfProcesses(ctx context.Context, group *core.ProcessGroup) []*P
 			Processes: group.Pid,
 		},
 	})
-	perfCh = perf.NewPerfStatRunner(group).Start(ctx)
+	start := time.Now()
+	perfCh = perf.NewStatRunnerFromRootConfig(group).Start(ctx)
 	perfResult = <-perfCh
+	duration := time.Since(start)
 	for i, pid := range group.Pid {
 		perfProcessResult := perfResult[pid]
 		if perfProcessResult.Error != nil {
 			processResults[i].Error = perfProcessResult.Error
 		} else {
 			processResults[i].StatResultAllWays = perfProcessResult
+			processResults[i].Duration = duration
 		}
 	}
-	return processResults
 }
 
+// 判断进程的内存特征，并将判断的依据保存到p.Explanation
 func (c *impl) determineCharacteristic(p *ProcessResult) MemoryCharacteristic {
-	all := p.StatResultAllWays
-	two := p.StatResultTwoWays
-	config := core.RootConfig.Algorithm.Classify
-	bully := func() bool {
-		// 与论文一致
-		ipcVeryLow := all.InstructionPerCycle() < config.IPCVeryLow || two.InstructionPerCycle() < config.IPCVeryLow
-		allHigh := all.LLCMissPerKiloInstructions() >= config.MPKIVeryHigh && all.LLCHitPerKiloInstructions() >= config.HPKIVeryHigh
-		twoHigh := two.LLCMissPerKiloInstructions() >= config.MPKIVeryHigh && two.LLCHitPerKiloInstructions() >= config.HPKIVeryHigh
-		return ipcVeryLow && allHigh || twoHigh
-	}
-	squanderer := func() bool {
-		// 与论文一致
-		return (all.LLCHitPerKiloInstructions() < config.HPKIVeryLow && all.LLCMissPerKiloInstructions() >= config.MPKIHigh) ||
-			(two.LLCHitPerKiloInstructions() < config.HPKIVeryLow && two.LLCMissPerKiloInstructions() >= config.MPKIHigh)
-	}
-	medium := func() bool {
-		ipcMedium := all.InstructionPerCycle() >= config.IPCLow
-		missRateDownSignificant := (two.LLCMissRate()-all.LLCMissRate())/two.LLCMissRate() > config.SignificantChangeThreshold
-		ipcUp := (all.InstructionPerCycle()-two.InstructionPerCycle())/two.InstructionPerCycle() >= config.NoChangeThreshold
-		return ipcMedium && missRateDownSignificant || ipcUp
-	}
-	sensitive := func() bool {
-		ipcLow := all.InstructionPerCycle() < config.IPCLow
-		missRateDownSignificant := (two.LLCMissRate()-all.LLCMissRate())/two.LLCMissRate() > config.SignificantChangeThreshold
-		ipcUp := (all.InstructionPerCycle()-two.InstructionPerCycle())/two.InstructionPerCycle() >= config.NoChangeThreshold
-		return ipcLow && missRateDownSignificant || ipcUp
-	}
-	nonCritical := func() bool {
-		// APKI小于1，IPC基本不变
-		apkiLow := all.AccessLLCPerInstructions()*1000.0 < config.APKILow || two.AccessLLCPerInstructions()*1000.0 < config.APKILow
-		ipcNonChange := math.Abs(all.InstructionPerCycle()-two.InstructionPerCycle())/all.InstructionPerCycle() < config.NoChangeThreshold
-		return apkiLow && ipcNonChange
+	p.Explanation = c.explainer.Explain(p.StatResultAllWays, p.StatResultTwoWays, core.RootConfig.Algorithm.Classify)
+	if p.Explanation.Default {
+		c.logger.Printf("进程 %d 没有分类，暂定为%s", p.StatResultAllWays.Pid, p.Explanation.Characteristic)
 	}
+	return p.Explanation.Characteristic
+}
 
-	if bully() {
-		return MemoryCharacteristicBully
-	} else if squanderer() {
-		return MemoryCharacteristicSquanderer
-	} else if nonCritical() {
-		return MemoryCharacteristicNonCritical
-	} else if medium() {
-		return MemoryCharacteristicMedium
-	} else if sensitive() {
-		return MemoryCharacteristicSensitive
-	} else {
-		c.logger.Printf("进程 %d 没有分类，暂定为non critical", all.Pid)
-		return MemoryCharacteristicNonCritical
-	}
+// 由全部way时的计数估计进程的内存带宽，并判断带宽强度
+func (c *impl) determineBandwidth(p *ProcessResult) {
+	_, _, lineBytes := utils.GetL3Cap()
+	p.Bandwidth = EstimateBandwidth(p.StatResultAllWays, p.Duration, lineBytes)
+	p.BandwidthIntensity = bandwidthIntensity(p.Bandwidth, core.RootConfig.Algorithm.Classify.BandwidthHigh)
 }
 
 var _ Classifier = &impl{}
@@ -0,0 +1,118 @@
+package classifier
+
+import (
+	"context"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/pqos"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/packagewjx/resourcemanager/internal/utils"
+	"math"
+	"time"
+)
+
+// 只在全部way时测量，不限制缓存。2个way时的计数优先由MRC估计，没有MRC时由CMT测得的缓存占用估计，
+// 两者都没有时假设进程占满了整个LLC
+func (c *impl) modelProcesses(ctx context.Context, group *core.ProcessGroup, processResults []*ProcessResult) {
+	numWays, numSets, lineBytes := utils.GetL3Cap()
+	mrcs := map[int][]float32{}
+	monitors := map[int]*pqos.OccupancyMonitor{}
+	for _, pid := range group.Pid {
+		if c.config.MRCProvider != nil {
+			if mrc := c.config.MRCProvider(pid); len(mrc) > 0 {
+				mrcs[pid] = mrc
+				continue
+			}
+		}
+		m, err := pqos.StartOccupancyMonitor(pid)
+		if err != nil {
+			c.logger.Printf("无法监控进程组 %s 进程 %d 的缓存占用：%v", group.Id, pid, err)
+			continue
+		}
+		monitors[pid] = m
+	}
+
+	c.logger.Printf("正在对进程组 %s 进行全缓存way perf stat", group.Id)
+	start := time.Now()
+	perfResult := <-perf.NewStatRunnerFromRootConfig(group).Start(ctx)
+	duration := time.Since(start)
+	restrictedLines := 2 * numSets
+	for i, pid := range group.Pid {
+		occupancyLines := numWays * numSets
+		if m, ok := monitors[pid]; ok {
+			if occupancy, err := m.Read(); err != nil {
+				c.logger.Printf("读取进程组 %s 进程 %d 的缓存占用出错：%v", group.Id, pid, err)
+			} else {
+				occupancyLines = int(occupancy / uint64(lineBytes))
+			}
+			if err := m.Stop(); err != nil {
+				c.logger.Printf("删除进程 %d 的监控组出错：%v", pid, err)
+			}
+		}
+
+		stat := perfResult[pid]
+		if stat.Error != nil {
+			processResults[i].Error = stat.Error
+			continue
+		}
+		var missRate float64
+		if mrc, ok := mrcs[pid]; ok {
+			missRate = mrcRestrictedMissRate(mrc, stat.LLCMissRate(), restrictedLines, numWays*numSets)
+		} else {
+			missRate = occupancyRestrictedMissRate(stat.LLCMissRate(), occupancyLines, restrictedLines)
+		}
+		processResults[i].StatResultAllWays = stat
+		processResults[i].Duration = duration
+		processResults[i].StatResultTwoWays = estimateRestrictedStat(stat, missRate)
+		processResults[i].TwoWaysEstimated = true
+	}
+}
+
+// 由MRC估计缓存只有restrictedLines个缓存行时的缺失率。MRC在全部缓存时的缺失率不为0时，按MRC的比例缩放测得的缺失率，
+// 以修正MRC采样的误差
+func mrcRestrictedMissRate(mrc []float32, missRate float64, restrictedLines, allLines int) float64 {
+	at := func(lines int) float64 {
+		if lines >= len(mrc) {
+			lines = len(mrc) - 1
+		}
+		return float64(mrc[lines])
+	}
+	restricted, all := at(restrictedLines), at(allLines)
+	if all > 0 && !math.IsNaN(missRate) {
+		return restricted * missRate / all
+	}
+	return restricted
+}
+
+// 按缺失率与缓存大小的平方根成反比的经验规律，由占用occupancyLines个缓存行时的缺失率估计缓存只有restrictedLines个缓存行时的缺失率。
+// 占用不超过restrictedLines时缺失率不变
+func occupancyRestrictedMissRate(missRate float64, occupancyLines, restrictedLines int) float64 {
+	if occupancyLines <= restrictedLines || math.IsNaN(missRate) {
+		return missRate
+	}
+	return missRate * math.Sqrt(float64(occupancyLines)/float64(restrictedLines))
+}
+
+// 由全部way时的计数估计LLC缺失率变为missRate时的计数。访问次数与指令数不变，
+// 增加的缺失每次增加平均缺失延迟的周期，与DCAPS预测IPC所用的CPI模型一致
+func estimateRestrictedStat(all *perf.StatResult, missRate float64) *perf.StatResult {
+	res := all.Clone().(*perf.StatResult)
+	accesses := all.LLCHit + all.LLCMiss
+	if accesses == 0 || math.IsNaN(missRate) {
+		return res
+	}
+	// 缓存变小时缺失率不会下降
+	missRate = math.Min(1, math.Max(missRate, all.LLCMissRate()))
+	res.LLCMiss = uint64(math.Round(float64(accesses) * missRate))
+	res.LLCHit = accesses - res.LLCMiss
+
+	latency := all.AverageCacheMissLatency()
+	if all.LLCMiss == 0 || math.IsNaN(latency) || math.IsInf(latency, 0) || latency <= 0 {
+		_, _, l3Lat, memLat := utils.GetMemAccessLatency()
+		latency = float64(memLat - l3Lat)
+	}
+	extra := uint64(float64(res.LLCMiss-all.LLCMiss) * latency)
+	res.Cycles += extra
+	res.MemAnyCycles += extra
+	res.LLCMissCycles += extra
+	return res
+}
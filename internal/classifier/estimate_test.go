@@ -0,0 +1,114 @@
+package classifier
+
+import (
+	"context"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRestrictedMissRate(t *testing.T) {
+	mrc := []float32{1, 0.8, 0.6, 0.4, 0.2}
+	assert.InDelta(t, 0.6, mrcRestrictedMissRate(mrc, 0.2, 2, 4), 1e-6)
+	// 按MRC的比例缩放测得的缺失率
+	assert.InDelta(t, 0.3, mrcRestrictedMissRate(mrc, 0.1, 2, 4), 1e-6)
+	assert.InDelta(t, 0.6, mrcRestrictedMissRate(mrc, 0.1, 2, 100)*2, 1e-6)
+	assert.InDelta(t, 0.8, mrcRestrictedMissRate([]float32{1, 0.8, 0}, 0.1, 1, 2), 1e-6)
+
+	assert.Equal(t, 0.1, occupancyRestrictedMissRate(0.1, 100, 200))
+	assert.InDelta(t, 0.2, occupancyRestrictedMissRate(0.1, 800, 200), 1e-9)
+	assert.True(t, math.IsNaN(occupancyRestrictedMissRate(math.NaN(), 800, 200)))
+}
+
+func TestEstimateRestrictedStat(t *testing.T) {
+	all := &perf.StatResult{
+		Pid:           1,
+		Instructions:  1000000,
+		Cycles:        1000000,
+		AllLoads:      250000,
+		AllStores:     100000,
+		MemAnyCycles:  400000,
+		LLCMissCycles: 200000,
+		LLCHit:        9000,
+		LLCMiss:       1000,
+	}
+	two := estimateRestrictedStat(all, 0.3)
+	assert.Equal(t, uint64(3000), two.LLCMiss)
+	assert.Equal(t, uint64(7000), two.LLCHit)
+	assert.Equal(t, all.Instructions, two.Instructions)
+	// 每次缺失200个周期
+	assert.Equal(t, uint64(1400000), two.Cycles)
+	assert.Equal(t, uint64(600000), two.LLCMissCycles)
+	assert.Less(t, two.InstructionPerCycle(), all.InstructionPerCycle())
+	assert.Equal(t, uint64(1000), all.LLCMiss)
+
+	// 缺失率不会低于测得的缺失率
+	same := estimateRestrictedStat(all, 0.01)
+	assert.Equal(t, all.LLCMiss, same.LLCMiss)
+	assert.Equal(t, all.Cycles, same.Cycles)
+
+	assert.Equal(t, uint64(0), estimateRestrictedStat(&perf.StatResult{Instructions: 100, Cycles: 100}, 0.5).LLCMiss)
+}
+
+func TestModelDetermineCharacteristic(t *testing.T) {
+	c := &impl{logger: log.New(ioutil.Discard, "", 0), explainer: newDefaultRuleSet(t)}
+	all := &perf.StatResult{
+		Instructions:  1000000,
+		Cycles:        500000,
+		AllLoads:      250000,
+		AllStores:     100000,
+		MemAnyCycles:  100000,
+		LLCMissCycles: 40000,
+		LLCHit:        4000,
+		LLCMiss:       200,
+	}
+	// 占用远大于2个way时缓存敏感
+	result := &ProcessResult{StatResultAllWays: all, StatResultTwoWays: estimateRestrictedStat(all,
+		occupancyRestrictedMissRate(all.LLCMissRate(), 16*20480, 2*20480))}
+	assert.Equal(t, MemoryCharacteristicMedium, c.determineCharacteristic(result))
+	// 2个way已经足够时不敏感
+	result.StatResultTwoWays = estimateRestrictedStat(all, occupancyRestrictedMissRate(all.LLCMissRate(), 100, 2*20480))
+	assert.NotEqual(t, MemoryCharacteristicMedium, c.determineCharacteristic(result))
+	assert.NotEqual(t, MemoryCharacteristicSensitive, c.determineCharacteristic(result))
+}
+
+func TestGentleWaySteps(t *testing.T) {
+	assert.Equal(t, []int{7, 4, 2}, gentleWaySteps(11))
+	assert.Equal(t, []int{13, 8, 5, 3, 2}, gentleWaySteps(20))
+	assert.Equal(t, []int{2}, gentleWaySteps(2))
+	assert.Equal(t, core.ClassifyModeProbe, core.RootConfig.Algorithm.Classify.Mode)
+}
+
+func TestGentleProcessesWaitCancelled(t *testing.T) {
+	// 其他进程组正在减少way时等待，被结束时不修改CLOS
+	gentleSemaphore <- struct{}{}
+	defer func() {
+		<-gentleSemaphore
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := []*ProcessResult{{Pid: 1}, {Pid: 2}}
+	(&impl{}).gentleProcesses(ctx, &core.ProcessGroup{Id: "test", Pid: []int{1, 2}}, results)
+	for _, result := range results {
+		assert.Error(t, result.Error)
+		assert.Nil(t, result.StatResultAllWays)
+	}
+}
+
+func TestNextIntervalAfter(t *testing.T) {
+	changed := time.Now()
+	ch := make(chan *perf.IntervalResult, 3)
+	ch <- &perf.IntervalResult{Start: changed.Add(-time.Second), End: changed.Add(time.Second)}
+	ch <- &perf.IntervalResult{Start: changed.Add(time.Second), End: changed.Add(2 * time.Second)}
+	close(ch)
+	res, ok := nextIntervalAfter(ch, changed)
+	assert.True(t, ok)
+	assert.Equal(t, changed.Add(time.Second), res.Start)
+	_, ok = nextIntervalAfter(ch, changed)
+	assert.False(t, ok)
+}
@@ -0,0 +1,62 @@
+package classifier
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 指标的值。计数为0时部分指标为NaN，输出JSON时为null
+type MetricValue float64
+
+func (v MetricValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// 一次指标与阈值的比较
+type Comparison struct {
+	Metric    string      `json:"metric"`
+	Value     MetricValue `json:"value"`
+	Op        string      `json:"op"`
+	Threshold float64     `json:"threshold"`
+	Result    bool        `json:"result"`
+}
+
+func (c Comparison) String() string {
+	return fmt.Sprintf("%s %.3f %s %.3f", c.Metric, c.Value, c.Op, c.Threshold)
+}
+
+// 一条规则的判断过程
+type RuleEvaluation struct {
+	Characteristic MemoryCharacteristic `json:"characteristic"`
+	Expression     string               `json:"expression"`
+	Comparisons    []Comparison         `json:"comparisons"`
+	Matched        bool                 `json:"matched"`
+}
+
+// 分类的依据：用到的指标以及按顺序判断的规则，直到第一条匹配的规则
+type Explanation struct {
+	Characteristic MemoryCharacteristic   `json:"characteristic"`
+	Default        bool                   `json:"default"` // 没有规则匹配，使用默认的分类
+	Metrics        map[string]MetricValue `json:"metrics"`
+	Rules          []*RuleEvaluation      `json:"rules"`
+}
+
+func (e *Explanation) String() string {
+	if e.Default {
+		return fmt.Sprintf("没有匹配的规则，暂定为%s", e.Characteristic)
+	}
+	rule := e.Rules[len(e.Rules)-1]
+	var evidence []string
+	for _, c := range rule.Comparisons {
+		if c.Result {
+			evidence = append(evidence, c.String())
+		}
+	}
+	return fmt.Sprintf("%s（%s）", e.Characteristic, strings.Join(evidence, ", "))
+}
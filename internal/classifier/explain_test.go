@@ -0,0 +1,59 @@
+package classifier
+
+import (
+	"encoding/json"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	config := core.RootConfig.Algorithm.Classify
+	rules := newDefaultRuleSet(t)
+	bully := &perf.StatResult{Instructions: 1000000, Cycles: 2000000, LLCHit: 20000, LLCMiss: 20000}
+	e := rules.Explain(bully, bully, config)
+	assert.Equal(t, MemoryCharacteristicBully, e.Characteristic)
+	assert.False(t, e.Default)
+	if assert.Len(t, e.Rules, 1) {
+		assert.True(t, e.Rules[0].Matched)
+		assert.Len(t, e.Rules[0].Comparisons, 6)
+		assert.Equal(t, Comparison{Metric: "all.ipc", Value: 0.5, Op: "<", Threshold: config.IPCVeryLow, Result: true},
+			e.Rules[0].Comparisons[0])
+	}
+	assert.Equal(t, MetricValue(20), e.Metrics["all.mpki"])
+	assert.Contains(t, e.String(), "all.mpki 20.000 >= 10.000")
+
+	// 依次判断每条规则，直到第一条匹配的规则
+	all := &perf.StatResult{Instructions: 1000000, Cycles: 500000, LLCHit: 4000, LLCMiss: 200}
+	two := &perf.StatResult{Instructions: 1000000, Cycles: 700000, LLCHit: 3000, LLCMiss: 1200}
+	e = rules.Explain(all, two, config)
+	assert.Equal(t, MemoryCharacteristicMedium, e.Characteristic)
+	if assert.Len(t, e.Rules, 4) {
+		for i, c := range []MemoryCharacteristic{MemoryCharacteristicBully, MemoryCharacteristicSquanderer,
+			MemoryCharacteristicNonCritical, MemoryCharacteristicMedium} {
+			assert.Equal(t, c, e.Rules[i].Characteristic)
+			assert.Equal(t, i == 3, e.Rules[i].Matched)
+		}
+	}
+}
+
+func TestExplainDefault(t *testing.T) {
+	// IPC很低但缓存不敏感，没有规则匹配
+	rules := newDefaultRuleSet(t)
+	stat := &perf.StatResult{Instructions: 1000000, Cycles: 1000000, LLCHit: 3000, LLCMiss: 100}
+	e := rules.Explain(stat, stat, core.RootConfig.Algorithm.Classify)
+	assert.True(t, e.Default)
+	assert.Equal(t, MemoryCharacteristicNonCritical, e.Characteristic)
+	assert.Len(t, e.Rules, 5)
+	assert.Contains(t, e.String(), "没有匹配的规则")
+
+	// 没有LLC访问时缺失率为NaN，JSON中为null
+	empty := &perf.StatResult{Instructions: 1000000, Cycles: 1000000}
+	e = rules.Explain(empty, empty, core.RootConfig.Algorithm.Classify)
+	content, err := json.Marshal(e)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `"all.missRate":null`)
+	assert.Equal(t, MemoryCharacteristicNonCritical, e.Characteristic)
+	assert.False(t, e.Default)
+}
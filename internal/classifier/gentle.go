@@ -0,0 +1,160 @@
+package classifier
+
+import (
+	"context"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/pqos"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/packagewjx/resourcemanager/internal/utils"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// gentle模式逐步减少way时使用的CLOS，是8个CLOS中的最后一个。gentle模式下ResourceManager不分配此CLOS，
+// 逐步减少way不会改变其他进程所在CLOS的设置
+const GentleCLOS = 7
+
+// GentleCLOS只有一组设置，同时只能有一个进程组逐步减少way
+var gentleSemaphore = make(chan struct{}, 1)
+
+// 先在全部way时测量一个间隔，然后每次减少约三分之一的way并在设置后测量一个完整的间隔，直到2个way。任意进程的IPC
+// 相对全部way时下降超过GentleMaxIPCDrop时停止减少，以最后一次测量的计数作为限制way时的计数。结束后恢复进程原来的CLOS
+func (c *impl) gentleProcesses(ctx context.Context, group *core.ProcessGroup, processResults []*ProcessResult) {
+	config := core.RootConfig.Algorithm.Classify
+	numWays, _, _ := utils.GetL3Cap()
+	select {
+	case gentleSemaphore <- struct{}{}:
+	case <-ctx.Done():
+		for _, result := range processResults {
+			result.Error = fmt.Errorf("等待其他进程组减少way时被结束")
+		}
+		return
+	}
+	defer func() {
+		<-gentleSemaphore
+	}()
+	childCtx, cancel := context.WithCancel(ctx)
+	ch := perf.NewIntervalStatRunnerFromRootConfig(group).StartInterval(childCtx, config.GentleStepTime)
+	defer func() {
+		cancel()
+		for range ch {
+		}
+	}()
+
+	c.logger.Printf("正在对进程组 %s 进行全缓存way perf stat", group.Id)
+	res, ok := <-ch
+	if !ok {
+		for _, result := range processResults {
+			result.Error = fmt.Errorf("计数提前结束")
+		}
+		return
+	}
+	baseline := make([]float64, len(group.Pid))
+	for i, pid := range group.Pid {
+		stat := res.Results[pid]
+		if stat == nil {
+			processResults[i].Error = fmt.Errorf("没有进程 %d 的计数", pid)
+		} else if stat.Error != nil {
+			processResults[i].Error = stat.Error
+		} else {
+			processResults[i].StatResultAllWays = stat
+			processResults[i].Duration = res.End.Sub(res.Start)
+			baseline[i] = stat.InstructionPerCycle()
+		}
+	}
+
+	originalClos := make(map[int][]int)
+	for _, pid := range group.Pid {
+		clos, err := pqos.GetProcessCLOS(pid)
+		if err != nil {
+			c.logger.Printf("无法读取进程 %d 原来的CLOS，结束后放回CLOS 0：%v", pid, err)
+		}
+		originalClos[clos] = append(originalClos[clos], pid)
+	}
+	defer c.restoreCLOS(group, originalClos)
+
+	for _, ways := range gentleWaySteps(numWays) {
+		c.logger.Printf("正在对进程组 %s 进行缓存way为%d的perf stat", group.Id, ways)
+		changed := time.Now()
+		err := pqos.SetCLOSScheme([]*pqos.CLOSScheme{
+			{
+				CLOSNum:     GentleCLOS,
+				WayBit:      utils.GetLowestBits(ways),
+				MemThrottle: 0,
+				Processes:   group.Pid,
+			},
+		})
+		if err != nil {
+			c.logger.Printf("无法将进程组 %s 限制到%d个way：%v", group.Id, ways, err)
+			for _, result := range processResults {
+				if result.Error == nil && result.StatResultTwoWays == nil {
+					result.Error = errors.Wrap(err, "无法设置缓存")
+				}
+			}
+			break
+		}
+		res, ok = nextIntervalAfter(ch, changed)
+		if !ok {
+			break
+		}
+		dropped := false
+		for i, pid := range group.Pid {
+			result := processResults[i]
+			stat := res.Results[pid]
+			if result.Error != nil || stat == nil {
+				continue
+			}
+			if stat.Error != nil {
+				result.Error = stat.Error
+				continue
+			}
+			result.StatResultTwoWays = stat
+			result.RestrictedWays = ways
+			if ipc := stat.InstructionPerCycle(); ipc < baseline[i]*(1-config.GentleMaxIPCDrop) {
+				c.logger.Printf("进程组 %s 进程 %d 在%d个way时IPC从 %.3f 下降到 %.3f，停止减少way", group.Id, pid, ways,
+					baseline[i], ipc)
+				dropped = true
+			}
+		}
+		if dropped {
+			break
+		}
+	}
+
+	for _, result := range processResults {
+		if result.Error == nil && result.StatResultTwoWays == nil {
+			result.Error = fmt.Errorf("没有限制way时的计数")
+		}
+	}
+}
+
+// 读取完全在changed之后开始的间隔。设置CLOS时正在进行的间隔混合了设置前后的计数，需要丢弃
+func nextIntervalAfter(ch <-chan *perf.IntervalResult, changed time.Time) (*perf.IntervalResult, bool) {
+	for res := range ch {
+		if !res.Start.Before(changed) {
+			return res, true
+		}
+	}
+	return nil, false
+}
+
+// 将进程放回逐步减少way之前所在的CLOS。WayBit为0，不改变这些CLOS的设置
+func (c *impl) restoreCLOS(group *core.ProcessGroup, originalClos map[int][]int) {
+	schemes := make([]*pqos.CLOSScheme, 0, len(originalClos))
+	for clos, pids := range originalClos {
+		schemes = append(schemes, &pqos.CLOSScheme{CLOSNum: clos, Processes: pids})
+	}
+	if err := pqos.SetCLOSScheme(schemes); err != nil {
+		c.logger.Printf("无法将进程组 %s 放回原来的CLOS：%v", group.Id, err)
+	}
+}
+
+// 逐步减少的way数，每次减少约三分之一，最后为2
+func gentleWaySteps(numWays int) []int {
+	var steps []int
+	for ways := numWays * 2 / 3; ways > 2; ways = ways * 2 / 3 {
+		steps = append(steps, ways)
+	}
+	return append(steps, 2)
+}
@@ -0,0 +1,98 @@
+package classifier
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+)
+
+// 按配置的策略得到进程组整体的分类，保存到res中。per-process策略下只记录指令数最多的进程。
+// 按cgroup计数时每个进程得到的都是整个cgroup的计数，无法区分进程，因此总是使用aggregate策略
+func (c *impl) determineGroup(res *Result) {
+	res.Policy = core.RootConfig.Algorithm.Classify.GroupPolicy
+	if cgroupScope(res.Group) && res.Policy != core.GroupPolicyAggregate {
+		c.logger.Printf("进程组 %s 按cgroup计数，使用%s策略代替%s策略", res.Group.Id, core.GroupPolicyAggregate, res.Policy)
+		res.Policy = core.GroupPolicyAggregate
+	}
+	var valid []*ProcessResult
+	for _, p := range res.Processes {
+		if p.Error == nil {
+			valid = append(valid, p)
+		}
+	}
+	if len(valid) == 0 {
+		return
+	}
+	dominant := dominantProcess(valid)
+	res.DominantPid = dominant.Pid
+
+	var group *ProcessResult
+	switch res.Policy {
+	case core.GroupPolicyDominant:
+		group = dominant
+	case core.GroupPolicyAggregate:
+		group = aggregateProcesses(res.Group, valid)
+		group.Explanation = c.explainer.Explain(group.StatResultAllWays, group.StatResultTwoWays, core.RootConfig.Algorithm.Classify)
+		group.Characteristic = group.Explanation.Characteristic
+		c.determineBandwidth(group)
+	default:
+		return
+	}
+	res.Characteristic = group.Characteristic
+	res.StatResultAllWays = group.StatResultAllWays
+	res.Explanation = group.Explanation
+	res.Bandwidth = group.Bandwidth
+	res.BandwidthIntensity = group.BandwidthIntensity
+	c.logger.Printf("进程组 %s 按%s策略分类为 %s", res.Group.Id, res.Policy, group.Explanation)
+}
+
+// 全部way时指令数最多的进程，数量相同时取靠前的
+func dominantProcess(results []*ProcessResult) *ProcessResult {
+	dominant := results[0]
+	for _, r := range results[1:] {
+		if r.StatResultAllWays.Instructions > dominant.StatResultAllWays.Instructions {
+			dominant = r
+		}
+	}
+	return dominant
+}
+
+// 将进程的计数相加作为进程组的计数，Pid为0。按cgroup计数时每个进程的结果已经是整个进程组的计数，直接使用其中一个
+func aggregateProcesses(group *core.ProcessGroup, results []*ProcessResult) *ProcessResult {
+	res := &ProcessResult{
+		StatResultAllWays: &perf.StatResult{},
+		StatResultTwoWays: &perf.StatResult{},
+		Mode:              results[0].Mode,
+		RestrictedWays:    results[0].RestrictedWays,
+		TwoWaysEstimated:  results[0].TwoWaysEstimated,
+	}
+	if cgroupScope(group) {
+		results = results[:1]
+	}
+	for _, r := range results {
+		addStat(res.StatResultAllWays, r.StatResultAllWays)
+		addStat(res.StatResultTwoWays, r.StatResultTwoWays)
+		if r.Duration > res.Duration {
+			res.Duration = r.Duration
+		}
+	}
+	return res
+}
+
+// 进程组是否按cgroup整体计数
+func cgroupScope(group *core.ProcessGroup) bool {
+	return core.RootConfig.PerfStat.Scope == core.PerfStatScopeCgroup && group.CgroupPath != ""
+}
+
+func addStat(dst, src *perf.StatResult) {
+	if src == nil {
+		return
+	}
+	dst.AllLoads += src.AllLoads
+	dst.AllStores += src.AllStores
+	dst.Instructions += src.Instructions
+	dst.Cycles += src.Cycles
+	dst.MemAnyCycles += src.MemAnyCycles
+	dst.LLCMissCycles += src.LLCMissCycles
+	dst.LLCHit += src.LLCHit
+	dst.LLCMiss += src.LLCMiss
+}
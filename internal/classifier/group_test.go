@@ -0,0 +1,90 @@
+package classifier
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestDetermineGroup(t *testing.T) {
+	c := &impl{explainer: newDefaultRuleSet(t), logger: log.New(ioutil.Discard, "", 0)}
+	newResult := func() *Result {
+		// 进程1指令数最多且很少访问LLC；两个进程的计数相加后缺失很多
+		processes := []*ProcessResult{
+			{
+				Pid:               1,
+				StatResultAllWays: &perf.StatResult{Pid: 1, Instructions: 2000000, Cycles: 1000000, LLCHit: 500, LLCMiss: 100},
+				StatResultTwoWays: &perf.StatResult{Pid: 1, Instructions: 2000000, Cycles: 1000000, LLCHit: 500, LLCMiss: 100},
+				Duration:          time.Second,
+			},
+			{
+				Pid:               2,
+				StatResultAllWays: &perf.StatResult{Pid: 2, Instructions: 1000000, Cycles: 1000000, LLCHit: 100, LLCMiss: 30000},
+				StatResultTwoWays: &perf.StatResult{Pid: 2, Instructions: 1000000, Cycles: 1000000, LLCHit: 100, LLCMiss: 30000},
+				Duration:          time.Second,
+			},
+			{Pid: 3, Error: assert.AnError},
+		}
+		for _, p := range processes[:2] {
+			p.Characteristic = c.determineCharacteristic(p)
+			c.determineBandwidth(p)
+		}
+		return &Result{Group: &core.ProcessGroup{Id: "group", Pid: []int{1, 2, 3}}, Processes: processes}
+	}
+	oldPolicy := core.RootConfig.Algorithm.Classify.GroupPolicy
+	defer func() {
+		core.RootConfig.Algorithm.Classify.GroupPolicy = oldPolicy
+	}()
+
+	core.RootConfig.Algorithm.Classify.GroupPolicy = core.GroupPolicyPerProcess
+	res := newResult()
+	c.determineGroup(res)
+	assert.Equal(t, 1, res.DominantPid)
+	assert.Equal(t, MemoryCharacteristicToDetermine, res.Characteristic)
+	assert.Nil(t, res.Explanation)
+
+	core.RootConfig.Algorithm.Classify.GroupPolicy = core.GroupPolicyDominant
+	res = newResult()
+	c.determineGroup(res)
+	assert.Equal(t, MemoryCharacteristicNonCritical, res.Characteristic)
+	assert.Same(t, res.Processes[0].StatResultAllWays, res.StatResultAllWays)
+
+	core.RootConfig.Algorithm.Classify.GroupPolicy = core.GroupPolicyAggregate
+	res = newResult()
+	c.determineGroup(res)
+	assert.Equal(t, MemoryCharacteristicSquanderer, res.Characteristic)
+	assert.Equal(t, uint64(3000000), res.StatResultAllWays.Instructions)
+	assert.Equal(t, uint64(30100), res.StatResultAllWays.LLCMiss)
+	assert.False(t, res.Explanation.Default)
+	assert.Equal(t, BandwidthIntensityLow, res.BandwidthIntensity)
+
+	// 按cgroup计数时每个进程的结果已经是整个进程组的计数
+	oldScope := core.RootConfig.PerfStat.Scope
+	defer func() {
+		core.RootConfig.PerfStat.Scope = oldScope
+	}()
+	core.RootConfig.PerfStat.Scope = core.PerfStatScopeCgroup
+	res = newResult()
+	res.Group.CgroupPath = "/sys/fs/cgroup/perf_event/group"
+	c.determineGroup(res)
+	assert.Equal(t, uint64(2000000), res.StatResultAllWays.Instructions)
+
+	// 按cgroup计数时其他策略也按aggregate处理，不会使用每个进程重复的计数
+	core.RootConfig.Algorithm.Classify.GroupPolicy = core.GroupPolicyPerProcess
+	res = newResult()
+	res.Group.CgroupPath = "/sys/fs/cgroup/perf_event/group"
+	c.determineGroup(res)
+	assert.Equal(t, core.GroupPolicyAggregate, res.Policy)
+	assert.NotEqual(t, MemoryCharacteristicToDetermine, res.Characteristic)
+	assert.Equal(t, uint64(2000000), res.StatResultAllWays.Instructions)
+
+	// 没有cgroup的进程组按进程计数，使用配置的策略
+	res = newResult()
+	c.determineGroup(res)
+	assert.Equal(t, core.GroupPolicyPerProcess, res.Policy)
+	assert.Equal(t, MemoryCharacteristicToDetermine, res.Characteristic)
+}
@@ -3,10 +3,11 @@ package classifier
 import "math"
 
 type metricStat struct {
-	data []float64 // 存float32节省空间，精度应该足够使用了
-	sum  float64
-	avg  float64
-	std  float64
+	data  []float64 // 存float32节省空间，精度应该足够使用了
+	sum   float64
+	avg   float64
+	std   float64
+	limit int // 最多保留的数据数量，超过时丢弃最旧的数据。为0时不限制
 }
 
 type dataLevel int
@@ -22,6 +23,10 @@ var (
 func (m *metricStat) addData(data float64) {
 	m.data = append(m.data, data)
 	m.sum += data
+	if m.limit > 0 && len(m.data) > m.limit {
+		m.sum -= m.data[0]
+		m.data = m.data[1:]
+	}
 	m.avg = m.sum / float64(len(m.data))
 	diffSum := float64(0)
 	for _, datum := range m.data {
@@ -33,6 +38,15 @@ func (m *metricStat) addData(data float64) {
 
 func (m *metricStat) dataLevel(data float64) dataLevel {
 	diff := data - m.avg
+	if m.std == 0 {
+		// 所有数据都相同时，只要不同就认为偏离很大
+		if diff < 0 {
+			return dataLevelVeryLow
+		} else if diff > 0 {
+			return dataLevelVeryHigh
+		}
+		return dataLevelNormal
+	}
 	level := diff / m.std
 	if level < -3 {
 		return dataLevelVeryLow
@@ -0,0 +1,283 @@
+package classifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+// 根据全部way与限制way时的计数判断进程的内存特征
+type Explainer interface {
+	Explain(all, two *perf.StatResult, config core.ClassifyConfig) *Explanation
+}
+
+var _ Explainer = &RuleSet{}
+var _ Explainer = &Model{}
+
+// 配置了ModelPath时读取模型，否则编译配置中的规则
+func NewExplainer(config core.ClassifyConfig) (Explainer, error) {
+	if config.ModelPath != "" {
+		return LoadModel(config.ModelPath)
+	}
+	rules, err := NewRuleSet(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "分类规则错误")
+	}
+	return rules, nil
+}
+
+const ModelTypeDecisionTree = "decisionTree"
+
+// 由标注的计数训练得到的决策树，可以序列化为JSON保存。特征为规则中可用的指标
+type Model struct {
+	Type     string     `json:"type"`
+	Features []string   `json:"features"`
+	Root     *ModelNode `json:"root"`
+}
+
+// 决策树的节点。内部节点的特征值小于阈值时进入Left，否则进入Right，NaN进入Right；叶子节点只有Class
+type ModelNode struct {
+	Feature   string               `json:"feature,omitempty"`
+	Threshold float64              `json:"threshold,omitempty"`
+	Left      *ModelNode           `json:"left,omitempty"`
+	Right     *ModelNode           `json:"right,omitempty"`
+	Class     MemoryCharacteristic `json:"class,omitempty"`
+	Samples   int                  `json:"samples"` // 训练时到达该节点的样本数
+}
+
+func (n *ModelNode) isLeaf() bool {
+	return n.Left == nil && n.Right == nil
+}
+
+// 一个标注的训练样本
+type TrainingSample struct {
+	AllWays *perf.StatResult
+	TwoWays *perf.StatResult
+	Label   MemoryCharacteristic
+}
+
+func LoadModel(path string) (*Model, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("读取模型文件 %s 出错", path))
+	}
+	m := &Model{}
+	if err = json.Unmarshal(content, m); err != nil {
+		return nil, errors.Wrap(err, "解析模型出错")
+	}
+	if err = m.validate(); err != nil {
+		return nil, errors.Wrap(err, "模型错误")
+	}
+	return m, nil
+}
+
+func (m *Model) Save(path string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "序列化模型出错")
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+func (m *Model) validate() error {
+	if m.Type != ModelTypeDecisionTree {
+		return fmt.Errorf("不支持的模型类型 %s", m.Type)
+	}
+	features := map[string]struct{}{}
+	for _, feature := range m.Features {
+		if !isRuleMetric(feature) {
+			return fmt.Errorf("未知的特征 %s", feature)
+		}
+		features[feature] = struct{}{}
+	}
+	if m.Root == nil {
+		return fmt.Errorf("没有根节点")
+	}
+	var check func(n *ModelNode) error
+	check = func(n *ModelNode) error {
+		if n.isLeaf() {
+			if n.Class == "" {
+				return fmt.Errorf("叶子节点没有分类")
+			}
+			if !isKnownCharacteristic(n.Class) {
+				return fmt.Errorf("叶子节点的分类 %s 未知", n.Class)
+			}
+			return nil
+		}
+		if n.Left == nil || n.Right == nil {
+			return fmt.Errorf("节点 %s 缺少子节点", n.Feature)
+		}
+		if _, ok := features[n.Feature]; !ok {
+			return fmt.Errorf("节点使用了不在特征列表中的 %s", n.Feature)
+		}
+		if err := check(n.Left); err != nil {
+			return err
+		}
+		return check(n.Right)
+	}
+	return check(m.Root)
+}
+
+// 沿决策树判断进程的内存特征，经过的节点记录为比较，阈值来自模型而不是config
+func (m *Model) Explain(all, two *perf.StatResult, _ core.ClassifyConfig) *Explanation {
+	e := &Explanation{Metrics: make(map[string]MetricValue, len(ruleMetrics))}
+	values := make(map[string]float64, len(ruleMetrics))
+	for _, metric := range ruleMetrics {
+		value := metric.value(all, two)
+		values[metric.name] = value
+		e.Metrics[metric.name] = MetricValue(value)
+	}
+	evaluation := &RuleEvaluation{Expression: "决策树", Matched: true}
+	n := m.Root
+	for !n.isLeaf() {
+		value := values[n.Feature]
+		// 记录实际走过的分支，比较结果总是成立
+		comparison := Comparison{Metric: n.Feature, Value: MetricValue(value), Threshold: n.Threshold, Result: true}
+		if value < n.Threshold {
+			comparison.Op = "<"
+			n = n.Left
+		} else {
+			comparison.Op = ">="
+			n = n.Right
+		}
+		evaluation.Comparisons = append(evaluation.Comparisons, comparison)
+	}
+	evaluation.Characteristic = n.Class
+	e.Characteristic = n.Class
+	e.Rules = []*RuleEvaluation{evaluation}
+	return e
+}
+
+type trainingRow struct {
+	features []float64
+	label    MemoryCharacteristic
+}
+
+// 使用CART算法以基尼不纯度训练决策树。maxDepth为树的最大深度，minSamplesLeaf为每个叶子节点最少的样本数
+func TrainDecisionTree(samples []*TrainingSample, maxDepth, minSamplesLeaf int) (*Model, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("没有训练样本")
+	}
+	if minSamplesLeaf < 1 {
+		minSamplesLeaf = 1
+	}
+	m := &Model{Type: ModelTypeDecisionTree}
+	for _, metric := range ruleMetrics {
+		m.Features = append(m.Features, metric.name)
+	}
+	rows := make([]*trainingRow, len(samples))
+	for i, sample := range samples {
+		if sample.Label == "" {
+			return nil, fmt.Errorf("第 %d 个样本没有标注", i+1)
+		}
+		if !isKnownCharacteristic(sample.Label) {
+			return nil, fmt.Errorf("第 %d 个样本的标注 %s 未知", i+1, sample.Label)
+		}
+		row := &trainingRow{label: sample.Label, features: make([]float64, len(ruleMetrics))}
+		for j, metric := range ruleMetrics {
+			row.features[j] = metric.value(sample.AllWays, sample.TwoWays)
+		}
+		rows[i] = row
+	}
+	m.Root = buildNode(rows, m.Features, maxDepth, minSamplesLeaf)
+	return m, nil
+}
+
+func buildNode(rows []*trainingRow, features []string, depth, minSamplesLeaf int) *ModelNode {
+	counts := countLabels(rows)
+	node := &ModelNode{Class: majorityLabel(counts), Samples: len(rows)}
+	if depth <= 0 || len(counts) == 1 || len(rows) < 2*minSamplesLeaf {
+		return node
+	}
+
+	bestFeature, bestThreshold, bestImpurity := -1, 0.0, gini(counts)*float64(len(rows))
+	for f := range features {
+		sorted := make([]*trainingRow, len(rows))
+		copy(sorted, rows)
+		// NaN排在最后，与判断时进入Right一致
+		sort.Slice(sorted, func(i, j int) bool {
+			a, b := sorted[i].features[f], sorted[j].features[f]
+			return a < b || !math.IsNaN(a) && math.IsNaN(b)
+		})
+		left := map[MemoryCharacteristic]int{}
+		right := countLabels(sorted)
+		for i := 0; i < len(sorted)-1; i++ {
+			left[sorted[i].label]++
+			right[sorted[i].label]--
+			a, b := sorted[i].features[f], sorted[i+1].features[f]
+			if i+1 < minSamplesLeaf || len(sorted)-i-1 < minSamplesLeaf || math.IsNaN(a) || a == b {
+				continue
+			}
+			impurity := gini(left)*float64(i+1) + gini(right)*float64(len(sorted)-i-1)
+			if impurity < bestImpurity-1e-12 {
+				bestFeature, bestImpurity = f, impurity
+				if math.IsNaN(b) {
+					// 所有非NaN的值都进入Left。JSON不能表示无穷大
+					bestThreshold = math.MaxFloat64
+				} else {
+					bestThreshold = (a + b) / 2
+				}
+			}
+		}
+	}
+	if bestFeature < 0 {
+		return node
+	}
+
+	var leftRows, rightRows []*trainingRow
+	for _, row := range rows {
+		if row.features[bestFeature] < bestThreshold {
+			leftRows = append(leftRows, row)
+		} else {
+			rightRows = append(rightRows, row)
+		}
+	}
+	return &ModelNode{
+		Feature:   features[bestFeature],
+		Threshold: bestThreshold,
+		Left:      buildNode(leftRows, features, depth-1, minSamplesLeaf),
+		Right:     buildNode(rightRows, features, depth-1, minSamplesLeaf),
+		Samples:   len(rows),
+	}
+}
+
+func countLabels(rows []*trainingRow) map[MemoryCharacteristic]int {
+	counts := map[MemoryCharacteristic]int{}
+	for _, row := range rows {
+		counts[row.label]++
+	}
+	return counts
+}
+
+func gini(counts map[MemoryCharacteristic]int) float64 {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+	res := 1.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		res -= p * p
+	}
+	return res
+}
+
+// 数量最多的分类，数量相同时取名称最小的，保证训练结果确定
+func majorityLabel(counts map[MemoryCharacteristic]int) MemoryCharacteristic {
+	var best MemoryCharacteristic
+	bestCount := -1
+	for label, count := range counts {
+		if count > bestCount || count == bestCount && label < best {
+			best, bestCount = label, count
+		}
+	}
+	return best
+}
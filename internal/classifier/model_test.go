@@ -0,0 +1,102 @@
+package classifier
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrainDecisionTree(t *testing.T) {
+	var samples []*TrainingSample
+	for i := 0; i < 5; i++ {
+		// IPC低于0.5的为bully，否则LLC访问多的为sensitive，其他为non-critical
+		bully := &perf.StatResult{Instructions: 1000000, Cycles: uint64(2500000 + i*100000), LLCHit: 20000, LLCMiss: 20000}
+		sensitive := &perf.StatResult{Instructions: 1000000, Cycles: uint64(1000000 + i*50000), LLCHit: uint64(8000 + i*500), LLCMiss: 1000}
+		nonCritical := &perf.StatResult{Instructions: 1000000, Cycles: uint64(800000 + i*50000), LLCHit: 100, LLCMiss: 50}
+		samples = append(samples,
+			&TrainingSample{AllWays: bully, TwoWays: bully, Label: MemoryCharacteristicBully},
+			&TrainingSample{AllWays: sensitive, TwoWays: sensitive, Label: MemoryCharacteristicSensitive},
+			&TrainingSample{AllWays: nonCritical, TwoWays: nonCritical, Label: MemoryCharacteristicNonCritical})
+	}
+	model, err := TrainDecisionTree(samples, 4, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, model.validate())
+	config := core.RootConfig.Algorithm.Classify
+	for _, sample := range samples {
+		e := model.Explain(sample.AllWays, sample.TwoWays, config)
+		assert.Equal(t, sample.Label, e.Characteristic)
+		if assert.Len(t, e.Rules, 1) {
+			assert.NotEmpty(t, e.Rules[0].Comparisons)
+		}
+	}
+
+	// 深度为0时只有一个叶子节点，取数量最多的分类，数量相同时取名称最小的
+	model, err = TrainDecisionTree(samples, 0, 1)
+	assert.NoError(t, err)
+	assert.True(t, model.Root.isLeaf())
+	assert.Equal(t, MemoryCharacteristicBully, model.Root.Class)
+
+	_, err = TrainDecisionTree(nil, 4, 2)
+	assert.Error(t, err)
+	_, err = TrainDecisionTree([]*TrainingSample{{AllWays: samples[0].AllWays, TwoWays: samples[0].TwoWays}}, 4, 2)
+	assert.Error(t, err)
+	_, err = TrainDecisionTree([]*TrainingSample{{AllWays: samples[0].AllWays, TwoWays: samples[0].TwoWays,
+		Label: "streaming"}}, 4, 2)
+	assert.Error(t, err)
+}
+
+func TestModelSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "model")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	model := &Model{
+		Type:     ModelTypeDecisionTree,
+		Features: []string{"all.ipc"},
+		Root: &ModelNode{
+			Feature:   "all.ipc",
+			Threshold: 0.5,
+			Left:      &ModelNode{Class: MemoryCharacteristicBully, Samples: 1},
+			Right:     &ModelNode{Class: MemoryCharacteristicNonCritical, Samples: 1},
+			Samples:   2,
+		},
+	}
+	path := filepath.Join(dir, "model.json")
+	assert.NoError(t, model.Save(path))
+	loaded, err := LoadModel(path)
+	assert.NoError(t, err)
+	assert.Equal(t, model, loaded)
+
+	config := core.RootConfig.Algorithm.Classify
+	config.ModelPath = path
+	explainer, err := NewExplainer(config)
+	assert.NoError(t, err)
+	assert.IsType(t, &Model{}, explainer)
+
+	for _, invalid := range []string{
+		`{`,
+		`{"type": "logistic", "features": ["all.ipc"], "root": {"class": "bully"}}`,
+		`{"type": "decisionTree", "features": ["all.cpi"], "root": {"class": "bully"}}`,
+		`{"type": "decisionTree", "features": ["all.ipc"]}`,
+		`{"type": "decisionTree", "features": ["all.ipc"], "root": {"samples": 1}}`,
+		`{"type": "decisionTree", "features": ["all.ipc"], "root": {"feature": "all.ipc", "left": {"class": "bully"}}}`,
+		`{"type": "decisionTree", "features": ["all.ipc"], "root": {"feature": "all.mpki", "left": {"class": "bully"}, "right": {"class": "bully"}}}`,
+		`{"type": "decisionTree", "features": ["all.ipc"], "root": {"feature": "all.ipc", "left": {"class": "bully"}, "right": {"class": "streaming"}}}`,
+	} {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(invalid), 0644))
+		_, err = LoadModel(path)
+		assert.Error(t, err, invalid)
+	}
+	_, err = LoadModel(filepath.Join(dir, "none.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package classifier
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/pkg/errors"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 离线分类的一个进程，由全部way与2个way时分别记录的计数组成
+type OfflineProcess struct {
+	GroupId string
+	Pid     int
+	AllWays *perf.StatResult
+	TwoWays *perf.StatResult
+	Label   MemoryCharacteristic // 全部way的文件中characteristic列的值，用于训练模型，没有该列时为空
+}
+
+type offlineKey struct {
+	groupId string
+	pid     int
+}
+
+type offlineRecord struct {
+	stat  *perf.StatResult
+	label MemoryCharacteristic
+}
+
+// 读取ResourceManager写出的perfstat.csv。文件可能由多次运行追加而成，同一个进程组的同一个进程以最后一条记录为准
+func readPerfStatCSV(r io.Reader) (map[offlineKey]*offlineRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "读取表头出错")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	counters := []struct {
+		column string
+		field  func(stat *perf.StatResult) *uint64
+	}{
+		{"instructions", func(stat *perf.StatResult) *uint64 { return &stat.Instructions }},
+		{"cycles", func(stat *perf.StatResult) *uint64 { return &stat.Cycles }},
+		{"allstores", func(stat *perf.StatResult) *uint64 { return &stat.AllStores }},
+		{"allloads", func(stat *perf.StatResult) *uint64 { return &stat.AllLoads }},
+		{"llcmiss", func(stat *perf.StatResult) *uint64 { return &stat.LLCMiss }},
+		{"llchit", func(stat *perf.StatResult) *uint64 { return &stat.LLCHit }},
+		{"memanycycles", func(stat *perf.StatResult) *uint64 { return &stat.MemAnyCycles }},
+		{"llcmisscycles", func(stat *perf.StatResult) *uint64 { return &stat.LLCMissCycles }},
+	}
+	for _, column := range []string{"groupid", "pid"} {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("缺少列 %s", column)
+		}
+	}
+	for _, counter := range counters {
+		if _, ok := columns[counter.column]; !ok {
+			return nil, fmt.Errorf("缺少列 %s", counter.column)
+		}
+	}
+
+	res := map[offlineKey]*offlineRecord{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("读取第 %d 行出错", line))
+		}
+		if len(record) < len(header) {
+			return nil, fmt.Errorf("第 %d 行列数不足", line)
+		}
+		pid, err := strconv.Atoi(record[columns["pid"]])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("解析第 %d 行的pid出错", line))
+		}
+		stat := &perf.StatResult{Pid: pid}
+		for _, counter := range counters {
+			val, err := strconv.ParseUint(record[columns[counter.column]], 10, 64)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("解析第 %d 行的%s出错", line, counter.column))
+			}
+			*counter.field(stat) = val
+		}
+		r := &offlineRecord{stat: stat}
+		if i, ok := columns["characteristic"]; ok {
+			r.label = MemoryCharacteristic(strings.TrimSpace(record[i]))
+		}
+		res[offlineKey{groupId: record[columns["groupid"]], pid: pid}] = r
+	}
+	return res, nil
+}
+
+// 读取全部way与2个way时的perfstat.csv，按进程组与pid配对。只在一个文件中出现的进程被忽略，结果按进程组与pid排序
+func LoadOfflineProcesses(allWays, twoWays io.Reader) ([]*OfflineProcess, error) {
+	all, err := readPerfStatCSV(allWays)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取全部way的计数出错")
+	}
+	two, err := readPerfStatCSV(twoWays)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取2个way的计数出错")
+	}
+	res := make([]*OfflineProcess, 0, len(all))
+	for key, allRecord := range all {
+		twoRecord, ok := two[key]
+		if !ok {
+			continue
+		}
+		res = append(res, &OfflineProcess{
+			GroupId: key.groupId,
+			Pid:     key.pid,
+			AllWays: allRecord.stat,
+			TwoWays: twoRecord.stat,
+			Label:   allRecord.label,
+		})
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("两个文件中没有相同的进程")
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].GroupId != res[j].GroupId {
+			return res[i].GroupId < res[j].GroupId
+		}
+		return res[i].Pid < res[j].Pid
+	})
+	return res, nil
+}
+
+// 按字段名设置ClassifyConfig中的阈值，不区分大小写，与配置文件中的键相同
+func SetThreshold(config *core.ClassifyConfig, name string, value float64) error {
+	val := reflect.ValueOf(config).Elem()
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		if !strings.EqualFold(typ.Field(i).Name, name) {
+			continue
+		}
+		if typ.Field(i).Type.Kind() != reflect.Float64 {
+			return fmt.Errorf("%s 不是阈值", typ.Field(i).Name)
+		}
+		val.Field(i).SetFloat(value)
+		return nil
+	}
+	return fmt.Errorf("没有阈值 %s", name)
+}
@@ -0,0 +1,58 @@
+package classifier
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const offlineHeader = "groupId,pid,instructions,cycles,allStores,allLoads,LLCMiss,LLCHit,MemAnyCycles,LLCMissCycles,characteristic\n"
+
+func TestLoadOfflineProcesses(t *testing.T) {
+	all := offlineHeader +
+		"b,2,1000000,500000,100000,250000,200,4000,0,0,medium\n" +
+		"a,1,1000000,2000000,0,0,0,0,0,0,non-critical\n" +
+		"a,1,1000000,2000000,0,0,20000,20000,0,0,bully\n" +
+		"c,3,1000000,1000000,0,0,0,0,0,0,non-critical\n"
+	two := offlineHeader +
+		"a,1,1000000,2000000,0,0,20000,20000,0,0,bully\n" +
+		"b,2,1000000,700000,100000,250000,1200,3000,0,0,medium\n"
+	processes, err := LoadOfflineProcesses(strings.NewReader(all), strings.NewReader(two))
+	assert.NoError(t, err)
+	if !assert.Len(t, processes, 2) {
+		return
+	}
+	assert.Equal(t, "a", processes[0].GroupId)
+	assert.Equal(t, 1, processes[0].Pid)
+	// 重复的记录以最后一条为准
+	assert.Equal(t, uint64(20000), processes[0].AllWays.LLCMiss)
+	assert.Equal(t, 2, processes[1].AllWays.Pid)
+	assert.Equal(t, uint64(700000), processes[1].TwoWays.Cycles)
+	assert.Equal(t, uint64(250000), processes[1].TwoWays.AllLoads)
+	assert.Equal(t, MemoryCharacteristicBully, processes[0].Label)
+	assert.Equal(t, MemoryCharacteristicMedium, processes[1].Label)
+
+	config := core.RootConfig.Algorithm.Classify
+	rules := newDefaultRuleSet(t)
+	assert.Equal(t, MemoryCharacteristicBully, rules.Explain(processes[0].AllWays, processes[0].TwoWays, config).Characteristic)
+	assert.Equal(t, MemoryCharacteristicMedium, rules.Explain(processes[1].AllWays, processes[1].TwoWays, config).Characteristic)
+
+	_, err = LoadOfflineProcesses(strings.NewReader(all), strings.NewReader(offlineHeader))
+	assert.Error(t, err)
+	_, err = LoadOfflineProcesses(strings.NewReader("groupId,pid\na,1\n"), strings.NewReader(two))
+	assert.Error(t, err)
+	_, err = LoadOfflineProcesses(strings.NewReader(offlineHeader+"a,x,1,1,1,1,1,1,1,1,bully\n"), strings.NewReader(two))
+	assert.Error(t, err)
+}
+
+func TestSetThreshold(t *testing.T) {
+	config := core.RootConfig.Algorithm.Classify
+	assert.NoError(t, SetThreshold(&config, "ipclow", 2))
+	assert.Equal(t, 2.0, config.IPCLow)
+	assert.Equal(t, 1.3, core.RootConfig.Algorithm.Classify.IPCLow)
+	assert.NoError(t, SetThreshold(&config, "NoChangeThreshold", 0.2))
+	assert.Equal(t, 0.2, config.NoChangeThreshold)
+	assert.Error(t, SetThreshold(&config, "mode", 1))
+	assert.Error(t, SetThreshold(&config, "none", 1))
+}
@@ -0,0 +1,154 @@
+package classifier
+
+import (
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"math"
+	"strings"
+)
+
+// 用于检测阶段变化的指标
+type PhaseMetric string
+
+var (
+	PhaseMetricIPC  PhaseMetric = "ipc"
+	PhaseMetricMPKI PhaseMetric = "mpki"
+	PhaseMetricAPKI PhaseMetric = "apki"
+)
+
+type phaseMetric struct {
+	name  PhaseMetric
+	value func(stat *perf.StatResult) float64
+	floor float64 // 绝对变化小于这个值时不认为偏离，避免指标接近0时微小的变化被当作阶段变化
+}
+
+var phaseMetrics = []phaseMetric{
+	{name: PhaseMetricIPC, value: (*perf.StatResult).InstructionPerCycle, floor: 0.05},
+	{name: PhaseMetricMPKI, value: (*perf.StatResult).LLCMissPerKiloInstructions, floor: 0.5},
+	{name: PhaseMetricAPKI, value: func(stat *perf.StatResult) float64 {
+		return stat.AccessPerInstruction() * 1000
+	}, floor: 5},
+}
+
+// 检测到的阶段变化
+type PhaseChange struct {
+	Metrics  []PhaseMetric           // 偏离基准的指标
+	Baseline map[PhaseMetric]float64 // 原阶段的平均值
+	Current  map[PhaseMetric]float64 // 新阶段的平均值
+}
+
+func (c *PhaseChange) String() string {
+	parts := make([]string, len(c.Metrics))
+	for i, metric := range c.Metrics {
+		parts[i] = fmt.Sprintf("%s %.3f -> %.3f", metric, c.Baseline[metric], c.Current[metric])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// 根据间隔计数检测程序的阶段变化。前Warmup个间隔建立基准，之后每个指标按与基准的偏离程度分级，
+// 连续Confirm个间隔都有指标的偏离达到dataLevelVeryLow或dataLevelVeryHigh时认为进入了新的阶段，
+// 并以这些间隔作为新阶段的基准。偶发的偏离不会加入基准。不是线程安全的
+type PhaseDetector struct {
+	config  core.PhaseDetectionConfig
+	stats   map[PhaseMetric]*metricStat
+	pending []map[PhaseMetric]float64 // 连续偏离基准的间隔的指标
+}
+
+func NewPhaseDetector(config core.PhaseDetectionConfig) *PhaseDetector {
+	if config.Warmup <= 0 {
+		config.Warmup = 1
+	}
+	if config.Confirm <= 0 {
+		config.Confirm = 1
+	}
+	d := &PhaseDetector{config: config}
+	d.Reset()
+	return d
+}
+
+// 清空基准，重新开始建立
+func (d *PhaseDetector) Reset() {
+	d.stats = make(map[PhaseMetric]*metricStat, len(phaseMetrics))
+	for _, metric := range phaseMetrics {
+		d.stats[metric.name] = &metricStat{limit: d.config.Window}
+	}
+	d.pending = nil
+}
+
+func (d *PhaseDetector) baselineSize() int {
+	return len(d.stats[PhaseMetricIPC].data)
+}
+
+// 加入一个间隔的计数，检测到阶段变化时返回变化，否则返回nil。出错或者没有执行指令的间隔被忽略
+func (d *PhaseDetector) Add(stat *perf.StatResult) *PhaseChange {
+	if stat == nil || stat.Error != nil || stat.Instructions == 0 || stat.Cycles == 0 {
+		return nil
+	}
+	values := make(map[PhaseMetric]float64, len(phaseMetrics))
+	for _, metric := range phaseMetrics {
+		values[metric.name] = metric.value(stat)
+	}
+	if d.baselineSize() < d.config.Warmup {
+		d.addBaseline(values)
+		return nil
+	}
+
+	deviated := d.deviatedMetrics(values)
+	if len(deviated) == 0 {
+		d.pending = nil
+		d.addBaseline(values)
+		return nil
+	}
+	d.pending = append(d.pending, values)
+	if len(d.pending) < d.config.Confirm {
+		return nil
+	}
+
+	change := &PhaseChange{
+		Metrics:  deviated,
+		Baseline: map[PhaseMetric]float64{},
+		Current:  map[PhaseMetric]float64{},
+	}
+	for _, metric := range phaseMetrics {
+		change.Baseline[metric.name] = d.stats[metric.name].avg
+	}
+	pending := d.pending
+	d.Reset()
+	for _, v := range pending {
+		d.addBaseline(v)
+	}
+	for _, metric := range phaseMetrics {
+		change.Current[metric.name] = d.stats[metric.name].avg
+	}
+	return change
+}
+
+func (d *PhaseDetector) addBaseline(values map[PhaseMetric]float64) {
+	for name, value := range values {
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			d.stats[name].addData(value)
+		}
+	}
+}
+
+func (d *PhaseDetector) deviatedMetrics(values map[PhaseMetric]float64) []PhaseMetric {
+	var res []PhaseMetric
+	for _, metric := range phaseMetrics {
+		value := values[metric.name]
+		stat := d.stats[metric.name]
+		if math.IsNaN(value) || math.IsInf(value, 0) || len(stat.data) == 0 {
+			continue
+		}
+		level := stat.dataLevel(value)
+		if level != dataLevelVeryLow && level != dataLevelVeryHigh {
+			continue
+		}
+		diff := math.Abs(value - stat.avg)
+		if diff < metric.floor || diff < d.config.MinRelativeChange*math.Abs(stat.avg) {
+			continue
+		}
+		res = append(res, metric.name)
+	}
+	return res
+}
@@ -0,0 +1,89 @@
+package classifier
+
+import (
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func phaseStat(ipc, mpki float64) *perf.StatResult {
+	const instructions = 1000000
+	return &perf.StatResult{
+		Instructions: instructions,
+		Cycles:       uint64(instructions / ipc),
+		LLCMiss:      uint64(mpki * instructions / 1000),
+		AllLoads:     instructions / 4,
+		AllStores:    instructions / 10,
+	}
+}
+
+func newTestPhaseDetector() *PhaseDetector {
+	return NewPhaseDetector(core.PhaseDetectionConfig{
+		Warmup:            4,
+		Window:            10,
+		Confirm:           2,
+		MinRelativeChange: 0.3,
+	})
+}
+
+func TestPhaseDetector(t *testing.T) {
+	d := newTestPhaseDetector()
+	for _, ipc := range []float64{1.0, 1.02, 0.98, 1.01, 0.99, 1.0} {
+		assert.Nil(t, d.Add(phaseStat(ipc, 2)))
+	}
+
+	// 偶发的偏离不是阶段变化，也不加入基准
+	assert.Nil(t, d.Add(phaseStat(2, 2)))
+	assert.Nil(t, d.Add(phaseStat(1, 2)))
+	assert.InDelta(t, 1.0, d.stats[PhaseMetricIPC].avg, 0.02)
+
+	assert.Nil(t, d.Add(phaseStat(1, 20)))
+	change := d.Add(phaseStat(1, 21))
+	if assert.NotNil(t, change) {
+		assert.Equal(t, []PhaseMetric{PhaseMetricMPKI}, change.Metrics)
+		assert.InDelta(t, 2, change.Baseline[PhaseMetricMPKI], 0.01)
+		assert.InDelta(t, 20.5, change.Current[PhaseMetricMPKI], 0.01)
+		assert.Contains(t, change.String(), "mpki")
+	}
+	// 新阶段以偏离的间隔作为基准
+	assert.Equal(t, 2, d.baselineSize())
+	assert.Nil(t, d.Add(phaseStat(1, 20.5)))
+}
+
+func TestPhaseDetectorSmallChange(t *testing.T) {
+	d := newTestPhaseDetector()
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, d.Add(phaseStat(1, 0.1)))
+	}
+	// 基准完全不变时任何变化都超过3倍标准差，但变化太小时不认为偏离
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, d.Add(phaseStat(1.1, 0.3)))
+	}
+}
+
+func TestPhaseDetectorIgnoreInvalid(t *testing.T) {
+	d := newTestPhaseDetector()
+	assert.Nil(t, d.Add(nil))
+	assert.Nil(t, d.Add(&perf.StatResult{Error: fmt.Errorf("进程已经退出")}))
+	assert.Nil(t, d.Add(&perf.StatResult{}))
+	assert.Equal(t, 0, d.baselineSize())
+}
+
+func TestMetricStatLimit(t *testing.T) {
+	m := &metricStat{limit: 3}
+	for _, v := range []float64{1, 2, 3, 4, 5} {
+		m.addData(v)
+	}
+	assert.Equal(t, []float64{3, 4, 5}, m.data)
+	assert.Equal(t, 4.0, m.avg)
+	assert.Equal(t, dataLevelNormal, m.dataLevel(4))
+	assert.Equal(t, dataLevelVeryHigh, m.dataLevel(10))
+
+	m = &metricStat{}
+	m.addData(1)
+	assert.Equal(t, dataLevelNormal, m.dataLevel(1))
+	assert.Equal(t, dataLevelVeryLow, m.dataLevel(0.5))
+	assert.Equal(t, dataLevelVeryHigh, m.dataLevel(1.5))
+}
@@ -0,0 +1,405 @@
+package classifier
+
+import (
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/pkg/errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 规则中可以使用的指标。all为全部way时的计数，two为限制way时的计数
+var ruleMetrics = []struct {
+	name  string
+	value func(all, two *perf.StatResult) float64
+}{
+	{"all.ipc", func(all, _ *perf.StatResult) float64 { return all.InstructionPerCycle() }},
+	{"all.mpki", func(all, _ *perf.StatResult) float64 { return all.LLCMissPerKiloInstructions() }},
+	{"all.hpki", func(all, _ *perf.StatResult) float64 { return all.LLCHitPerKiloInstructions() }},
+	{"all.apki", func(all, _ *perf.StatResult) float64 { return all.AccessLLCPerInstructions() * 1000.0 }},
+	{"all.missRate", func(all, _ *perf.StatResult) float64 { return all.LLCMissRate() }},
+	{"two.ipc", func(_, two *perf.StatResult) float64 { return two.InstructionPerCycle() }},
+	{"two.mpki", func(_, two *perf.StatResult) float64 { return two.LLCMissPerKiloInstructions() }},
+	{"two.hpki", func(_, two *perf.StatResult) float64 { return two.LLCHitPerKiloInstructions() }},
+	{"two.apki", func(_, two *perf.StatResult) float64 { return two.AccessLLCPerInstructions() * 1000.0 }},
+	{"two.missRate", func(_, two *perf.StatResult) float64 { return two.LLCMissRate() }},
+	// 全部way相对限制way时IPC上升的比例
+	{"ipcUp", func(all, two *perf.StatResult) float64 {
+		return (all.InstructionPerCycle() - two.InstructionPerCycle()) / two.InstructionPerCycle()
+	}},
+	// 两次IPC相差的比例
+	{"ipcChange", func(all, two *perf.StatResult) float64 {
+		return math.Abs(all.InstructionPerCycle()-two.InstructionPerCycle()) / all.InstructionPerCycle()
+	}},
+	// 全部way相对限制way时缺失率下降的比例
+	{"missRateDown", func(all, two *perf.StatResult) float64 {
+		return (two.LLCMissRate() - all.LLCMissRate()) / two.LLCMissRate()
+	}},
+}
+
+func isRuleMetric(name string) bool {
+	for _, metric := range ruleMetrics {
+		if metric.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回ClassifyConfig中所有的阈值，键为小写的字段名，与配置文件中的键相同
+func thresholds(config core.ClassifyConfig) map[string]float64 {
+	val := reflect.ValueOf(config)
+	typ := val.Type()
+	res := map[string]float64{}
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).Type.Kind() == reflect.Float64 {
+			res[strings.ToLower(typ.Field(i).Name)] = val.Field(i).Float()
+		}
+	}
+	return res
+}
+
+// 编译后的分类规则，可以被多个协程同时使用
+type RuleSet struct {
+	rules                 []*compiledRule
+	defaultCharacteristic MemoryCharacteristic
+}
+
+type compiledRule struct {
+	characteristic MemoryCharacteristic
+	condition      string
+	expr           ruleExpr
+}
+
+// 编译配置中的分类规则，配置中没有规则时使用core.DefaultClassifyRules。条件的语法为：
+//
+//	expr       = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" expr ")" | comparison
+//	comparison = operand ( "<" | "<=" | ">" | ">=" | "==" | "!=" ) operand
+//	operand    = 指标 | 阈值 | 数字
+//
+// 指标见ruleMetrics，阈值为ClassifyConfig中浮点数字段的名称，不区分大小写。阈值在判断时读取，因此修改阈值不需要重新编译
+func NewRuleSet(config core.ClassifyConfig) (*RuleSet, error) {
+	rules := config.Rules
+	if len(rules) == 0 {
+		rules = core.DefaultClassifyRules
+	}
+	if config.DefaultCharacteristic == "" {
+		return nil, fmt.Errorf("没有设置默认的分类")
+	}
+	if !isKnownCharacteristic(MemoryCharacteristic(config.DefaultCharacteristic)) {
+		return nil, fmt.Errorf("未知的默认分类 %s", config.DefaultCharacteristic)
+	}
+	known := thresholds(config)
+	res := &RuleSet{defaultCharacteristic: MemoryCharacteristic(config.DefaultCharacteristic)}
+	for i, rule := range rules {
+		if rule.Characteristic == "" {
+			return nil, fmt.Errorf("第 %d 条规则没有分类", i+1)
+		}
+		if !isKnownCharacteristic(MemoryCharacteristic(rule.Characteristic)) {
+			return nil, fmt.Errorf("第 %d 条规则的分类 %s 未知", i+1, rule.Characteristic)
+		}
+		expr, err := parseRule(rule.Condition, known)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("第 %d 条规则（%s）的条件错误", i+1, rule.Characteristic))
+		}
+		res.rules = append(res.rules, &compiledRule{
+			characteristic: MemoryCharacteristic(rule.Characteristic),
+			condition:      rule.Condition,
+			expr:           expr,
+		})
+	}
+	return res, nil
+}
+
+// 按顺序判断规则，直到第一条满足的规则，并记录每条规则比较的指标与阈值。阈值使用config中的值
+func (s *RuleSet) Explain(all, two *perf.StatResult, config core.ClassifyConfig) *Explanation {
+	e := &Explanation{Metrics: make(map[string]MetricValue, len(ruleMetrics))}
+	ctx := &ruleContext{metrics: make(map[string]float64, len(ruleMetrics)), thresholds: thresholds(config)}
+	for _, metric := range ruleMetrics {
+		value := metric.value(all, two)
+		ctx.metrics[metric.name] = value
+		e.Metrics[metric.name] = MetricValue(value)
+	}
+	for _, rule := range s.rules {
+		ctx.evaluation = &RuleEvaluation{Characteristic: rule.characteristic, Expression: rule.condition}
+		ctx.evaluation.Matched = rule.expr.eval(ctx)
+		e.Rules = append(e.Rules, ctx.evaluation)
+		if ctx.evaluation.Matched {
+			e.Characteristic = rule.characteristic
+			return e
+		}
+	}
+	e.Characteristic = s.defaultCharacteristic
+	e.Default = true
+	return e
+}
+
+type ruleContext struct {
+	metrics    map[string]float64
+	thresholds map[string]float64
+	evaluation *RuleEvaluation
+}
+
+// 为了记录所有比较的结果，判断时不短路
+type ruleExpr interface {
+	eval(ctx *ruleContext) bool
+}
+
+type ruleOr struct{ left, right ruleExpr }
+
+func (e *ruleOr) eval(ctx *ruleContext) bool {
+	left := e.left.eval(ctx)
+	right := e.right.eval(ctx)
+	return left || right
+}
+
+type ruleAnd struct{ left, right ruleExpr }
+
+func (e *ruleAnd) eval(ctx *ruleContext) bool {
+	left := e.left.eval(ctx)
+	right := e.right.eval(ctx)
+	return left && right
+}
+
+type ruleNot struct{ expr ruleExpr }
+
+func (e *ruleNot) eval(ctx *ruleContext) bool {
+	return !e.expr.eval(ctx)
+}
+
+type operandKind int
+
+const (
+	operandNumber operandKind = iota
+	operandMetric
+	operandThreshold
+)
+
+type ruleOperand struct {
+	kind  operandKind
+	name  string
+	value float64
+}
+
+func (o *ruleOperand) eval(ctx *ruleContext) float64 {
+	switch o.kind {
+	case operandMetric:
+		return ctx.metrics[o.name]
+	case operandThreshold:
+		return ctx.thresholds[o.name]
+	default:
+		return o.value
+	}
+}
+
+type ruleCompare struct {
+	left, right *ruleOperand
+	op          string
+}
+
+func (e *ruleCompare) eval(ctx *ruleContext) bool {
+	left, right := e.left.eval(ctx), e.right.eval(ctx)
+	var result bool
+	switch e.op {
+	case "<":
+		result = left < right
+	case "<=":
+		result = left <= right
+	case ">":
+		result = left > right
+	case ">=":
+		result = left >= right
+	case "==":
+		result = left == right
+	case "!=":
+		result = left != right
+	}
+	ctx.evaluation.Comparisons = append(ctx.evaluation.Comparisons, Comparison{
+		Metric:    e.left.name,
+		Value:     MetricValue(left),
+		Op:        e.op,
+		Threshold: right,
+		Result:    result,
+	})
+	return result
+}
+
+type ruleParser struct {
+	tokens     []string
+	pos        int
+	thresholds map[string]float64
+}
+
+func parseRule(condition string, thresholds map[string]float64) (ruleExpr, error) {
+	tokens, err := tokenizeRule(condition)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("条件为空")
+	}
+	p := &ruleParser{tokens: tokens, thresholds: thresholds}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("多余的 %s", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+var ruleOperators = []string{"&&", "||", "<=", ">=", "==", "!=", "<", ">", "!", "(", ")"}
+
+func tokenizeRule(condition string) ([]string, error) {
+	var tokens []string
+	runes := []rune(condition)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if unicode.IsSpace(r) {
+			i++
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' {
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+			continue
+		}
+		matched := false
+		for _, op := range ruleOperators {
+			if strings.HasPrefix(string(runes[i:]), op) {
+				tokens = append(tokens, op)
+				i += len([]rune(op))
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("无法识别的字符 %c", r)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *ruleParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *ruleParser) next() string {
+	token := p.peek()
+	if token != "" {
+		p.pos++
+	}
+	return token
+}
+
+func (p *ruleParser) parseOr() (ruleExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &ruleOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (ruleExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &ruleAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseUnary() (ruleExpr, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ruleNot{expr: expr}, nil
+	case "(":
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if token := p.next(); token != ")" {
+			return nil, fmt.Errorf("缺少 )")
+		}
+		return expr, nil
+	default:
+		return p.parseComparison()
+	}
+}
+
+func (p *ruleParser) parseComparison() (ruleExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	case "":
+		return nil, fmt.Errorf("%s 之后缺少比较运算符", left.name)
+	default:
+		return nil, fmt.Errorf("%s 之后应为比较运算符，而不是 %s", left.name, op)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &ruleCompare{left: left, right: right, op: op}, nil
+}
+
+func (p *ruleParser) parseOperand() (*ruleOperand, error) {
+	token := p.next()
+	if token == "" {
+		return nil, fmt.Errorf("条件不完整")
+	}
+	if value, err := strconv.ParseFloat(token, 64); err == nil {
+		return &ruleOperand{kind: operandNumber, name: token, value: value}, nil
+	}
+	if isRuleMetric(token) {
+		return &ruleOperand{kind: operandMetric, name: token}, nil
+	}
+	if _, ok := p.thresholds[strings.ToLower(token)]; ok {
+		return &ruleOperand{kind: operandThreshold, name: strings.ToLower(token)}, nil
+	}
+	for _, op := range ruleOperators {
+		if token == op {
+			return nil, fmt.Errorf("%s 处应为指标、阈值或者数字", token)
+		}
+	}
+	return nil, fmt.Errorf("未知的指标或者阈值 %s", token)
+}
@@ -0,0 +1,147 @@
+package classifier
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newDefaultRuleSet(t *testing.T) *RuleSet {
+	rules, err := NewRuleSet(core.RootConfig.Algorithm.Classify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestParseRule(t *testing.T) {
+	known := thresholds(core.RootConfig.Algorithm.Classify)
+	for _, c := range []struct {
+		condition string
+		valid     bool
+	}{
+		{"all.ipc < 1", true},
+		{"all.ipc<ipclow", true},
+		{"all.ipc < IPCLow", true},
+		{"!(all.mpki >= 1e1) && two.apki != 0 || ipcUp <= 0.5", true},
+		{"((missRateDown == 0))", true},
+		{"", false},
+		{"all.ipc", false},
+		{"all.ipc <", false},
+		{"all.ipc < 1 &&", false},
+		{"(all.ipc < 1", false},
+		{"all.ipc < 1)", false},
+		{"all.ipc < 1 two.ipc < 1", false},
+		{"all.cpi < 1", false},
+		{"all.ipc < unknown", false},
+		{"all.ipc = 1", false},
+		{"all.ipc < 1 & two.ipc < 1", false},
+		{"all.ipc < < 1", false},
+	} {
+		_, err := parseRule(c.condition, known)
+		if c.valid {
+			assert.NoError(t, err, c.condition)
+		} else {
+			assert.Error(t, err, c.condition)
+		}
+	}
+}
+
+func TestDefaultRuleSet(t *testing.T) {
+	rules := newDefaultRuleSet(t)
+	config := core.RootConfig.Algorithm.Classify
+	for _, c := range []struct {
+		name      string
+		all, two  *perf.StatResult
+		expected  MemoryCharacteristic
+		isDefault bool
+	}{
+		{
+			name:     "IPC很低且命中与缺失都很多",
+			all:      &perf.StatResult{Instructions: 1000000, Cycles: 2000000, LLCHit: 20000, LLCMiss: 20000},
+			two:      &perf.StatResult{Instructions: 1000000, Cycles: 2000000, LLCHit: 20000, LLCMiss: 20000},
+			expected: MemoryCharacteristicBully,
+		},
+		{
+			name:     "几乎没有命中但缺失很多",
+			all:      &perf.StatResult{Instructions: 1000000, Cycles: 1000000, LLCHit: 100, LLCMiss: 6000},
+			two:      &perf.StatResult{Instructions: 1000000, Cycles: 1000000, LLCHit: 100, LLCMiss: 6000},
+			expected: MemoryCharacteristicSquanderer,
+		},
+		{
+			name:     "很少访问LLC",
+			all:      &perf.StatResult{Instructions: 1000000, Cycles: 500000, LLCHit: 500, LLCMiss: 100},
+			two:      &perf.StatResult{Instructions: 1000000, Cycles: 510000, LLCHit: 400, LLCMiss: 200},
+			expected: MemoryCharacteristicNonCritical,
+		},
+		{
+			name:     "IPC较高且缓存增加时缺失率明显下降",
+			all:      &perf.StatResult{Instructions: 1000000, Cycles: 500000, LLCHit: 4000, LLCMiss: 200},
+			two:      &perf.StatResult{Instructions: 1000000, Cycles: 520000, LLCHit: 3000, LLCMiss: 1200},
+			expected: MemoryCharacteristicMedium,
+		},
+		{
+			name:     "IPC较低且缓存增加时缺失率明显下降",
+			all:      &perf.StatResult{Instructions: 1000000, Cycles: 1000000, LLCHit: 4000, LLCMiss: 200},
+			two:      &perf.StatResult{Instructions: 1000000, Cycles: 1050000, LLCHit: 3000, LLCMiss: 1200},
+			expected: MemoryCharacteristicSensitive,
+		},
+		{
+			name:      "没有规则满足",
+			all:       &perf.StatResult{Instructions: 1000000, Cycles: 1000000, LLCHit: 3000, LLCMiss: 100},
+			two:       &perf.StatResult{Instructions: 1000000, Cycles: 1000000, LLCHit: 3000, LLCMiss: 100},
+			expected:  MemoryCharacteristicNonCritical,
+			isDefault: true,
+		},
+	} {
+		e := rules.Explain(c.all, c.two, config)
+		assert.Equal(t, c.expected, e.Characteristic, c.name)
+		assert.Equal(t, c.isDefault, e.Default, c.name)
+	}
+}
+
+func TestCustomRuleSet(t *testing.T) {
+	config := core.RootConfig.Algorithm.Classify
+	config.Rules = []core.ClassifyRule{
+		{Characteristic: "squanderer", Condition: "all.mpki >= mpkihigh && !(missRateDown > 0.1)"},
+		{Characteristic: "sensitive", Condition: "ipcUp >= 0.2"},
+	}
+	config.DefaultCharacteristic = "medium"
+	rules, err := NewRuleSet(config)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	streaming := &perf.StatResult{Instructions: 1000000, Cycles: 1000000, LLCHit: 1000, LLCMiss: 9000}
+	e := rules.Explain(streaming, streaming, config)
+	assert.Equal(t, MemoryCharacteristicSquanderer, e.Characteristic)
+	if assert.Len(t, e.Rules, 1) {
+		assert.Equal(t, []Comparison{
+			{Metric: "all.mpki", Value: 9, Op: ">=", Threshold: 5, Result: true},
+			{Metric: "missRateDown", Value: 0, Op: ">", Threshold: 0.1, Result: false},
+		}, e.Rules[0].Comparisons)
+	}
+
+	// 阈值在判断时读取
+	config.MPKIHigh = 10
+	e = rules.Explain(streaming, streaming, config)
+	assert.Equal(t, MemoryCharacteristicMedium, e.Characteristic)
+	assert.True(t, e.Default)
+	assert.Len(t, e.Rules, 2)
+
+	for _, invalid := range [][]core.ClassifyRule{
+		{{Characteristic: "", Condition: "all.ipc < 1"}},
+		{{Characteristic: "bully", Condition: "all.ipc < "}},
+		{{Characteristic: "streaming", Condition: "all.ipc < 1"}},
+		{{Characteristic: "Bully", Condition: "all.ipc < 1"}},
+	} {
+		config.Rules = invalid
+		_, err = NewRuleSet(config)
+		assert.Error(t, err)
+	}
+	config.Rules = nil
+	config.DefaultCharacteristic = ""
+	_, err = NewRuleSet(config)
+	assert.Error(t, err)
+}
@@ -32,15 +32,70 @@ var (
 type MicroArchitectureName string
 
 var (
-	MicroArchitectureNameSkyLake     MicroArchitectureName = "SkyLake"
-	MicroArchitectureNameCascadeLake MicroArchitectureName = "CascadeLake"
+	MicroArchitectureNameAuto           MicroArchitectureName = "auto" // 根据/proc/cpuinfo中的处理器型号选择
+	MicroArchitectureNameSkyLake        MicroArchitectureName = "SkyLake"
+	MicroArchitectureNameCascadeLake    MicroArchitectureName = "CascadeLake"
+	MicroArchitectureNameIceLakeSP      MicroArchitectureName = "IceLakeSP"
+	MicroArchitectureNameSapphireRapids MicroArchitectureName = "SapphireRapids"
+	MicroArchitectureNameZen            MicroArchitectureName = "Zen"
+)
+
+// 多线程进程的MRC合成方式
+type MRCCompositionType string
+
+var (
+	MRCCompositionWeighted    MRCCompositionType = "weighted"    // 每个线程单独计算RTH，按指令数加权平均
+	MRCCompositionInterleaved MRCCompositionType = "interleaved" // 所有线程的访问按到达顺序交织，作为一个进程整体计算RTH
+)
+
+// 由RTH计算MRC所使用的模型
+type MRCModelType string
+
+var (
+	MRCModelAET       MRCModelType = "aet"       // Average Eviction Time模型
+	MRCModelFootprint MRCModelType = "footprint" // HOTL平均足迹模型
+)
+
+// 分类时测量性能计数器的方式
+type PerfStatBackend string
+
+var (
+	PerfStatBackendPerf      PerfStatBackend = "perf"      // 对每个进程启动perf stat子进程
+	PerfStatBackendPerfEvent PerfStatBackend = "perfevent" // 在本进程中使用perf_event_open计数，不依赖perf可执行文件
+)
+
+// 分类时计数的范围
+type PerfStatScope string
+
+var (
+	PerfStatScopeProcess PerfStatScope = "process" // 分别对组内每个进程计数
+	PerfStatScopeCgroup  PerfStatScope = "cgroup"  // 对进程组的cgroup整体计数，包括短暂存在的子进程。有cgroup的进程组总是按aggregate策略分类
+)
+
+// 多进程的进程组的分类策略
+type GroupPolicy string
+
+var (
+	GroupPolicyPerProcess GroupPolicy = "per-process" // 每个进程单独分类与分配CLOS
+	GroupPolicyAggregate  GroupPolicy = "aggregate"   // 以组内所有进程的计数之和分类，进程组整体分配CLOS
+	GroupPolicyDominant   GroupPolicy = "dominant"    // 以指令数最多的进程的分类作为进程组的分类，进程组整体分配CLOS
+)
+
+// 分类时测量进程缓存敏感度的方式
+type ClassifyMode string
+
+var (
+	ClassifyModeProbe  ClassifyMode = "probe"  // 将进程组限制到2个way与使用全部way各测量一次
+	ClassifyModeModel  ClassifyMode = "model"  // 只使用全部way测量，根据MRC或者CMT测得的缓存占用估计2个way时的计数，不限制缓存
+	ClassifyModeGentle ClassifyMode = "gentle" // 在单独的CLOS中逐步减少way并测量，IPC下降超过阈值时停止并恢复全部way
 )
 
 type MemTraceSampler string
 
 var (
-	MemTraceSamplerPerf MemTraceSampler = "perf"
-	MemTraceSamplerPin  MemTraceSampler = "pin"
+	MemTraceSamplerPerf      MemTraceSampler = "perf"
+	MemTraceSamplerPin       MemTraceSampler = "pin"
+	MemTraceSamplerPerfEvent MemTraceSampler = "perfevent" // 直接使用perf_event_open进行PEBS采样，不依赖perf可执行文件
 )
 
 type MemTraceConfig struct {
@@ -49,9 +104,30 @@ type MemTraceConfig struct {
 	ConcurrentMax     int
 	RthCalculatorType RthCalculatorType
 	ReservoirSize     int
-	Sampler           MemTraceSampler
+	MRCComposition    MRCCompositionType
+	MRCModel          MRCModelType
+	Sampler           MemTraceSampler // sample等命令使用的采样方式，ResourceManager固定使用Pin
 	PinConfig         PinConfig
 	PerfRecordConfig  PerfRecordConfig
+	PerfEventConfig   PerfEventConfig
+	MRCCache          MRCCacheConfig
+	Limit             TraceLimitConfig
+}
+
+// ResourceManager对运行中的进程进行内存追踪时的限制，达到任意一个限制时停止追踪，使用已经采集的部分计算MRC。为0的项不限制
+type TraceLimitConfig struct {
+	MaxDuration     time.Duration // 追踪的最长时间
+	MaxSlowdown     float64       // 追踪期间进程IPC相对追踪前下降的最大倍数
+	MaxAddresses    uint64        // 采集的地址数量上限
+	MonitorInterval time.Duration // 测量IPC的时间窗口
+}
+
+// 以程序标识（可执行文件路径、内容哈希与参数）为键的持久化MRC缓存。默认关闭，打开时Dir需要对运行的用户可写
+type MRCCacheConfig struct {
+	Enable              bool
+	Dir                 string
+	MaxAge              time.Duration // 超过这个时间的记录视为过期。为0时永不过期
+	RefreshInBackground bool          // 记录过期时先使用旧的MRC，同时在后台重新追踪
 }
 
 type PerfRecordConfig struct {
@@ -60,6 +136,12 @@ type PerfRecordConfig struct {
 	PerfExecPath  string
 }
 
+type PerfEventConfig struct {
+	SamplePeriod uint64        // 每发生这么多次访存事件采样一次
+	Duration     time.Duration // 采样时长，为0时一直采样到进程结束或者达到TraceCount
+	BufferPages  int           // 每个CPU环形缓冲区的页数，必须为2的幂
+}
+
 type PinConfig struct {
 	PinPath        string
 	PinToolPath    string
@@ -70,6 +152,8 @@ type PinConfig struct {
 type PerfStatConfig struct {
 	MicroArchitecture MicroArchitectureName
 	SampleTime        time.Duration
+	Backend           PerfStatBackend
+	Scope             PerfStatScope
 }
 
 type ClassifyConfig struct {
@@ -83,6 +167,46 @@ type ClassifyConfig struct {
 	NoChangeThreshold          float64
 	SignificantChangeThreshold float64
 	APKILow                    float64
+	Mode                       ClassifyMode
+	GentleStepTime             time.Duration  // gentle模式下每次减少way后测量的时间
+	GentleMaxIPCDrop           float64        // gentle模式下IPC相对全部way时下降超过这个比例时停止减少way
+	Rules                      []ClassifyRule // 按顺序判断的分类规则，为空时使用DefaultClassifyRules
+	DefaultCharacteristic      string         // 没有规则满足时的分类
+	ModelPath                  string         // 使用train命令训练的模型文件，不为空时使用模型代替规则分类
+	BandwidthHigh              float64        // 全部way时由LLC缺失估计的内存带宽（MB/s）达到这个值时认为进程是带宽密集的
+	GroupPolicy                GroupPolicy
+}
+
+// 一条分类规则。Condition是对指标与阈值的布尔表达式，阈值使用本配置中的键，如ipclow。
+// 可用的指标与语法见classifier.NewRuleSet
+type ClassifyRule struct {
+	Characteristic string
+	Condition      string
+}
+
+// 默认的分类规则，与论文中的分类方法一致
+var DefaultClassifyRules = []ClassifyRule{
+	{
+		Characteristic: "bully",
+		Condition: "(all.ipc < ipcverylow || two.ipc < ipcverylow) && all.mpki >= mpkiveryhigh && all.hpki >= hpkiveryhigh || " +
+			"two.mpki >= mpkiveryhigh && two.hpki >= hpkiveryhigh",
+	},
+	{
+		Characteristic: "squanderer",
+		Condition:      "all.hpki < hpkiverylow && all.mpki >= mpkihigh || two.hpki < hpkiverylow && two.mpki >= mpkihigh",
+	},
+	{
+		Characteristic: "non-critical",
+		Condition:      "(all.apki < apkilow || two.apki < apkilow) && ipcChange < nochangethreshold",
+	},
+	{
+		Characteristic: "medium",
+		Condition:      "all.ipc >= ipclow && missRateDown > significantchangethreshold || ipcUp >= nochangethreshold",
+	},
+	{
+		Characteristic: "sensitive",
+		Condition:      "all.ipc < ipclow && missRateDown > significantchangethreshold || ipcUp >= nochangethreshold",
+	},
 }
 
 type DCAPSConfig struct {
@@ -116,6 +240,22 @@ type ManagerConfig struct {
 	ChangeProcessCountThreshold int           // 多个进程组更新时，更新的进程的数量达到这个数字时才进行再分配
 	TargetPrograms              []string      // 当使用ProcessWatcher时，监控的目标程序
 	ClassifyAfter               time.Duration // 跳过应用启动的的初始化时间
+	StatInterval                time.Duration // 分类后按这个间隔持续计数，为0时不进行
+	StatHistorySize             int           // 每个进程保留最近的间隔计数的数量
+	StatusFile                  string        // 运行时定期写入分类结果与分类依据的文件，可以用status命令查看。为空时不写入
+	StatusInterval              time.Duration // 写入StatusFile的间隔
+	Phase                       PhaseDetectionConfig
+	BandwidthThrottle           int // 带宽密集且不由DCAPS分配的进程放入单独的CLOS，MBA限制为最大带宽的这个百分比。为0时与其他进程一起放入CLOS 1
+}
+
+// 根据间隔计数检测程序的阶段变化，变化时对进程组重新分类并重新追踪MRC。需要StatInterval大于0
+type PhaseDetectionConfig struct {
+	Enable            bool
+	Warmup            int           // 建立基准所需的间隔数
+	Window            int           // 基准最多保留的间隔数，为0时不限制
+	Confirm           int           // 连续偏离基准的间隔数达到这个数量时认为进入了新的阶段
+	MinRelativeChange float64       // 与基准平均值的相对差小于这个比例时不认为偏离
+	CoolDown          time.Duration // 同一个进程组两次重新分类的最短间隔
 }
 
 type DebugConfig struct {
@@ -129,6 +269,8 @@ var RootConfig = &Config{
 		ConcurrentMax:     int(math.Min(math.Max(1, float64(runtime.NumCPU())/4), 4)),
 		RthCalculatorType: RthCalculatorTypeReservoir,
 		ReservoirSize:     100000,
+		MRCComposition:    MRCCompositionWeighted,
+		MRCModel:          MRCModelAET,
 		Sampler:           MemTraceSamplerPerf,
 		PinConfig: PinConfig{
 			PinPath:        "/home/wjx/bin/pin",
@@ -141,10 +283,29 @@ var RootConfig = &Config{
 			OverflowCount: 5,
 			PerfExecPath:  "/home/wjx/linux-5.4.0/tools/perf",
 		},
+		PerfEventConfig: PerfEventConfig{
+			SamplePeriod: 200,
+			Duration:     0,
+			BufferPages:  64,
+		},
+		MRCCache: MRCCacheConfig{
+			Enable:              false,
+			Dir:                 "/var/lib/resourcemanager/mrc",
+			MaxAge:              7 * 24 * time.Hour,
+			RefreshInBackground: true,
+		},
+		Limit: TraceLimitConfig{
+			MaxDuration:     2 * time.Minute,
+			MaxSlowdown:     20,
+			MaxAddresses:    100000000,
+			MonitorInterval: time.Second,
+		},
 	},
 	PerfStat: PerfStatConfig{
 		SampleTime:        30 * time.Second,
-		MicroArchitecture: MicroArchitectureNameSkyLake,
+		MicroArchitecture: MicroArchitectureNameAuto,
+		Backend:           PerfStatBackendPerfEvent,
+		Scope:             PerfStatScopeProcess,
 	},
 	Algorithm: AlgorithmConfig{
 		Classify: ClassifyConfig{
@@ -158,6 +319,12 @@ var RootConfig = &Config{
 			NoChangeThreshold:          0.1,
 			SignificantChangeThreshold: 0.3,
 			APKILow:                    1,
+			Mode:                       ClassifyModeProbe,
+			GentleStepTime:             5 * time.Second,
+			GentleMaxIPCDrop:           0.15,
+			DefaultCharacteristic:      "non-critical",
+			BandwidthHigh:              2000,
+			GroupPolicy:                GroupPolicyPerProcess,
 		},
 		DCAPS: DCAPSConfig{
 			MaxIteration:                        200,
@@ -178,7 +345,20 @@ var RootConfig = &Config{
 		ChangeProcessCountThreshold: 100, // 暂定
 		TargetPrograms: []string{"blackscholes", "bodytrack", "canneal", "dedup", "facesim", "ferret", "fluidanimate", "freqmine",
 			"rtview", "streamcluster", "swaptions", "vips", "x264"},
-		ClassifyAfter: 5 * time.Second,
+		ClassifyAfter:   5 * time.Second,
+		StatInterval:    5 * time.Second,
+		StatHistorySize: 120,
+		StatusFile:      "resourcemanager.status.json",
+		StatusInterval:  5 * time.Second,
+		Phase: PhaseDetectionConfig{
+			Enable:            true,
+			Warmup:            6,
+			Window:            60,
+			Confirm:           3,
+			MinRelativeChange: 0.3,
+			CoolDown:          5 * time.Minute,
+		},
+		BandwidthThrottle: 50,
 	},
 	Debug: DebugConfig{
 		IgnorePqosError: false,
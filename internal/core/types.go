@@ -1,16 +1,18 @@
 package core
 
 type ProcessGroup struct {
-	Id  string
-	Pid []int
+	Id         string
+	Pid        []int
+	CgroupPath string // 包含组内所有进程的perf_event cgroup目录，为空时只能按进程计数
 }
 
 func (p *ProcessGroup) Clone() Cloneable {
 	cpid := make([]int, len(p.Pid))
 	copy(cpid, p.Pid)
 	return &ProcessGroup{
-		Id:  p.Id,
-		Pid: cpid,
+		Id:         p.Id,
+		Pid:        cpid,
+		CgroupPath: p.CgroupPath,
 	}
 }
 
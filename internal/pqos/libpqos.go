@@ -61,7 +61,7 @@ int set_control_scheme(struct clos_scheme *schemes, int lenSchemes) {
         }
 
         // 设置进程绑定
-        for (int j = 0; j < schemes->lenProcessList; j++) {
+        for (int j = 0; j < schemes[i].lenProcessList; j++) {
             // 这里忽略错误。由于可能会有很大量的PID设置，由一个进程设置错误会导致整个过程结束。比如设置过程中pid进程关闭了，重新设置
             // 又有可能新的进程关闭，可能就会多次重试。
             pqos_alloc_assoc_set_pid(schemes[i].processList[j], schemes[i].closNum);
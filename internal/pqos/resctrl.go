@@ -0,0 +1,134 @@
+package pqos
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const resctrlRoot = "/sys/fs/resctrl"
+
+// 使用resctrl文件系统的监控组读取一个进程的LLC占用（CMT）。监控组建立在进程当前所在的控制组之下，不改变进程的CLOS。
+// 监控组只统计建立之后进程填充的缓存行，因此需要进程运行一段时间后再读取
+type OccupancyMonitor struct {
+	pid int
+	dir string
+}
+
+func StartOccupancyMonitor(pid int) (*OccupancyMonitor, error) {
+	return startOccupancyMonitor(resctrlRoot, pid)
+}
+
+func startOccupancyMonitor(root string, pid int) (*OccupancyMonitor, error) {
+	if _, err := os.Stat(filepath.Join(root, "info", "L3_MON")); err != nil {
+		return nil, errors.Wrap(err, "resctrl不支持L3监控")
+	}
+	ctrlDir, err := findControlGroup(root, pid)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(ctrlDir, "mon_groups", fmt.Sprintf("resourcemanager-%d", pid))
+	if err = os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return nil, errors.Wrap(err, fmt.Sprintf("创建进程 %d 的监控组出错", pid))
+	}
+	m := &OccupancyMonitor{pid: pid, dir: dir}
+
+	taskDir := fmt.Sprintf("/proc/%d/task", pid)
+	tasks, err := ioutil.ReadDir(taskDir)
+	if err != nil {
+		_ = m.Stop()
+		return nil, errors.Wrap(err, fmt.Sprintf("读取%s出错", taskDir))
+	}
+	added := 0
+	for _, task := range tasks {
+		err = ioutil.WriteFile(filepath.Join(dir, "tasks"), []byte(task.Name()), 0644)
+		if err == nil {
+			added++
+		} else if !errors.Is(err, syscall.ESRCH) {
+			// 线程已经退出时忽略
+			_ = m.Stop()
+			return nil, errors.Wrap(err, fmt.Sprintf("将线程 %s 加入监控组出错", task.Name()))
+		}
+	}
+	if added == 0 {
+		_ = m.Stop()
+		return nil, fmt.Errorf("进程 %d 没有可以监控的线程", pid)
+	}
+	return m, nil
+}
+
+// 查找进程所在的控制组目录。默认控制组为resctrl根目录
+func findControlGroup(root string, pid int) (string, error) {
+	dirs := []string{root}
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("读取%s出错", root))
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == "info" || entry.Name() == "mon_groups" || entry.Name() == "mon_data" {
+			continue
+		}
+		dirs = append(dirs, filepath.Join(root, entry.Name()))
+	}
+	for _, dir := range dirs {
+		ok, err := containsTask(filepath.Join(dir, "tasks"), pid)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("进程 %d 不在任何控制组中", pid)
+}
+
+func containsTask(tasksFile string, pid int) (bool, error) {
+	f, err := os.Open(tasksFile)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("打开%s出错", tasksFile))
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if tid, err := strconv.Atoi(strings.TrimSpace(scanner.Text())); err == nil && tid == pid {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// 读取进程在所有L3缓存域上的占用，单位为字节
+func (m *OccupancyMonitor) Read() (uint64, error) {
+	files, err := filepath.Glob(filepath.Join(m.dir, "mon_data", "mon_L3_*", "llc_occupancy"))
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("进程 %d 的监控组没有llc_occupancy", m.pid)
+	}
+	var sum uint64
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("读取%s出错", file))
+		}
+		val, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err != nil {
+			// 硬件无法提供数据时内容为Unavailable
+			return 0, errors.Wrap(err, fmt.Sprintf("解析%s出错", file))
+		}
+		sum += val
+	}
+	return sum, nil
+}
+
+// 删除监控组，进程回到控制组的默认监控
+func (m *OccupancyMonitor) Stop() error {
+	return os.Remove(m.dir)
+}
@@ -0,0 +1,51 @@
+package pqos
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOccupancyMonitor(t *testing.T) {
+	root, err := ioutil.TempDir("", "resctrl")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	pid := os.Getpid()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "info", "L3_MON"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "tasks"), []byte("1\n2\n"), 0644))
+	ctrl := filepath.Join(root, "COS1")
+	assert.NoError(t, os.MkdirAll(filepath.Join(ctrl, "mon_groups"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(ctrl, "tasks"), []byte(fmt.Sprintf("3\n%d\n", pid)), 0644))
+
+	dir, err := findControlGroup(root, pid)
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl, dir)
+	dir, err = findControlGroup(root, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, root, dir)
+	_, err = findControlGroup(root, 4)
+	assert.Error(t, err)
+
+	m, err := startOccupancyMonitor(root, pid)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, filepath.Join(ctrl, "mon_groups", fmt.Sprintf("resourcemanager-%d", pid)), m.dir)
+	_, err = m.Read()
+	assert.Error(t, err)
+
+	for i, val := range []string{"1048576\n", "524288\n"} {
+		domain := filepath.Join(m.dir, "mon_data", fmt.Sprintf("mon_L3_%02d", i))
+		assert.NoError(t, os.MkdirAll(domain, 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(domain, "llc_occupancy"), []byte(val), 0644))
+	}
+	occupancy, err := m.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1572864), occupancy)
+
+	_, err = startOccupancyMonitor(filepath.Join(root, "none"), pid)
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+package resourcemanager
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/algorithm"
+	"github.com/packagewjx/resourcemanager/internal/classifier"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/pqos"
+	"github.com/packagewjx/resourcemanager/internal/utils"
+)
+
+const (
+	numClos    = 8
+	sharedClos = 1 // 不需要缓存的进程共享2个way的CLOS
+)
+
+var sharedWayBit = utils.GetLowestBits(2)
+
+// 再分配可以使用的CLOS数量。gentle模式下最后一个CLOS留给分类时逐步减少way
+func allocatableClos(mode core.ClassifyMode) int {
+	if mode == core.ClassifyModeGentle {
+		return classifier.GentleCLOS
+	}
+	return numClos
+}
+
+// 进程在再分配时的去向
+type allocationClass int
+
+const (
+	allocationDefault   allocationClass = iota // 还没有分类或者缺少DCAPS需要的MRC与计数，放在CLOS 0
+	allocationDCAPS                            // 由DCAPS决定CLOS
+	allocationShared                           // 放在CLOS 1
+	allocationThrottled                        // 带宽密集，放在限制内存带宽的CLOS，与CLOS 1使用相同的way
+)
+
+type allocationPlan struct {
+	managed   []*algorithm.ProgramMetric
+	defaults  []int
+	shared    []int
+	throttled []int
+}
+
+func (p *allocationPlan) add(class allocationClass, program *algorithm.ProgramMetric) {
+	pids := program.GroupPids
+	if len(pids) == 0 {
+		pids = []int{program.Pid}
+	}
+	switch class {
+	case allocationDCAPS:
+		p.managed = append(p.managed, program)
+	case allocationShared:
+		p.shared = append(p.shared, pids...)
+	case allocationThrottled:
+		p.throttled = append(p.throttled, pids...)
+	default:
+		p.defaults = append(p.defaults, pids...)
+	}
+}
+
+// throttle为true时带宽密集的进程单独放在限制带宽的CLOS
+func allocationOf(p *processCharacteristic, throttle bool) allocationClass {
+	switch p.characteristic {
+	case classifier.MemoryCharacteristicToDetermine:
+		return allocationDefault
+	case classifier.MemoryCharacteristicNonCritical, classifier.MemoryCharacteristicBully,
+		classifier.MemoryCharacteristicSquanderer:
+		if throttle && p.bandwidthIntensity == classifier.BandwidthIntensityHigh {
+			return allocationThrottled
+		}
+		return allocationShared
+	}
+	if len(p.mrc) == 0 || p.perfStat == nil {
+		return allocationDefault
+	}
+	return allocationDCAPS
+}
+
+type dcapsFunc func(programs []*algorithm.ProgramMetric, oldSchemes []*pqos.CLOSScheme, numClos int) []*pqos.CLOSScheme
+
+// 按分配计划组装CLOS方案，只使用前totalClos个CLOS。throttle大于0时其中最后一个CLOS限制内存带宽为throttle%，不交给DCAPS使用。
+// CLOS 1与限制带宽的CLOS每次重新生成，oldSchemes中只有DCAPS使用的CLOS用于平滑分配方案的改变
+func buildSchemes(plan *allocationPlan, oldSchemes []*pqos.CLOSScheme, throttle, numWays, totalClos int, dcaps dcapsFunc) []*pqos.CLOSScheme {
+	dcapsClos := totalClos
+	if throttle > 0 {
+		dcapsClos--
+	}
+	var schemes []*pqos.CLOSScheme
+	if len(plan.managed) > 0 {
+		var old []*pqos.CLOSScheme
+		for _, scheme := range oldSchemes {
+			if scheme.CLOSNum != sharedClos && scheme.CLOSNum < dcapsClos {
+				old = append(old, scheme)
+			}
+		}
+		schemes = dcaps(plan.managed, old, dcapsClos)
+	} else {
+		schemes = make([]*pqos.CLOSScheme, dcapsClos)
+		for i := range schemes {
+			schemes[i] = &pqos.CLOSScheme{
+				CLOSNum:     i,
+				WayBit:      utils.GetLowestBits(numWays),
+				MemThrottle: 100,
+			}
+		}
+	}
+	schemes[0].Processes = append(schemes[0].Processes, plan.defaults...)
+	schemes[sharedClos] = &pqos.CLOSScheme{
+		CLOSNum:     sharedClos,
+		WayBit:      sharedWayBit,
+		MemThrottle: 100,
+		Processes:   plan.shared,
+	}
+	if throttle > 0 {
+		schemes = append(schemes, &pqos.CLOSScheme{
+			CLOSNum:     dcapsClos,
+			WayBit:      sharedWayBit,
+			MemThrottle: throttle,
+			Processes:   plan.throttled,
+		})
+	}
+	return schemes
+}
+
+// pid所在的CLOS，不在任何CLOS中时返回nil
+func findCLOS(schemes []*pqos.CLOSScheme, pid int) *pqos.CLOSScheme {
+	for _, scheme := range schemes {
+		for _, p := range scheme.Processes {
+			if p == pid {
+				return scheme
+			}
+		}
+	}
+	return nil
+}
+
+// 将pids放入templatePid所在的CLOS，返回新的方案列表与修改后的方案。schemes已经交给pqos并用于之后的再分配，
+// 因此不修改其中的方案，而是复制被修改的方案。templatePid不在任何CLOS中时返回nil
+func addToCLOS(schemes []*pqos.CLOSScheme, templatePid int, pids []int) ([]*pqos.CLOSScheme, *pqos.CLOSScheme) {
+	scheme := findCLOS(schemes, templatePid)
+	if scheme == nil {
+		return nil, nil
+	}
+	changed := &pqos.CLOSScheme{
+		CLOSNum:     scheme.CLOSNum,
+		WayBit:      scheme.WayBit,
+		MemThrottle: scheme.MemThrottle,
+		Processes:   make([]int, 0, len(scheme.Processes)+len(pids)),
+	}
+	changed.Processes = append(append(changed.Processes, scheme.Processes...), pids...)
+	newSchemes := make([]*pqos.CLOSScheme, len(schemes))
+	for i, s := range schemes {
+		if s == scheme {
+			newSchemes[i] = changed
+		} else {
+			newSchemes[i] = s
+		}
+	}
+	return newSchemes, changed
+}
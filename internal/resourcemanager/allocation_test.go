@@ -0,0 +1,156 @@
+package resourcemanager
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/algorithm"
+	"github.com/packagewjx/resourcemanager/internal/classifier"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/pqos"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestAllocationOf(t *testing.T) {
+	stat := &perf.StatResult{Instructions: 1000, Cycles: 1000}
+	for _, c := range []struct {
+		name     string
+		p        *processCharacteristic
+		throttle bool
+		expected allocationClass
+	}{
+		{"未分类", &processCharacteristic{}, true, allocationDefault},
+		{"带宽低的squanderer", &processCharacteristic{characteristic: classifier.MemoryCharacteristicSquanderer,
+			bandwidthIntensity: classifier.BandwidthIntensityLow}, true, allocationShared},
+		{"带宽密集的squanderer", &processCharacteristic{characteristic: classifier.MemoryCharacteristicSquanderer,
+			bandwidthIntensity: classifier.BandwidthIntensityHigh}, true, allocationThrottled},
+		{"不限制带宽时带宽密集的bully", &processCharacteristic{characteristic: classifier.MemoryCharacteristicBully,
+			bandwidthIntensity: classifier.BandwidthIntensityHigh}, false, allocationShared},
+		{"带宽密集的sensitive", &processCharacteristic{characteristic: classifier.MemoryCharacteristicSensitive,
+			mrc: []float32{1, 0.5}, perfStat: stat, bandwidthIntensity: classifier.BandwidthIntensityHigh}, true, allocationDCAPS},
+		{"没有MRC的medium", &processCharacteristic{characteristic: classifier.MemoryCharacteristicMedium, perfStat: stat},
+			true, allocationDefault},
+	} {
+		assert.Equal(t, c.expected, allocationOf(c.p, c.throttle), c.name)
+	}
+}
+
+func TestBuildSchemes(t *testing.T) {
+	plan := &allocationPlan{
+		managed:   []*algorithm.ProgramMetric{{Pid: 1}, {Pid: 2}},
+		defaults:  []int{3},
+		shared:    []int{4},
+		throttled: []int{5},
+	}
+	old := []*pqos.CLOSScheme{
+		{CLOSNum: 0, WayBit: 0x7FF, MemThrottle: 100},
+		{CLOSNum: 1, WayBit: 0x3, MemThrottle: 100, Processes: []int{1}},
+		{CLOSNum: 2, WayBit: 0xFF, MemThrottle: 100, Processes: []int{2}},
+		{CLOSNum: 7, WayBit: 0x3, MemThrottle: 50, Processes: []int{5}},
+	}
+	var dcapsPrograms []*algorithm.ProgramMetric
+	var dcapsOld []*pqos.CLOSScheme
+	dcapsClos := 0
+	dcaps := func(programs []*algorithm.ProgramMetric, oldSchemes []*pqos.CLOSScheme, n int) []*pqos.CLOSScheme {
+		dcapsPrograms, dcapsOld, dcapsClos = programs, oldSchemes, n
+		schemes := make([]*pqos.CLOSScheme, n)
+		for i := range schemes {
+			schemes[i] = &pqos.CLOSScheme{CLOSNum: i, WayBit: 0x7FF, MemThrottle: 100}
+		}
+		schemes[2].Processes = []int{1, 2}
+		return schemes
+	}
+
+	schemes := buildSchemes(plan, old, 50, 11, numClos, dcaps)
+	// DCAPS只分配需要缓存的进程，不使用CLOS 1与限制带宽的CLOS
+	assert.Equal(t, plan.managed, dcapsPrograms)
+	assert.Equal(t, numClos-1, dcapsClos)
+	assert.Equal(t, []*pqos.CLOSScheme{old[0], old[2]}, dcapsOld)
+	if assert.Len(t, schemes, numClos) {
+		assert.Equal(t, []int{3}, schemes[0].Processes)
+		assert.Equal(t, &pqos.CLOSScheme{CLOSNum: 1, WayBit: 0x3, MemThrottle: 100, Processes: []int{4}}, schemes[1])
+		assert.Equal(t, []int{1, 2}, schemes[2].Processes)
+		assert.Equal(t, &pqos.CLOSScheme{CLOSNum: 7, WayBit: 0x3, MemThrottle: 50, Processes: []int{5}}, schemes[7])
+	}
+
+	// 没有需要缓存的进程时不运行DCAPS；不限制带宽时没有单独的CLOS
+	dcapsClos = 0
+	schemes = buildSchemes(&allocationPlan{shared: []int{4}}, nil, 0, 11, numClos, dcaps)
+	assert.Equal(t, 0, dcapsClos)
+	if assert.Len(t, schemes, numClos) {
+		assert.Equal(t, 0x7FF, schemes[0].WayBit)
+		assert.Empty(t, schemes[0].Processes)
+		assert.Equal(t, []int{4}, schemes[1].Processes)
+		assert.Equal(t, 0x7FF, schemes[7].WayBit)
+	}
+
+	// gentle模式下不使用留给分类的CLOS
+	totalClos := allocatableClos(core.ClassifyModeGentle)
+	assert.Equal(t, numClos, allocatableClos(core.ClassifyModeProbe))
+	schemes = buildSchemes(plan, old, 50, 11, totalClos, dcaps)
+	assert.Equal(t, totalClos-1, dcapsClos)
+	assert.Equal(t, []*pqos.CLOSScheme{old[0], old[2]}, dcapsOld)
+	if assert.Len(t, schemes, totalClos) {
+		assert.Equal(t, &pqos.CLOSScheme{CLOSNum: totalClos - 1, WayBit: 0x3, MemThrottle: 50, Processes: []int{5}},
+			schemes[totalClos-1])
+		for _, scheme := range schemes {
+			assert.NotEqual(t, classifier.GentleCLOS, scheme.CLOSNum)
+		}
+	}
+}
+
+func TestPlanAllocationGroupPolicy(t *testing.T) {
+	newGroup := func(id string, policy core.GroupPolicy, characteristic classifier.MemoryCharacteristic) *processGroupContext {
+		return &processGroupContext{
+			group: &core.ProcessGroup{Id: id, Pid: []int{1, 2, 3}},
+			processes: map[int]*processCharacteristic{
+				1: {pid: 1, characteristic: classifier.MemoryCharacteristicSensitive, mrc: []float32{1, 0.5, 0.2},
+					perfStat: &perf.StatResult{LLCHit: 300, LLCMiss: 100}},
+				2: {pid: 2, characteristic: classifier.MemoryCharacteristicNonCritical, mrc: []float32{1, 1, 1},
+					perfStat: &perf.StatResult{LLCHit: 0, LLCMiss: 0}},
+				3: {pid: 3, characteristic: classifier.MemoryCharacteristicBully},
+			},
+			state:          processGroupStateRunning,
+			policy:         policy,
+			dominantPid:    1,
+			characteristic: characteristic,
+			perfStat:       &perf.StatResult{LLCHit: 300, LLCMiss: 100},
+		}
+	}
+
+	m := (*processGroupMap)(&sync.Map{})
+	m.store(newGroup("perProcess", core.GroupPolicyPerProcess, classifier.MemoryCharacteristicToDetermine))
+	plan := m.planAllocation(true)
+	if assert.Len(t, plan.managed, 1) {
+		assert.Equal(t, 1, plan.managed[0].Pid)
+		assert.Empty(t, plan.managed[0].GroupPids)
+	}
+	assert.Equal(t, []int{2, 3}, plan.shared)
+
+	// 进程组整体分配，所有进程都交给DCAPS
+	m = (*processGroupMap)(&sync.Map{})
+	group := newGroup("dominant", core.GroupPolicyDominant, classifier.MemoryCharacteristicSensitive)
+	m.store(group)
+	plan = m.planAllocation(true)
+	if assert.Len(t, plan.managed, 1) {
+		assert.Equal(t, []int{1, 2, 3}, plan.managed[0].GroupPids)
+		assert.Equal(t, []float32{1, 0.5, 0.2}, plan.managed[0].MRC)
+	}
+	assert.Empty(t, plan.shared)
+	assert.True(t, group.needsMRC(group.processes[1]))
+	assert.False(t, group.needsMRC(group.processes[2]))
+
+	group.characteristic = classifier.MemoryCharacteristicSquanderer
+	group.bandwidthIntensity = classifier.BandwidthIntensityHigh
+	plan = m.planAllocation(true)
+	assert.Empty(t, plan.managed)
+	assert.Equal(t, []int{1, 2, 3}, plan.throttled)
+	assert.False(t, group.needsMRC(group.processes[1]))
+
+	// aggregate策略按LLC访问次数加权平均，进程2没有访问LLC
+	group = newGroup("aggregate", core.GroupPolicyAggregate, classifier.MemoryCharacteristicMedium)
+	assert.Equal(t, []float32{1, 0.5, 0.2}, group.groupMRC())
+	group.processes[2].perfStat = &perf.StatResult{LLCHit: 400}
+	assert.InDeltaSlice(t, []float32{1, 0.75, 0.6}, group.groupMRC(), 1e-6)
+	assert.True(t, group.needsMRC(group.processes[2]))
+}
@@ -0,0 +1,213 @@
+package resourcemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 程序标识。同一个可执行文件以相同参数运行，并且以相同方式计算MRC时，认为其MRC相同，可以复用之前的追踪结果
+type programIdentity struct {
+	exePath string
+	exeHash string
+	args    []string
+	method  string // 计算MRC的方式，见mrcMethod
+}
+
+// 计算MRC的方式，由采样方式、RTH计算方式、MRC模型、多线程合成方式以及追踪的限制组成。任意一项不同时得到的MRC
+// 不能互相替代
+func mrcMethod() string {
+	memTrace := core.RootConfig.MemTrace
+	limit := memTrace.Limit
+	// ResourceManager固定使用Pin追踪
+	return fmt.Sprintf("%s/%s/%d/%d/%d/%s/%s/%s/%g/%d", core.MemTraceSamplerPin, memTrace.RthCalculatorType,
+		memTrace.ReservoirSize, memTrace.MaxRthTime, memTrace.TraceCount, memTrace.MRCModel, memTrace.MRCComposition,
+		limit.MaxDuration, limit.MaxSlowdown, limit.MaxAddresses)
+}
+
+// 使用内容哈希、参数与计算方式作为键，路径不参与计算，使得同一个镜像在不同容器中运行时也能命中
+func (p *programIdentity) key() string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, p.exeHash)
+	_, _ = io.WriteString(h, "\x00")
+	_, _ = io.WriteString(h, p.method)
+	for _, arg := range p.args {
+		_, _ = io.WriteString(h, "\x00")
+		_, _ = io.WriteString(h, arg)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (p *programIdentity) String() string {
+	return fmt.Sprintf("%s %s", p.exePath, strings.Join(p.args, " "))
+}
+
+type exeHashKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// 缓存可执行文件的哈希值，避免每次都重新读取整个文件
+var exeHashCache = struct {
+	sync.Mutex
+	m map[exeHashKey]string
+}{m: map[exeHashKey]string{}}
+
+// 读取进程的程序标识。通过/proc/<pid>/exe读取文件内容，因此对容器内的进程同样有效
+func getProgramIdentity(pid int) (*programIdentity, error) {
+	exeLink := fmt.Sprintf("/proc/%d/exe", pid)
+	exePath, err := os.Readlink(exeLink)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取可执行文件路径出错")
+	}
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, errors.Wrap(err, "读取进程参数出错")
+	}
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	if len(args) > 0 {
+		// 第一个参数为程序名，可能是相对路径，不参与比较
+		args = args[1:]
+	}
+
+	f, err := os.Open(exeLink)
+	if err != nil {
+		return nil, errors.Wrap(err, "打开可执行文件出错")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "读取可执行文件信息出错")
+	}
+	hashKey := exeHashKey{path: exePath, size: stat.Size(), modTime: stat.ModTime()}
+	exeHashCache.Lock()
+	exeHash, ok := exeHashCache.m[hashKey]
+	exeHashCache.Unlock()
+	if !ok {
+		h := sha256.New()
+		if _, err = io.Copy(h, f); err != nil {
+			return nil, errors.Wrap(err, "计算可执行文件哈希出错")
+		}
+		exeHash = hex.EncodeToString(h.Sum(nil))
+		exeHashCache.Lock()
+		exeHashCache.m[hashKey] = exeHash
+		exeHashCache.Unlock()
+	}
+
+	return &programIdentity{
+		exePath: exePath,
+		exeHash: exeHash,
+		args:    args,
+		method:  mrcMethod(),
+	}, nil
+}
+
+type mrcCacheEntry struct {
+	ExePath    string
+	ExeHash    string
+	Args       []string
+	Method     string
+	CreateTime time.Time
+	MRC        []float32
+	Partial    bool // 由提前结束的追踪得到
+}
+
+// 持久化的MRC缓存，每个程序标识保存为目录下的一个JSON文件
+type mrcCache struct {
+	dir        string
+	maxAge     time.Duration
+	lock       sync.Mutex
+	refreshing map[string]struct{}
+}
+
+func newMRCCache(dir string, maxAge time.Duration) (*mrcCache, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建MRC缓存目录出错")
+	}
+	return &mrcCache{
+		dir:        dir,
+		maxAge:     maxAge,
+		refreshing: map[string]struct{}{},
+	}, nil
+}
+
+func (c *mrcCache) entryPath(id *programIdentity) string {
+	return filepath.Join(c.dir, id.key()+".json")
+}
+
+// 查询缓存。cacheSize与记录的MRC长度不一致时视为未命中。
+// stale表示记录已经超过maxAge或者是部分结果，调用者可以选择继续使用或者重新追踪
+func (c *mrcCache) get(id *programIdentity, cacheSize int) (mrc []float32, stale bool, ok bool) {
+	content, err := ioutil.ReadFile(c.entryPath(id))
+	if err != nil {
+		return nil, false, false
+	}
+	entry := &mrcCacheEntry{}
+	if err = json.Unmarshal(content, entry); err != nil {
+		return nil, false, false
+	}
+	if entry.ExeHash != id.exeHash || entry.Method != id.method || len(entry.MRC) != cacheSize+1 {
+		return nil, false, false
+	}
+	stale = entry.Partial || c.maxAge != 0 && time.Now().Sub(entry.CreateTime) > c.maxAge
+	return entry.MRC, stale, true
+}
+
+func (c *mrcCache) put(id *programIdentity, mrc []float32, partial bool) error {
+	content, err := json.Marshal(&mrcCacheEntry{
+		ExePath:    id.exePath,
+		ExeHash:    id.exeHash,
+		Args:       id.args,
+		Method:     id.method,
+		CreateTime: time.Now(),
+		MRC:        mrc,
+		Partial:    partial,
+	})
+	if err != nil {
+		return errors.Wrap(err, "序列化MRC缓存出错")
+	}
+	// 先写入临时文件再重命名，避免并发读取到写了一半的文件
+	tmp, err := ioutil.TempFile(c.dir, "tmp.mrc.*")
+	if err != nil {
+		return errors.Wrap(err, "创建MRC缓存文件出错")
+	}
+	_, err = tmp.Write(content)
+	_ = tmp.Close()
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "写入MRC缓存文件出错")
+	}
+	return os.Rename(tmp.Name(), c.entryPath(id))
+}
+
+// 标记开始后台刷新。同一个程序标识同时只会有一个刷新任务，返回false表示已经有任务在进行
+func (c *mrcCache) startRefresh(id *programIdentity) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := id.key()
+	if _, ok := c.refreshing[key]; ok {
+		return false
+	}
+	c.refreshing[key] = struct{}{}
+	return true
+}
+
+func (c *mrcCache) finishRefresh(id *programIdentity) {
+	c.lock.Lock()
+	delete(c.refreshing, id.key())
+	c.lock.Unlock()
+}
@@ -0,0 +1,97 @@
+package resourcemanager
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGetProgramIdentity(t *testing.T) {
+	id, err := getProgramIdentity(os.Getpid())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, id.exePath)
+	assert.Len(t, id.exeHash, 64)
+	assert.Equal(t, os.Args[1:], id.args)
+	assert.Equal(t, mrcMethod(), id.method)
+
+	again, err := getProgramIdentity(os.Getpid())
+	assert.NoError(t, err)
+	assert.Equal(t, id.key(), again.key())
+}
+
+func TestMRCCache(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "tmp.mrccache.*")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	cache, err := newMRCCache(dir, time.Hour)
+	assert.NoError(t, err)
+
+	id := &programIdentity{exePath: "/bin/canneal", exeHash: "abc", args: []string{"-n", "1"}, method: "pin/aet/weighted"}
+	_, _, ok := cache.get(id, 3)
+	assert.False(t, ok)
+
+	mrc := []float32{1, 0.5, 0.25, 0.1}
+	assert.NoError(t, cache.put(id, mrc, false))
+	got, stale, ok := cache.get(id, 3)
+	assert.True(t, ok)
+	assert.False(t, stale)
+	assert.Equal(t, mrc, got)
+
+	// 缓存大小不一致时视为未命中
+	_, _, ok = cache.get(id, 4)
+	assert.False(t, ok)
+
+	// 参数不同时为不同的程序
+	other := &programIdentity{exePath: "/bin/canneal", exeHash: "abc", args: []string{"-n", "2"}}
+	assert.NotEqual(t, id.key(), other.key())
+	_, _, ok = cache.get(other, 3)
+	assert.False(t, ok)
+
+	// 计算MRC的方式不同时不能复用
+	otherMethod := &programIdentity{exePath: "/bin/canneal", exeHash: "abc", args: []string{"-n", "1"},
+		method: "pin/footprint/weighted"}
+	assert.NotEqual(t, id.key(), otherMethod.key())
+	_, _, ok = cache.get(otherMethod, 3)
+	assert.False(t, ok)
+
+	// 部分结果视为过期
+	partialId := &programIdentity{exePath: "/bin/canneal", exeHash: "abc", args: []string{"-n", "3"}}
+	assert.NoError(t, cache.put(partialId, mrc, true))
+	got, stale, ok = cache.get(partialId, 3)
+	assert.True(t, ok)
+	assert.True(t, stale)
+	assert.Equal(t, mrc, got)
+
+	// 过期
+	cache.maxAge = time.Nanosecond
+	<-time.After(time.Millisecond)
+	got, stale, ok = cache.get(id, 3)
+	assert.True(t, ok)
+	assert.True(t, stale)
+	assert.Equal(t, mrc, got)
+
+	assert.True(t, cache.startRefresh(id))
+	assert.False(t, cache.startRefresh(id))
+	cache.finishRefresh(id)
+	assert.True(t, cache.startRefresh(id))
+}
+
+func TestMRCMethod(t *testing.T) {
+	memTrace := &core.RootConfig.MemTrace
+	method := mrcMethod()
+	oldSize := memTrace.ReservoirSize
+	memTrace.ReservoirSize = oldSize * 2
+	assert.NotEqual(t, method, mrcMethod())
+	memTrace.ReservoirSize = oldSize
+
+	oldDuration := memTrace.Limit.MaxDuration
+	memTrace.Limit.MaxDuration = oldDuration + time.Minute
+	assert.NotEqual(t, method, mrcMethod())
+	memTrace.Limit.MaxDuration = oldDuration
+	assert.Equal(t, method, mrcMethod())
+}
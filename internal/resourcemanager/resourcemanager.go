@@ -3,6 +3,7 @@ package resourcemanager
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/packagewjx/resourcemanager/internal/algorithm"
 	"github.com/packagewjx/resourcemanager/internal/classifier"
@@ -10,8 +11,10 @@ import (
 	"github.com/packagewjx/resourcemanager/internal/pqos"
 	"github.com/packagewjx/resourcemanager/internal/resourcemanager/watcher"
 	"github.com/packagewjx/resourcemanager/internal/sampler/memrecord"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
 	"github.com/packagewjx/resourcemanager/internal/utils"
 	"github.com/pkg/errors"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
@@ -31,6 +34,9 @@ var (
 
 var numWays, numSets, _ = utils.GetL3Cap()
 
+// 关闭时等待进程组的分类、追踪与监控结束的最长时间
+const shutdownTimeout = 30 * time.Second
+
 type impl struct {
 	watcher                      watcher.ProcessGroupWatcher
 	classifier                   classifier.Classifier
@@ -40,16 +46,15 @@ type impl struct {
 	processChangeCountWhenUpdate int
 	logger                       *log.Logger
 	wg                           sync.WaitGroup
+	schemeLock                   sync.Mutex // 保护currentSchemes
 	currentSchemes               []*pqos.CLOSScheme
+	mrcCache                     *mrcCache // 为nil时不使用缓存
 }
 
 var _ ResourceManager = &impl{}
 
 func New(config *Config) (ResourceManager, error) {
-	c, err := classifier.New(&classifier.Config{})
-	if err != nil {
-		return nil, errors.Wrap(err, "创建分类器出错")
-	}
+	// 管理器固定使用Pin追踪，memtrace.sampler只影响sample等命令
 	recorder, err := memrecord.NewPinMemRecorder(&memrecord.Config{
 		BufferSize:     core.RootConfig.MemTrace.PinConfig.BufferSize,
 		WriteThreshold: core.RootConfig.MemTrace.PinConfig.WriteThreshold,
@@ -62,20 +67,48 @@ func New(config *Config) (ResourceManager, error) {
 	}
 	r := &impl{
 		watcher:                      config.Watcher,
-		classifier:                   c,
-		memRecorder:                  recorder,
+		memRecorder:                  memrecord.NewLimitedRecorder(recorder, core.RootConfig.MemTrace.Limit.MonitorInterval),
 		processGroups:                (*processGroupMap)(&sync.Map{}),
 		processChangeCountWhenUpdate: 0,
 		logger:                       log.New(os.Stdout, "ResourceManager: ", log.LstdFlags|log.Lshortfile|log.Lmsgprefix),
 		wg:                           sync.WaitGroup{},
 	}
 
+	if cacheConfig := core.RootConfig.MemTrace.MRCCache; cacheConfig.Enable {
+		r.mrcCache, err = newMRCCache(cacheConfig.Dir, cacheConfig.MaxAge)
+		if err != nil {
+			r.logger.Printf("无法使用MRC缓存，将每次都进行内存追踪：%v", err)
+		}
+	}
+
+	r.classifier, err = classifier.New(&classifier.Config{MRCProvider: r.cachedMRC})
+	if err != nil {
+		return nil, errors.Wrap(err, "创建分类器出错")
+	}
+
 	r.reAllocTimerRoutine = newTimerRoutine(core.RootConfig.Manager.AllocCoolDown, core.RootConfig.Manager.AllocSquash, r.doReAlloc)
 	return r, nil
 }
 
-func (r *impl) gracefulShutdown() {
-
+// 结束所有进程组的分类、追踪与监控，等待其退出后写出每个进程的分类结果、分类依据与MRC
+func (r *impl) gracefulShutdown(cancel context.CancelFunc) {
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		r.logger.Printf("等待 %s 后仍有任务没有结束，直接写出结果", shutdownTimeout)
+	}
+	if statusFile := core.RootConfig.Manager.StatusFile; statusFile != "" {
+		if err := r.writeStatus(statusFile); err != nil {
+			r.logger.Printf("写入状态文件 %s 出错：%v", statusFile, err)
+		}
+	}
+	r.writeResult()
 }
 
 func (r *impl) handleProcessStatus(ctx context.Context, status *watcher.ProcessGroupStatus) {
@@ -83,15 +116,19 @@ func (r *impl) handleProcessStatus(ctx context.Context, status *watcher.ProcessG
 	case watcher.ProcessGroupStatusAdd:
 		childCtx, cancel := context.WithCancel(ctx)
 		processGroupCtx := &processGroupContext{
+			id:               status.Group.Id,
 			group:            status.Group.Clone().(*core.ProcessGroup),
 			state:            processGroupStateNew,
 			processes:        map[int]*processCharacteristic{},
 			cancelManageFunc: cancel,
+			ctx:              childCtx,
+			pidsChanged:      make(chan struct{}, 1),
 		}
 		for _, pid := range processGroupCtx.group.Pid {
 			processGroupCtx.processes[pid] = &processCharacteristic{
 				pid:            pid,
 				characteristic: classifier.MemoryCharacteristicToDetermine,
+				history:        perf.NewStatHistory(core.RootConfig.Manager.StatHistorySize),
 			}
 		}
 		r.processGroups.store(processGroupCtx)
@@ -99,12 +136,21 @@ func (r *impl) handleProcessStatus(ctx context.Context, status *watcher.ProcessG
 		go func() {
 			defer func() {
 				r.wg.Done()
+				processGroupCtx.lock.Lock()
 				processGroupCtx.cancelManageFunc = nil
+				processGroupCtx.lock.Unlock()
 			}()
 			if r.classify(childCtx, processGroupCtx) != nil {
 				return
 			}
-			r.memTrace(childCtx, processGroupCtx)
+			if interval := core.RootConfig.Manager.StatInterval; interval > 0 {
+				r.wg.Add(1)
+				go func() {
+					defer r.wg.Done()
+					r.monitorStats(childCtx, processGroupCtx, interval)
+				}()
+			}
+			r.memTrace(childCtx, processGroupCtx, true)
 			r.reAllocTimerRoutine.requestRun()
 		}()
 	case watcher.ProcessGroupStatusRemove:
@@ -114,12 +160,14 @@ func (r *impl) handleProcessStatus(ctx context.Context, status *watcher.ProcessG
 			return
 		}
 		// 当进程已经退出的时候，CLOS自然会被清空，因此这里不需要做太多的工作，移除本进程组即可
+		processGroup.lock.Lock()
 		if processGroup.cancelManageFunc != nil {
 			// 暂停正在进行的任何活动
 			processGroup.cancelManageFunc()
 			processGroup.cancelManageFunc = nil
 		}
 		r.processChangeCountWhenUpdate += len(processGroup.group.Pid)
+		processGroup.lock.Unlock()
 		r.processGroups.remove(status.Group.Id)
 		r.logger.Printf("成功移除进程组 %s", status.Group.Id)
 	case watcher.ProcessGroupStatusUpdate:
@@ -131,13 +179,35 @@ func (r *impl) handleProcessStatus(ctx context.Context, status *watcher.ProcessG
 		// 对于进程组更新，只有当前进程更改的次数达到一个阈值以后才会进行处理。如果每次更新进程都处理，会导致分配方案频繁变更，可能
 		// 会有不好的后果。
 		// 再分配触发时重置此计数。
-		// 目前先不实现再次进行分类的逻辑。
+		// 已有进程不再次进行分类，程序行为的变化由monitorStats检测阶段变化后重新分类。
+		processGroup.lock.Lock()
 		oldGroup := processGroup.group
 		add, removed := diffIntArray(oldGroup.Pid, status.Group.Pid)
 		r.processChangeCountWhenUpdate += len(add) + len(removed)
+		// 其他协程可能正在使用旧的group，因此替换而不修改
+		processGroup.group = status.Group.Clone().(*core.ProcessGroup)
 		for _, removedPid := range removed {
 			delete(processGroup.processes, removedPid)
 		}
+		for _, pid := range add {
+			processGroup.processes[pid] = &processCharacteristic{
+				pid:            pid,
+				characteristic: classifier.MemoryCharacteristicToDetermine,
+				history:        perf.NewStatHistory(core.RootConfig.Manager.StatHistorySize),
+			}
+		}
+		running := processGroup.state == processGroupStateRunning
+		processGroup.lock.Unlock()
+		if len(add) != 0 {
+			select {
+			case processGroup.pidsChanged <- struct{}{}:
+			default:
+			}
+		}
+		// 还没有开始分类的进程组在分类时会包括新进程，正在分类时由runClassification在分类结束后处理
+		if len(add) != 0 && running {
+			r.adoptProcesses(processGroup.ctx, processGroup, add)
+		}
 	}
 	if r.processChangeCountWhenUpdate > core.RootConfig.Manager.ChangeProcessCountThreshold {
 		r.reAllocTimerRoutine.requestRun()
@@ -147,28 +217,19 @@ func (r *impl) handleProcessStatus(ctx context.Context, status *watcher.ProcessG
 func (r *impl) doReAlloc() {
 	// 首先获取快照，防止processGroups修改产生的一些意外后果
 	r.logger.Println("正在计算分配方案")
-	managedProcess := make([]*processCharacteristic, 0, 10)
-	r.processGroups.traverse(func(name string, group *processGroupContext) bool {
-		if group.state == processGroupStateClassifying || group.state == processGroupStateErrored {
-			return true
-		}
-		pGroup := group.group.Clone().(*core.ProcessGroup)
-		for _, pid := range pGroup.Pid {
-			r, ok := group.processes[pid]
-			if !ok || r.characteristic == classifier.MemoryCharacteristicNonCritical ||
-				r.characteristic == classifier.MemoryCharacteristicBully || r.characteristic == classifier.MemoryCharacteristicSquanderer {
-				continue
-			}
-
-			managedProcess = append(managedProcess, r.Clone().(*processCharacteristic))
-		}
-		return true
-	})
+	throttle := core.RootConfig.Manager.BandwidthThrottle
+	plan := r.processGroups.planAllocation(throttle > 0)
+	r.logger.Printf("DCAPS分配 %d 个进程，CLOS 1共享 %d 个进程，限制带宽 %d 个进程，%d 个进程使用CLOS 0", len(plan.managed),
+		len(plan.shared), len(plan.throttled), len(plan.defaults))
+	r.schemeLock.Lock()
+	defer r.schemeLock.Unlock()
+	r.currentSchemes = buildSchemes(plan, r.currentSchemes, throttle, numWays,
+		allocatableClos(core.RootConfig.Algorithm.Classify.Mode),
+		func(programs []*algorithm.ProgramMetric, oldSchemes []*pqos.CLOSScheme, dcapsClos int) []*pqos.CLOSScheme {
+			return algorithm.DCAPS(programs, oldSchemes, numWays, numSets, dcapsClos)
+		})
 
 	r.logger.Println("分配方案计算完成，正在执行分配")
-	programMetricList := r.processGroups.getProgramMetricList()
-	r.currentSchemes = algorithm.DCAPS(programMetricList, r.currentSchemes, numWays, numSets, 8)
-
 	err := pqos.SetCLOSScheme(r.currentSchemes)
 	if err != nil {
 		r.logger.Println("无法设置CLOS分配", err)
@@ -176,33 +237,31 @@ func (r *impl) doReAlloc() {
 	r.logger.Println("资源分配完成")
 }
 
-// 调试用,用于采集信息
+// 关闭时调用，将分类结果、分类依据、MRC与计数写入当前目录，也用于采集离线分类与训练的数据
 func (r *impl) writeResult() {
-	var perfStatCsv *os.File
-	name := "perfstat.csv"
-	if _, err := os.Stat(name); os.IsNotExist(err) {
-		perfStatCsv, err = os.Create(name)
-		if err != nil {
-			r.logger.Println("创建perfstat输出文件失败", err)
-			return
-		}
-		_, _ = perfStatCsv.WriteString("groupId,pid,instructions,cycles,allStores,allLoads,LLCMiss,LLCHit,MemAnyCycles,LLCMissCycles,characteristic\n")
-	} else {
-		perfStatCsv, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
-		if err != nil {
-			r.logger.Println("打开perfstat输出文件失败", err)
-			return
-		}
+	perfStatCsv := r.openPerfStatCSV("perfstat.csv")
+	if perfStatCsv == nil {
+		return
 	}
+	// 分类时限制way的计数，与perfstat.csv一起可以用classify命令离线分类
+	twoWaysCsv := r.openPerfStatCSV("perfstat.twoways.csv")
 
 	r.processGroups.traverse(func(name string, group *processGroupContext) bool {
+		group.lock.Lock()
+		defer group.lock.Unlock()
+		if group.explanation != nil {
+			r.writeExplanation(fmt.Sprintf("%s.classify.json", group.id), group.explanation)
+		}
 		for pid, characteristic := range group.processes {
 			if characteristic.characteristic == classifier.MemoryCharacteristicToDetermine {
 				continue
 			}
 
 			if len(characteristic.mrc) != 0 {
-				mrcCsv, err := os.Create(fmt.Sprintf("%s-%d.mrc.csv", group.group.Id, pid))
+				if characteristic.mrcPartial {
+					r.logger.Printf("进程组 %s 进程 %d 的MRC由提前结束的追踪得到，可能不准确", group.id, pid)
+				}
+				mrcCsv, err := os.Create(fmt.Sprintf("%s-%d.mrc.csv", group.id, pid))
 				if err != nil {
 					r.logger.Println("创建MRC CSV 失败")
 				} else {
@@ -214,86 +273,505 @@ func (r *impl) writeResult() {
 					_ = mrcCsv.Close()
 				}
 			}
+			if characteristic.explanation != nil {
+				r.writeExplanation(fmt.Sprintf("%s-%d.classify.json", group.id, pid), characteristic.explanation)
+			}
+			if characteristic.history != nil && characteristic.history.Len() != 0 {
+				r.writeStatHistory(fmt.Sprintf("%s-%d.stat.csv", group.id, pid), characteristic.history)
+			}
 			if characteristic.perfStat == nil {
-				r.logger.Printf("进程组 %s 进程 %d perf stat 为空", group.group.Id, pid)
+				r.logger.Printf("进程组 %s 进程 %d perf stat 为空", group.id, pid)
 			} else {
-				_, _ = perfStatCsv.WriteString(fmt.Sprintf("%s,%d,%d,%d,%d,%d,%d,%d,%d,%d,%s\n", group.group.Id,
-					characteristic.perfStat.Pid, characteristic.perfStat.Instructions, characteristic.perfStat.Cycles,
-					characteristic.perfStat.AllStores, characteristic.perfStat.AllLoads, characteristic.perfStat.LLCMiss,
-					characteristic.perfStat.LLCHit, characteristic.perfStat.MemAnyCycles, characteristic.perfStat.LLCMissCycles,
-					characteristic.characteristic))
+				writePerfStatRow(perfStatCsv, group.id, characteristic.perfStat, characteristic.characteristic)
+			}
+			if twoWaysCsv != nil && characteristic.perfStatTwoWays != nil {
+				writePerfStatRow(twoWaysCsv, group.id, characteristic.perfStatTwoWays, characteristic.characteristic)
 			}
 		}
 		return true
 	})
 	_ = perfStatCsv.Close()
+	if twoWaysCsv != nil {
+		_ = twoWaysCsv.Close()
+	}
 	r.logger.Println("结果写入完成")
 }
 
+// 打开perfstat输出文件，不存在时创建并写入表头。出错时返回nil
+func (r *impl) openPerfStatCSV(name string) *os.File {
+	var perfStatCsv *os.File
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		perfStatCsv, err = os.Create(name)
+		if err != nil {
+			r.logger.Println("创建perfstat输出文件失败", err)
+			return nil
+		}
+		_, _ = perfStatCsv.WriteString("groupId,pid,instructions,cycles,allStores,allLoads,LLCMiss,LLCHit,MemAnyCycles,LLCMissCycles,characteristic\n")
+	} else {
+		perfStatCsv, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			r.logger.Println("打开perfstat输出文件失败", err)
+			return nil
+		}
+	}
+	return perfStatCsv
+}
+
+func writePerfStatRow(f *os.File, groupId string, stat *perf.StatResult, characteristic classifier.MemoryCharacteristic) {
+	_, _ = f.WriteString(fmt.Sprintf("%s,%d,%d,%d,%d,%d,%d,%d,%d,%d,%s\n", groupId, stat.Pid, stat.Instructions,
+		stat.Cycles, stat.AllStores, stat.AllLoads, stat.LLCMiss, stat.LLCHit, stat.MemAnyCycles, stat.LLCMissCycles,
+		characteristic))
+}
+
+// 写出分类的依据
+func (r *impl) writeExplanation(name string, explanation *classifier.Explanation) {
+	content, err := json.MarshalIndent(explanation, "", "  ")
+	if err != nil {
+		r.logger.Println("序列化分类依据失败", err)
+		return
+	}
+	if err = ioutil.WriteFile(name, content, 0644); err != nil {
+		r.logger.Println("写入分类依据失败", err)
+	}
+}
+
+// 写出间隔计数的IPC与MPKI趋势
+func (r *impl) writeStatHistory(name string, history *perf.StatHistory) {
+	f, err := os.Create(name)
+	if err != nil {
+		r.logger.Println("创建间隔计数输出文件失败", err)
+		return
+	}
+	writer := bufio.NewWriter(f)
+	_, _ = writer.WriteString("start,end,instructions,cycles,ipc,mpki\n")
+	for _, sample := range history.Samples() {
+		stat := sample.Stat
+		if stat.Instructions == 0 || stat.Cycles == 0 {
+			continue
+		}
+		_, _ = writer.WriteString(fmt.Sprintf("%d,%d,%d,%d,%.4f,%.4f\n", sample.Start.UnixNano(), sample.End.UnixNano(),
+			stat.Instructions, stat.Cycles, stat.InstructionPerCycle(), stat.LLCMissPerKiloInstructions()))
+	}
+	_ = writer.Flush()
+	_ = f.Close()
+}
+
 func (r *impl) classify(ctx context.Context, groupContext *processGroupContext) error {
-	r.logger.Printf("等待 %s 后对 %s 进程组进行分类", core.RootConfig.Manager.ClassifyAfter.String(), groupContext.group.Id)
+	r.logger.Printf("等待 %s 后对 %s 进程组进行分类", core.RootConfig.Manager.ClassifyAfter.String(), groupContext.id)
 	select {
 	case <-time.After(core.RootConfig.Manager.ClassifyAfter):
 	case <-ctx.Done():
 		r.logger.Println("等待分类时被结束")
 		return fmt.Errorf("等待分类时被结束")
 	}
+	return r.runClassification(ctx, groupContext)
+}
 
+// 立即对进程组进行分类，并更新每个进程的特征
+func (r *impl) runClassification(ctx context.Context, groupContext *processGroupContext) error {
+	groupContext.lock.Lock()
 	groupContext.state = processGroupStateClassifying
-	ch := r.classifier.Classify(ctx, groupContext.group)
-	r.logger.Printf("对进程组 %s 进行分类", groupContext.group.Id)
+	group := groupContext.group
+	groupContext.lock.Unlock()
+	ch := r.classifier.Classify(ctx, group)
+	r.logger.Printf("对进程组 %s 进行分类", groupContext.id)
 	result := <-ch // 这里直接等待这个，而没有ctx.Done，因为ctx结束时，理论上会返回结果
 	if result.Error != nil {
-		r.logger.Printf("对进程组 %s 的分类出错： %v", groupContext.group.Id, result.Error)
+		r.logger.Printf("对进程组 %s 的分类出错： %v", groupContext.id, result.Error)
+		groupContext.lock.Lock()
 		groupContext.state = processGroupStateErrored
+		groupContext.lock.Unlock()
 		return result.Error
 	}
+	groupContext.lock.Lock()
+	classified := map[int]struct{}{}
 	for _, processResult := range result.Processes {
-		p := groupContext.processes[processResult.Pid]
-		if processResult.Error != nil {
-			r.logger.Printf("进程组 %s 的进程 %d 监控出错： %v", groupContext.group.Id, processResult.Pid, processResult.Error)
-			p.characteristic = classifier.MemoryCharacteristicToDetermine
-		} else {
-			p.characteristic = processResult.Characteristic
-			p.perfStat = processResult.StatResultAllWays
+		classified[processResult.Pid] = struct{}{}
+		p, ok := groupContext.processes[processResult.Pid]
+		if !ok {
+			// 分类期间退出的进程
+			continue
 		}
+		r.setProcessResult(groupContext, p, processResult)
+	}
+	groupContext.policy = result.Policy
+	groupContext.dominantPid = result.DominantPid
+	groupContext.characteristic = result.Characteristic
+	groupContext.perfStat = result.StatResultAllWays
+	groupContext.explanation = result.Explanation
+	groupContext.bandwidthIntensity = result.BandwidthIntensity
+	if result.Characteristic != classifier.MemoryCharacteristicToDetermine {
+		r.logger.Printf("进程组 %s 按%s策略整体分类为 %s，内存带宽 %.1f MB/s（%s）", groupContext.id, result.Policy,
+			result.Explanation, result.Bandwidth, result.BandwidthIntensity)
 	}
-	r.logger.Printf("进程组 %s 分类完成", groupContext.group.Id)
+	r.logger.Printf("进程组 %s 分类完成", groupContext.id)
 	groupContext.state = processGroupStateRunning
+
+	var added []int
+	for _, pid := range groupContext.group.Pid {
+		if _, ok := classified[pid]; ok {
+			continue
+		}
+		if _, ok := groupContext.processes[pid]; ok {
+			added = append(added, pid)
+		}
+	}
+	groupContext.lock.Unlock()
+	if len(added) != 0 {
+		r.adoptProcesses(ctx, groupContext, added)
+	}
 	return nil
 }
 
-func (r *impl) memTrace(ctx context.Context, group *processGroupContext) {
-	wg := sync.WaitGroup{}
-	for _, c := range group.processes {
-		if c.characteristic == classifier.MemoryCharacteristicSensitive ||
-			c.characteristic == classifier.MemoryCharacteristicMedium {
-			wg.Add(1)
-			go func(p *processCharacteristic) {
-				r.logger.Printf("对进程组 %s 进程 %d 开始内存追踪", group.group.Id, p.pid)
-				consumer := memrecord.NewRTHCalculatorConsumer(memrecord.GetCalculatorFromRootConfig())
-				ch, _ := r.memRecorder.RecordProcess(ctx, &memrecord.AttachRequest{
-					BaseRequest: memrecord.BaseRequest{
-						Consumer: consumer,
-						Name:     fmt.Sprintf("%s-%d", group.group.Id, p.pid),
-					},
-					Pid: p.pid,
-				})
-				result := <-ch
-				if result.Err != nil {
-					r.logger.Printf("对进程组 %s 进程 %d 的内存追踪错误：%v", group.group.Id, p.pid, result.Err)
-					p.mrc = []float32{}
-				} else {
-					p.mrc = WeightedAverageMRC(consumer.GetCalculatorMap(), result.ThreadInstructionCount,
-						result.TotalInstructions, core.RootConfig.MemTrace.MaxRthTime, numWays*numSets)
+// 调用时需持有group.lock
+func (r *impl) setProcessResult(group *processGroupContext, p *processCharacteristic, processResult *classifier.ProcessResult) {
+	if processResult.Error != nil {
+		r.logger.Printf("进程组 %s 的进程 %d 监控出错： %v", group.id, processResult.Pid, processResult.Error)
+		p.characteristic = classifier.MemoryCharacteristicToDetermine
+		p.inherited = false
+		return
+	}
+	p.characteristic = processResult.Characteristic
+	p.perfStat = processResult.StatResultAllWays
+	p.perfStatTwoWays = processResult.StatResultTwoWays
+	p.explanation = processResult.Explanation
+	p.bandwidth = processResult.Bandwidth
+	p.bandwidthIntensity = processResult.BandwidthIntensity
+	p.inherited = false
+	r.logger.Printf("进程组 %s 的进程 %d 分类为 %s，内存带宽 %.1f MB/s（%s）", group.id, processResult.Pid,
+		processResult.Explanation, processResult.Bandwidth, processResult.BandwidthIntensity)
+}
+
+// 进程组分类后新出现的进程先继承进程组的特征与MRC，并放入进程组所在的CLOS。per-process策略下随后在后台对这些进程单独分类
+func (r *impl) adoptProcesses(ctx context.Context, group *processGroupContext, pids []int) {
+	group.lock.Lock()
+	template := inheritTemplate(group)
+	if template == nil {
+		group.lock.Unlock()
+		r.logger.Printf("进程组 %s 没有已分类的进程，新进程 %v 等待重新分类", group.id, pids)
+		return
+	}
+	// 加锁之前可能已经退出的进程
+	var adopted []int
+	for _, pid := range pids {
+		if p, ok := group.processes[pid]; ok {
+			inheritCharacteristic(group, template, p)
+			adopted = append(adopted, pid)
+		}
+	}
+	templatePid := template.pid
+	perProcess := group.characteristic == classifier.MemoryCharacteristicToDetermine
+	group.lock.Unlock()
+	if len(adopted) == 0 {
+		return
+	}
+	r.logger.Printf("进程组 %s 的新进程 %v 继承进程 %d 的特征", group.id, adopted, templatePid)
+
+	r.schemeLock.Lock()
+	if schemes, scheme := addToCLOS(r.currentSchemes, templatePid, adopted); scheme != nil {
+		// WayBit与MemThrottle为0时只绑定进程，不修改CLOS的设置
+		err := pqos.SetCLOSScheme([]*pqos.CLOSScheme{{CLOSNum: scheme.CLOSNum, Processes: adopted}})
+		if err != nil {
+			r.logger.Printf("无法将进程组 %s 的新进程放入CLOS %d：%v", group.id, scheme.CLOSNum, err)
+		} else {
+			r.currentSchemes = schemes
+		}
+	}
+	r.schemeLock.Unlock()
+	if perProcess {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.classifyNewProcesses(ctx, group, adopted)
+		}()
+	}
+}
+
+// 对进程组中新出现的进程单独分类，完成后追踪MRC并请求再分配。分类期间进程保持继承的特征，分类失败时继续使用
+func (r *impl) classifyNewProcesses(ctx context.Context, group *processGroupContext, pids []int) {
+	r.logger.Printf("对进程组 %s 的新进程 %v 进行分类", group.id, pids)
+	// 不使用cgroup，只对新进程计数
+	result := <-r.classifier.Classify(ctx, &core.ProcessGroup{Id: group.id, Pid: pids})
+	if result.Error != nil {
+		r.logger.Printf("对进程组 %s 的新进程分类出错，继续使用继承的特征：%v", group.id, result.Error)
+		return
+	}
+	var traced []*processCharacteristic
+	group.lock.Lock()
+	for _, processResult := range result.Processes {
+		p, ok := group.processes[processResult.Pid]
+		if !ok {
+			continue
+		}
+		if processResult.Error != nil {
+			r.logger.Printf("进程组 %s 的新进程 %d 分类出错，继续使用继承的特征：%v", group.id, processResult.Pid,
+				processResult.Error)
+			continue
+		}
+		r.setProcessResult(group, p, processResult)
+		p.mrc = nil
+		p.mrcPartial = false
+		traced = append(traced, p)
+	}
+	group.lock.Unlock()
+	r.memTraceProcesses(ctx, group, traced, true)
+	r.reAllocTimerRoutine.requestRun()
+}
+
+// 持续按间隔计数，保存到每个进程的历史中，直到ctx结束或者进程组的进程都退出。有新进程加入时重新启动间隔计数，使新进程也被监控。
+// 启用阶段检测时，任意进程进入新的阶段后对整个进程组重新分类并重新追踪MRC
+func (r *impl) monitorStats(ctx context.Context, group *processGroupContext, interval time.Duration) {
+	phaseConfig := core.RootConfig.Manager.Phase
+	detectors := map[int]*classifier.PhaseDetector{}
+	lastClassify := time.Now()
+	skip := false
+	for {
+		group.lock.Lock()
+		processGroup := group.group
+		group.lock.Unlock()
+		runCtx, stopRun := context.WithCancel(ctx)
+		ch := perf.NewIntervalStatRunnerFromRootConfig(processGroup).StartInterval(runCtx, interval)
+		restart := false
+		for running := true; running; {
+			var res *perf.IntervalResult
+			select {
+			case <-group.pidsChanged:
+				// 结束当前的间隔计数，处理完剩余的结果后重新启动
+				restart = true
+				stopRun()
+				continue
+			case res, running = <-ch:
+				if !running {
+					continue
+				}
+			}
+			var change *classifier.PhaseChange
+			var changedPid int
+			for pid, stat := range res.Results {
+				if stat.Error != nil {
+					if !restart {
+						r.logger.Printf("进程组 %s 进程 %d 的间隔计数结束：%v", group.id, pid, stat.Error)
+					}
+					delete(detectors, pid)
+					continue
+				}
+				group.lock.Lock()
+				p, ok := group.processes[pid]
+				group.lock.Unlock()
+				if !ok {
+					continue
+				}
+				p.history.Add(perf.StatSample{Start: res.Start, End: res.End, Stat: stat})
+				if !phaseConfig.Enable || skip {
+					continue
 				}
-				wg.Done()
-			}(c)
+				detector, ok := detectors[pid]
+				if !ok {
+					detector = classifier.NewPhaseDetector(phaseConfig)
+					detectors[pid] = detector
+				}
+				if c := detector.Add(stat); c != nil && change == nil {
+					change, changedPid = c, pid
+				}
+			}
+			// 重新分类与追踪期间进程的行为受到干扰，丢弃之后的第一个间隔
+			skip = false
+			if change == nil || restart {
+				continue
+			}
+			r.logger.Printf("进程组 %s 进程 %d 进入新的阶段：%s", group.id, changedPid, change)
+			if time.Since(lastClassify) < phaseConfig.CoolDown {
+				r.logger.Printf("进程组 %s 距离上次分类不足 %s，不重新分类", group.id, phaseConfig.CoolDown)
+				continue
+			}
+			r.reclassify(ctx, group)
+			lastClassify = time.Now()
+			for _, detector := range detectors {
+				detector.Reset()
+			}
+			skip = true
 		}
+		stopRun()
+		// 进程都退出后又有新进程加入
+		if !restart {
+			select {
+			case <-group.pidsChanged:
+				restart = true
+			default:
+			}
+		}
+		if !restart || ctx.Err() != nil {
+			return
+		}
+		r.logger.Printf("进程组 %s 有新进程加入，重新启动间隔计数", group.id)
+	}
+}
+
+// 对进程组重新分类，并不使用缓存重新追踪MRC
+func (r *impl) reclassify(ctx context.Context, group *processGroupContext) {
+	r.logger.Printf("对进程组 %s 重新分类", group.id)
+	if err := r.runClassification(ctx, group); err != nil {
+		return
+	}
+	group.lock.Lock()
+	for _, p := range group.processes {
+		p.mrc = nil
+		p.mrcPartial = false
+		p.inherited = false
+	}
+	group.lock.Unlock()
+	r.memTrace(ctx, group, false)
+	r.reAllocTimerRoutine.requestRun()
+}
+
+// 从MRC缓存中读取进程的MRC，供分类器估计缓存敏感度。过期的记录也会使用，没有缓存时返回nil
+func (r *impl) cachedMRC(pid int) []float32 {
+	if r.mrcCache == nil {
+		return nil
+	}
+	id, err := getProgramIdentity(pid)
+	if err != nil {
+		return nil
+	}
+	mrc, _, ok := r.mrcCache.get(id, numWays*numSets)
+	if !ok {
+		return nil
+	}
+	return mrc
+}
+
+// 对需要MRC的进程进行内存追踪。useCache为false时不读取MRC缓存，追踪结果仍然会写入缓存
+func (r *impl) memTrace(ctx context.Context, group *processGroupContext, useCache bool) {
+	group.lock.Lock()
+	processes := make([]*processCharacteristic, 0, len(group.processes))
+	for _, p := range group.processes {
+		processes = append(processes, p)
+	}
+	group.lock.Unlock()
+	r.memTraceProcesses(ctx, group, processes, useCache)
+}
+
+// 设置进程的MRC
+func (g *processGroupContext) setMRC(p *processCharacteristic, mrc []float32, partial bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	p.mrc = mrc
+	p.mrcPartial = partial
+}
+
+// 对processes中需要MRC的进程进行内存追踪
+func (r *impl) memTraceProcesses(ctx context.Context, group *processGroupContext, processes []*processCharacteristic, useCache bool) {
+	var traced []*processCharacteristic
+	group.lock.Lock()
+	for _, p := range processes {
+		if group.needsMRC(p) {
+			traced = append(traced, p)
+		}
+	}
+	group.lock.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, c := range traced {
+		wg.Add(1)
+		go func(p *processCharacteristic) {
+			defer wg.Done()
+			var id *programIdentity
+			if r.mrcCache != nil {
+				var err error
+				id, err = getProgramIdentity(p.pid)
+				if err != nil {
+					r.logger.Printf("无法获取进程组 %s 进程 %d 的程序标识，将不使用MRC缓存：%v", group.id, p.pid, err)
+				} else if useCache {
+					if mrc, stale, ok := r.mrcCache.get(id, numWays*numSets); ok {
+						// 部分结果的缓存视为过期
+						if !stale {
+							r.logger.Printf("进程组 %s 进程 %d 命中MRC缓存：%s", group.id, p.pid, id)
+							group.setMRC(p, mrc, false)
+							return
+						}
+						if core.RootConfig.MemTrace.MRCCache.RefreshInBackground {
+							r.logger.Printf("进程组 %s 进程 %d 的MRC缓存已过期，先使用旧的MRC并在后台重新追踪", group.id, p.pid)
+							group.setMRC(p, mrc, false)
+							if r.mrcCache.startRefresh(id) {
+								r.wg.Add(1)
+								go func() {
+									defer r.wg.Done()
+									r.refreshMRC(ctx, group, p, id)
+								}()
+							}
+							return
+						}
+					}
+				}
+			}
+
+			mrc, partial, err := r.traceMRC(ctx, group, p)
+			if err != nil {
+				r.logger.Printf("对进程组 %s 进程 %d 的内存追踪错误：%v", group.id, p.pid, err)
+				group.setMRC(p, []float32{}, false)
+				return
+			}
+			group.setMRC(p, mrc, partial)
+			if id != nil {
+				if err = r.mrcCache.put(id, mrc, partial); err != nil {
+					r.logger.Printf("保存进程组 %s 进程 %d 的MRC缓存出错：%v", group.id, p.pid, err)
+				}
+			}
+		}(c)
 	}
 	wg.Wait()
 }
 
+// 对一个进程进行内存追踪并计算MRC。追踪受配置的限制约束，达到限制时使用部分结果计算MRC，并返回partial为true
+func (r *impl) traceMRC(ctx context.Context, group *processGroupContext, p *processCharacteristic) (mrc []float32, partial bool, err error) {
+	r.logger.Printf("对进程组 %s 进程 %d 开始内存追踪", group.id, p.pid)
+	limit := core.RootConfig.MemTrace.Limit
+	consumer := memrecord.GetConsumerFromRootConfig()
+	ch, err := r.memRecorder.RecordProcess(ctx, &memrecord.AttachRequest{
+		BaseRequest: memrecord.BaseRequest{
+			Consumer: consumer,
+			Name:     fmt.Sprintf("%s-%d", group.id, p.pid),
+		},
+		Pid: p.pid,
+		Limits: memrecord.TraceLimits{
+			MaxDuration:  limit.MaxDuration,
+			MaxSlowdown:  limit.MaxSlowdown,
+			MaxAddresses: limit.MaxAddresses,
+		},
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	result := <-ch
+	if result.Err != nil {
+		return nil, false, result.Err
+	}
+	if result.TotalSamples == 0 {
+		return nil, false, fmt.Errorf("追踪提前结束（%s），没有采集到地址", result.StopReason)
+	}
+	if result.Partial {
+		r.logger.Printf("进程组 %s 进程 %d 的追踪提前结束（%s），使用已采集的 %d 条地址计算MRC", group.id, p.pid,
+			result.StopReason, result.TotalSamples)
+	}
+	return ProcessMRC(consumer, result, core.RootConfig.MemTrace.MaxRthTime, numWays*numSets), result.Partial, nil
+}
+
+// 后台重新追踪过期的MRC，完成后更新缓存并请求再分配
+func (r *impl) refreshMRC(ctx context.Context, group *processGroupContext, p *processCharacteristic, id *programIdentity) {
+	defer r.mrcCache.finishRefresh(id)
+	mrc, partial, err := r.traceMRC(ctx, group, p)
+	if err != nil {
+		r.logger.Printf("后台刷新进程组 %s 进程 %d 的MRC出错，继续使用旧的MRC：%v", group.id, p.pid, err)
+		return
+	}
+	group.setMRC(p, mrc, partial)
+	if err = r.mrcCache.put(id, mrc, partial); err != nil {
+		r.logger.Printf("保存进程组 %s 进程 %d 的MRC缓存出错：%v", group.id, p.pid, err)
+	}
+	r.logger.Printf("进程组 %s 进程 %d 的MRC已在后台刷新", group.id, p.pid)
+	r.reAllocTimerRoutine.requestRun()
+}
+
 func (r *impl) Run() error {
 	pqos.PqosInit()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -307,6 +785,11 @@ func (r *impl) Run() error {
 	// 注册进程监视函数
 	watchChannel := r.watcher.Watch()
 	r.reAllocTimerRoutine.start(ctx)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.reportStatus(ctx)
+	}()
 
 	for {
 		select {
@@ -314,7 +797,7 @@ func (r *impl) Run() error {
 			signal.Ignore(sig) // 防止重复进入本函数
 			if sig == syscall.SIGTERM || sig == syscall.SIGINT || sig == syscall.SIGQUIT {
 				r.logger.Println("接收到结束信号，正在关闭并回收所有资源")
-				r.gracefulShutdown()
+				r.gracefulShutdown(cancel)
 				return nil
 			} else if sig == syscall.SIGKILL {
 				r.logger.Println("接收到中止信号，正在强制退出")
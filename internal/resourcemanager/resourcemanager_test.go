@@ -1 +1,243 @@
 package resourcemanager
+
+import (
+	"context"
+	"github.com/packagewjx/resourcemanager/internal/classifier"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/pqos"
+	"github.com/packagewjx/resourcemanager/internal/resourcemanager/watcher"
+	"github.com/packagewjx/resourcemanager/internal/sampler/perf"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestInheritCharacteristic(t *testing.T) {
+	dominant := &processCharacteristic{pid: 1, characteristic: classifier.MemoryCharacteristicSensitive,
+		mrc: []float32{1, 0.5}, perfStat: &perf.StatResult{Pid: 1, Instructions: 100}, bandwidth: 10,
+		bandwidthIntensity: classifier.BandwidthIntensityLow}
+	group := &processGroupContext{
+		group:       &core.ProcessGroup{Id: "group", Pid: []int{1, 2, 3}},
+		processes:   map[int]*processCharacteristic{1: dominant, 2: {pid: 2}, 3: {pid: 3}},
+		policy:      core.GroupPolicyPerProcess,
+		dominantPid: 1,
+	}
+	assert.Same(t, dominant, inheritTemplate(group))
+
+	p := group.processes[2]
+	inheritCharacteristic(group, dominant, p)
+	assert.True(t, p.inherited)
+	assert.Equal(t, classifier.MemoryCharacteristicSensitive, p.characteristic)
+	assert.Equal(t, dominant.mrc, p.mrc)
+	assert.Equal(t, 2, p.perfStat.Pid)
+	assert.Equal(t, 1, dominant.perfStat.Pid)
+	assert.Equal(t, classifier.BandwidthIntensityLow, p.bandwidthIntensity)
+
+	// 指令数最多的进程退出后使用其他自己分类过的进程，不使用继承的进程
+	delete(group.processes, 1)
+	assert.Nil(t, inheritTemplate(group))
+	group.processes[3].characteristic = classifier.MemoryCharacteristicMedium
+	assert.Same(t, group.processes[3], inheritTemplate(group))
+
+	// 进程组整体分类时继承进程组的分类
+	group.processes[1] = dominant
+	group.policy = core.GroupPolicyDominant
+	group.characteristic = classifier.MemoryCharacteristicBully
+	group.bandwidthIntensity = classifier.BandwidthIntensityHigh
+	p = &processCharacteristic{pid: 4}
+	inheritCharacteristic(group, dominant, p)
+	assert.Equal(t, classifier.MemoryCharacteristicBully, p.characteristic)
+	assert.Equal(t, dominant.mrc, p.mrc)
+	assert.Equal(t, classifier.BandwidthIntensityHigh, p.bandwidthIntensity)
+	assert.Nil(t, p.perfStat)
+}
+
+func TestFindCLOS(t *testing.T) {
+	schemes := []*pqos.CLOSScheme{
+		{CLOSNum: 0, Processes: []int{1}},
+		{CLOSNum: 2, Processes: []int{2, 3}},
+	}
+	assert.Same(t, schemes[1], findCLOS(schemes, 3))
+	assert.Nil(t, findCLOS(schemes, 4))
+	assert.Nil(t, findCLOS(nil, 1))
+}
+
+func TestAddToCLOS(t *testing.T) {
+	processes := make([]int, 2, 4)
+	processes[0], processes[1] = 2, 3
+	schemes := []*pqos.CLOSScheme{
+		{CLOSNum: 0, Processes: []int{1}},
+		{CLOSNum: 2, WayBit: 0xf, MemThrottle: 100, Processes: processes},
+	}
+	newSchemes, scheme := addToCLOS(schemes, 3, []int{4, 5})
+	assert.Equal(t, &pqos.CLOSScheme{CLOSNum: 2, WayBit: 0xf, MemThrottle: 100, Processes: []int{2, 3, 4, 5}}, scheme)
+	assert.Same(t, schemes[0], newSchemes[0])
+	assert.Same(t, scheme, newSchemes[1])
+	// 原来的方案与其进程列表不变
+	assert.Equal(t, []int{2, 3}, schemes[1].Processes)
+	assert.Equal(t, []int{2, 3, 0, 0}, processes[:4])
+
+	newSchemes, scheme = addToCLOS(schemes, 6, []int{7})
+	assert.Nil(t, newSchemes)
+	assert.Nil(t, scheme)
+}
+
+func TestHandleProcessStatusUpdate(t *testing.T) {
+	r := &impl{
+		processGroups: (*processGroupMap)(&sync.Map{}),
+		logger:        log.New(ioutil.Discard, "", 0),
+	}
+	newGroup := func(state processGroupState) *processGroupContext {
+		return &processGroupContext{
+			id:    string(state),
+			group: &core.ProcessGroup{Id: string(state), Pid: []int{1, 2}},
+			processes: map[int]*processCharacteristic{
+				1: {pid: 1, characteristic: classifier.MemoryCharacteristicSensitive, mrc: []float32{1, 0.5}},
+				2: {pid: 2, characteristic: classifier.MemoryCharacteristicSensitive, mrc: []float32{1, 0.5}},
+			},
+			state:          state,
+			ctx:            context.Background(),
+			pidsChanged:    make(chan struct{}, 1),
+			policy:         core.GroupPolicyDominant,
+			dominantPid:    1,
+			characteristic: classifier.MemoryCharacteristicSensitive,
+		}
+	}
+	update := func(id string, pid ...int) {
+		r.handleProcessStatus(context.Background(), &watcher.ProcessGroupStatus{
+			Group:  core.ProcessGroup{Id: id, Pid: pid},
+			Status: watcher.ProcessGroupStatusUpdate,
+		})
+	}
+
+	// 还没有分类时新进程等待分类
+	waiting := newGroup(processGroupStateNew)
+	r.processGroups.store(waiting)
+	update(waiting.group.Id, 1, 3)
+	assert.Equal(t, []int{1, 3}, waiting.group.Pid)
+	assert.NotContains(t, waiting.processes, 2)
+	if assert.Contains(t, waiting.processes, 3) {
+		assert.Equal(t, classifier.MemoryCharacteristicToDetermine, waiting.processes[3].characteristic)
+		assert.NotNil(t, waiting.processes[3].history)
+	}
+
+	// 分类后新进程立即继承进程组的特征与MRC
+	running := newGroup(processGroupStateRunning)
+	r.processGroups.store(running)
+	update(running.group.Id, 1, 2, 3)
+	assert.Equal(t, []int{1, 2, 3}, running.group.Pid)
+	assert.Len(t, running.pidsChanged, 1)
+	if assert.Contains(t, running.processes, 3) {
+		p := running.processes[3]
+		assert.True(t, p.inherited)
+		assert.Equal(t, classifier.MemoryCharacteristicSensitive, p.characteristic)
+		assert.Equal(t, []float32{1, 0.5}, p.mrc)
+	}
+	plan := r.processGroups.planAllocation(false)
+	for _, program := range plan.managed {
+		if program.Pid == 1 {
+			assert.Equal(t, []int{1, 2, 3}, program.GroupPids)
+		}
+	}
+}
+
+func TestHandleProcessStatusUpdateConcurrent(t *testing.T) {
+	r := &impl{
+		processGroups: (*processGroupMap)(&sync.Map{}),
+		logger:        log.New(ioutil.Discard, "", 0),
+	}
+	group := &processGroupContext{
+		id:    "group",
+		group: &core.ProcessGroup{Id: "group", Pid: []int{1}},
+		processes: map[int]*processCharacteristic{
+			1: {pid: 1, characteristic: classifier.MemoryCharacteristicSensitive, mrc: []float32{1, 0.5}},
+		},
+		state:          processGroupStateRunning,
+		ctx:            context.Background(),
+		pidsChanged:    make(chan struct{}, 1),
+		policy:         core.GroupPolicyDominant,
+		dominantPid:    1,
+		characteristic: classifier.MemoryCharacteristicSensitive,
+	}
+	r.processGroups.store(group)
+
+	// 进程组更新的同时计算分配方案与追踪MRC，使用-race运行时检查数据竞争
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.reAllocTimerRoutine = newTimerRoutine(0, 0, func() {
+		r.processGroups.planAllocation(true)
+	})
+	r.reAllocTimerRoutine.start(ctx)
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			r.processGroups.planAllocation(true)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			r.memTraceProcesses(ctx, group, nil, true)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		pids := []int{1}
+		for pid := 2; pid < 2+i%5; pid++ {
+			pids = append(pids, pid)
+		}
+		r.handleProcessStatus(context.Background(), &watcher.ProcessGroupStatus{
+			Group:  core.ProcessGroup{Id: "group", Pid: pids},
+			Status: watcher.ProcessGroupStatusUpdate,
+		})
+	}
+	cancel()
+	wg.Wait()
+	group.lock.Lock()
+	defer group.lock.Unlock()
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, group.group.Pid)
+	assert.Len(t, group.processes, 5)
+}
+
+func TestWriteStatus(t *testing.T) {
+	r := &impl{
+		processGroups: (*processGroupMap)(&sync.Map{}),
+		logger:        log.New(ioutil.Discard, "", 0),
+	}
+	explanation := &classifier.Explanation{Characteristic: classifier.MemoryCharacteristicSensitive, Default: true}
+	r.processGroups.store(&processGroupContext{
+		id:    "b",
+		group: &core.ProcessGroup{Id: "b"},
+		state: processGroupStateRunning,
+		processes: map[int]*processCharacteristic{
+			3: {pid: 3, characteristic: classifier.MemoryCharacteristicSensitive, explanation: explanation},
+			2: {pid: 2, characteristic: classifier.MemoryCharacteristicSensitive, inherited: true},
+		},
+	})
+	r.processGroups.store(&processGroupContext{id: "a", group: &core.ProcessGroup{Id: "a"},
+		state: processGroupStateClassifying, processes: map[int]*processCharacteristic{}})
+
+	dir, err := ioutil.TempDir(os.TempDir(), "tmp.status.*")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "status.json")
+	assert.NoError(t, r.writeStatus(path))
+	status, err := ReadStatus(path)
+	assert.NoError(t, err)
+	if assert.Len(t, status.Groups, 2) {
+		assert.Equal(t, "a", status.Groups[0].Id)
+		assert.Equal(t, string(processGroupStateClassifying), status.Groups[0].State)
+		group := status.Groups[1]
+		assert.Equal(t, []*ProcessStatus{
+			{Pid: 2, Characteristic: classifier.MemoryCharacteristicSensitive, Inherited: true},
+			{Pid: 3, Characteristic: classifier.MemoryCharacteristicSensitive, Explanation: explanation},
+		}, group.Processes)
+	}
+}
@@ -0,0 +1,119 @@
+package resourcemanager
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/packagewjx/resourcemanager/internal/classifier"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 运行中的管理器的分类状态，定期写入Manager.StatusFile，可以使用status命令查看
+type Status struct {
+	Time   time.Time      `json:"time"`
+	Groups []*GroupStatus `json:"groups"`
+}
+
+type GroupStatus struct {
+	Id             string                          `json:"id"`
+	State          string                          `json:"state"`
+	Characteristic classifier.MemoryCharacteristic `json:"characteristic,omitempty"` // 进程组整体分类时的分类
+	Explanation    *classifier.Explanation         `json:"explanation,omitempty"`
+	Processes      []*ProcessStatus                `json:"processes"`
+}
+
+type ProcessStatus struct {
+	Pid            int                             `json:"pid"`
+	Characteristic classifier.MemoryCharacteristic `json:"characteristic"`
+	Explanation    *classifier.Explanation         `json:"explanation,omitempty"`
+	Inherited      bool                            `json:"inherited,omitempty"` // 特征继承自进程组，还没有单独分类
+}
+
+// 当前所有进程组的分类结果与分类依据，按进程组Id与pid排序
+func (r *impl) status() *Status {
+	status := &Status{Time: time.Now()}
+	r.processGroups.traverse(func(name string, group *processGroupContext) bool {
+		group.lock.Lock()
+		defer group.lock.Unlock()
+		groupStatus := &GroupStatus{
+			Id:             group.id,
+			State:          string(group.state),
+			Characteristic: group.characteristic,
+			Explanation:    group.explanation,
+		}
+		for pid, p := range group.processes {
+			groupStatus.Processes = append(groupStatus.Processes, &ProcessStatus{
+				Pid:            pid,
+				Characteristic: p.characteristic,
+				Explanation:    p.explanation,
+				Inherited:      p.inherited,
+			})
+		}
+		sort.Slice(groupStatus.Processes, func(i, j int) bool {
+			return groupStatus.Processes[i].Pid < groupStatus.Processes[j].Pid
+		})
+		status.Groups = append(status.Groups, groupStatus)
+		return true
+	})
+	sort.Slice(status.Groups, func(i, j int) bool {
+		return status.Groups[i].Id < status.Groups[j].Id
+	})
+	return status
+}
+
+// 写入状态文件。先写入临时文件再重命名，读取的一方不会读到写了一半的文件
+func (r *impl) writeStatus(path string) error {
+	content, err := json.MarshalIndent(r.status(), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "序列化状态出错")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp.status.*")
+	if err != nil {
+		return errors.Wrap(err, "创建状态文件出错")
+	}
+	_, err = tmp.Write(content)
+	_ = tmp.Close()
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "写入状态文件出错")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "写入状态文件出错")
+}
+
+// 按StatusInterval定期写入状态文件，直到ctx结束。StatusFile为空时不写入
+func (r *impl) reportStatus(ctx context.Context) {
+	config := core.RootConfig.Manager
+	if config.StatusFile == "" || config.StatusInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(config.StatusInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.writeStatus(config.StatusFile); err != nil {
+			r.logger.Printf("写入状态文件 %s 出错：%v", config.StatusFile, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 读取运行中的管理器写入的状态文件
+func ReadStatus(path string) (*Status, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取状态文件出错")
+	}
+	status := &Status{}
+	if err = json.Unmarshal(content, status); err != nil {
+		return nil, errors.Wrap(err, "解析状态文件出错")
+	}
+	return status, nil
+}
@@ -13,11 +13,79 @@ import (
 
 type processGroupMap sync.Map
 
+// lock保护group、processes、state、cancelManageFunc、分类结果以及processes中每个进程的特征与MRC。
+// 持有lock时不进行分类、追踪等耗时的操作
 type processGroupContext struct {
+	lock             sync.Mutex
+	id               string // 进程组的Id，不会改变，不需要加锁
 	group            *core.ProcessGroup
 	processes        map[int]*processCharacteristic
 	state            processGroupState
 	cancelManageFunc context.CancelFunc
+	ctx              context.Context // 管理进程组的协程使用的ctx，进程组移除时结束
+	pidsChanged      chan struct{}   // 有新进程加入时通知monitorStats重新启动间隔计数，容量为1
+	policy           core.GroupPolicy
+	dominantPid      int
+	// 进程组整体的分类结果，per-process策略下characteristic为空，按每个进程的分类分配
+	characteristic     classifier.MemoryCharacteristic
+	perfStat           *perf.StatResult
+	explanation        *classifier.Explanation
+	bandwidthIntensity classifier.BandwidthIntensity
+}
+
+// 进程是否需要追踪MRC。进程组整体分类时由进程组的分类决定，dominant策略只追踪指令数最多的进程
+func (g *processGroupContext) needsMRC(p *processCharacteristic) bool {
+	characteristic := p.characteristic
+	if g.characteristic != classifier.MemoryCharacteristicToDetermine {
+		characteristic = g.characteristic
+	}
+	if characteristic != classifier.MemoryCharacteristicSensitive && characteristic != classifier.MemoryCharacteristicMedium {
+		return false
+	}
+	return g.policy != core.GroupPolicyDominant || p.pid == g.dominantPid
+}
+
+// 进程组整体分配时使用的MRC。dominant策略使用指令数最多的进程的MRC，aggregate策略按LLC访问次数对各进程的MRC加权平均。
+// 没有MRC时返回nil
+func (g *processGroupContext) groupMRC() []float32 {
+	if g.policy == core.GroupPolicyDominant {
+		if p, ok := g.processes[g.dominantPid]; ok && len(p.mrc) > 0 {
+			return p.mrc
+		}
+		return nil
+	}
+	var mrcs [][]float32
+	var weights []float64
+	totalWeight := 0.0
+	length := 0
+	for _, pid := range g.group.Pid {
+		p, ok := g.processes[pid]
+		if !ok || len(p.mrc) == 0 || p.perfStat == nil {
+			continue
+		}
+		weight := float64(p.perfStat.LLCHit + p.perfStat.LLCMiss)
+		if length == 0 || len(p.mrc) < length {
+			length = len(p.mrc)
+		}
+		mrcs = append(mrcs, p.mrc)
+		weights = append(weights, weight)
+		totalWeight += weight
+	}
+	if len(mrcs) == 0 {
+		return nil
+	}
+	res := make([]float32, length)
+	for i, mrc := range mrcs {
+		// 都没有访问LLC时平均
+		weight := 1 / float64(len(mrcs))
+		if totalWeight > 0 {
+			weight = weights[i] / totalWeight
+		}
+		for j := range res {
+			res[j] += float32(float64(mrc[j]) * weight)
+		}
+	}
+	return res
 }
 
 func (m *processGroupMap) get(name string) (*processGroupContext, bool) {
@@ -47,19 +115,56 @@ func (m *processGroupMap) traverse(s func(name string, group *processGroupContex
 	})
 }
 
-func (m *processGroupMap) getProgramMetricList() []*algorithm.ProgramMetric {
-	res := make([]*algorithm.ProgramMetric, 0, 10)
+// 按每个进程或者进程组的分类决定再分配时的去向。正在分类或者分类出错的进程组不参与再分配
+func (m *processGroupMap) planAllocation(throttle bool) *allocationPlan {
+	plan := &allocationPlan{}
 	m.traverse(func(name string, group *processGroupContext) bool {
-		for pid, characteristic := range group.processes {
-			res = append(res, &algorithm.ProgramMetric{
-				Pid:      pid,
-				MRC:      characteristic.mrc,
-				PerfStat: characteristic.perfStat,
-			})
+		group.lock.Lock()
+		defer group.lock.Unlock()
+		if group.state == processGroupStateClassifying || group.state == processGroupStateErrored {
+			return true
 		}
+		if group.characteristic == classifier.MemoryCharacteristicToDetermine {
+			for _, pid := range group.group.Pid {
+				p, ok := group.processes[pid]
+				if !ok {
+					continue
+				}
+				plan.add(allocationOf(p, throttle), &algorithm.ProgramMetric{
+					Pid:      pid,
+					MRC:      p.mrc,
+					PerfStat: p.perfStat,
+				})
+			}
+			return true
+		}
+
+		// 进程组整体分配到同一个CLOS
+		var pids []int
+		for _, pid := range group.group.Pid {
+			if _, ok := group.processes[pid]; ok {
+				pids = append(pids, pid)
+			}
+		}
+		if len(pids) == 0 {
+			return true
+		}
+		p := &processCharacteristic{
+			pid:                group.dominantPid,
+			characteristic:     group.characteristic,
+			mrc:                group.groupMRC(),
+			perfStat:           group.perfStat,
+			bandwidthIntensity: group.bandwidthIntensity,
+		}
+		plan.add(allocationOf(p, throttle), &algorithm.ProgramMetric{
+			Pid:       p.pid,
+			MRC:       p.mrc,
+			PerfStat:  p.perfStat,
+			GroupPids: pids,
+		})
 		return true
 	})
-	return res
+	return plan
 }
 
 type ResourceManager interface {
@@ -71,19 +176,68 @@ type Config struct {
 }
 
 type processCharacteristic struct {
-	pid            int
-	characteristic classifier.MemoryCharacteristic
-	mrc            []float32
-	perfStat       *perf.StatResult
+	pid                int
+	characteristic     classifier.MemoryCharacteristic
+	mrc                []float32
+	mrcPartial         bool // mrc由提前结束的追踪得到
+	perfStat           *perf.StatResult
+	perfStatTwoWays    *perf.StatResult        // 分类时限制way的计数，model模式下为估计值
+	explanation        *classifier.Explanation // 分类的依据，分类完成后不再修改
+	history            *perf.StatHistory       // 分类后的间隔计数，可以被多个协程同时使用
+	bandwidth          float64                 // 分类时估计的内存带宽，单位为MB/s
+	bandwidthIntensity classifier.BandwidthIntensity
+	inherited          bool // 特征与MRC继承自进程组，还没有单独分类
 }
 
 func (p *processCharacteristic) Clone() core.Cloneable {
 	newMrc := make([]float32, len(p.mrc))
 	copy(newMrc, p.mrc)
 	return &processCharacteristic{
-		pid:            p.pid,
-		characteristic: p.characteristic,
-		mrc:            newMrc,
-		perfStat:       p.perfStat.Clone().(*perf.StatResult),
+		pid:                p.pid,
+		characteristic:     p.characteristic,
+		mrc:                newMrc,
+		mrcPartial:         p.mrcPartial,
+		perfStat:           p.perfStat.Clone().(*perf.StatResult),
+		perfStatTwoWays:    p.perfStatTwoWays.Clone().(*perf.StatResult),
+		explanation:        p.explanation,
+		history:            p.history,
+		bandwidth:          p.bandwidth,
+		bandwidthIntensity: p.bandwidthIntensity,
+		inherited:          p.inherited,
+	}
+}
+
+// 新进程继承特征的来源，调用时需持有group.lock。优先使用指令数最多的进程，其次是组内任意一个自己分类过的进程。没有时返回nil
+func inheritTemplate(group *processGroupContext) *processCharacteristic {
+	if p, ok := group.processes[group.dominantPid]; ok && p.characteristic != classifier.MemoryCharacteristicToDetermine {
+		return p
+	}
+	for _, pid := range group.group.Pid {
+		p, ok := group.processes[pid]
+		if ok && !p.inherited && p.characteristic != classifier.MemoryCharacteristicToDetermine {
+			return p
+		}
+	}
+	return nil
+}
+
+// 进程组整体分类时继承进程组的特征与MRC，否则继承template的特征、MRC与计数，使进程在单独分类前也可以由DCAPS分配
+func inheritCharacteristic(group *processGroupContext, template, p *processCharacteristic) {
+	p.inherited = true
+	if group.characteristic != classifier.MemoryCharacteristicToDetermine {
+		p.characteristic = group.characteristic
+		p.mrc = group.groupMRC()
+		p.bandwidthIntensity = group.bandwidthIntensity
+		return
+	}
+	p.characteristic = template.characteristic
+	p.mrc = template.mrc
+	p.mrcPartial = template.mrcPartial
+	p.bandwidth = template.bandwidth
+	p.bandwidthIntensity = template.bandwidthIntensity
+	if template.perfStat != nil {
+		stat := template.perfStat.Clone().(*perf.StatResult)
+		stat.Pid = p.pid
+		p.perfStat = stat
 	}
 }
@@ -2,14 +2,45 @@ package resourcemanager
 
 import (
 	"github.com/packagewjx/resourcemanager/internal/algorithm"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/sampler/memrecord"
+	"log"
 )
 
+// 根据配置的合成方式计算进程的MRC。若配置为交织方式但Consumer没有进程级RTH，则退回加权平均
+func ProcessMRC(consumer memrecord.RTHCalculatorConsumer, result *memrecord.Result, maxRTH, cacheSize int) []float32 {
+	if core.RootConfig.MemTrace.MRCComposition == core.MRCCompositionInterleaved {
+		if process := consumer.GetProcessCalculator(); process != nil {
+			return InterleavedMRC(process, maxRTH, cacheSize)
+		}
+		log.Printf("Consumer没有进程级RTH，使用加权平均计算MRC")
+	}
+	return WeightedAverageMRC(consumer.GetCalculatorMap(), result.ThreadInstructionCount, result.TotalInstructions,
+		maxRTH, cacheSize)
+}
+
+// 使用所有线程交织得到的进程级RTH计算MRC
+func InterleavedMRC(process algorithm.RTHCalculator, maxRTH, cacheSize int) []float32 {
+	model := newMRCModel(process.GetRTH(maxRTH))
+	return model.MRC(cacheSize)
+}
+
 // 给所有线程计算的加权平均MRC
 func WeightedAverageMRC(cMap map[int]algorithm.RTHCalculator, threadCount map[int]uint64, totalCount uint64, maxRTH, cacheSize int) []float32 {
-	model := algorithm.NewAETModel(WeightedAverageRTH(cMap, threadCount, totalCount, maxRTH))
+	model := newMRCModel(WeightedAverageRTH(cMap, threadCount, totalCount, maxRTH))
 	return model.MRC(cacheSize)
 }
 
+// 根据配置的模型类型构建MRC模型，配置错误时使用AET模型
+func newMRCModel(rth []int) algorithm.AETModel {
+	model, err := algorithm.NewMRCModel(core.RootConfig.MemTrace.MRCModel, rth)
+	if err != nil {
+		log.Printf("%v，使用AET模型计算MRC", err)
+		return algorithm.NewAETModel(rth)
+	}
+	return model
+}
+
 func WeightedAverageRTH(cMap map[int]algorithm.RTHCalculator, threadCount map[int]uint64, totalCount uint64, maxRTH int) []int {
 	averageRth := make([]int, maxRTH+2)
 	for tid, calculator := range cMap {
@@ -0,0 +1,73 @@
+package resourcemanager
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/algorithm"
+	"github.com/packagewjx/resourcemanager/internal/sampler/memrecord"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// 构造多线程的合成访问序列：每个线程循环访问自己私有的1000个缓存行，每10次访问插入一个只访问一次的缓存行。
+// 线程以每次125条访问的粒度交织，模拟并发执行。
+func feedMultiThreadTrace(consumer memrecord.CacheLineAddressConsumer, numThreads int) map[int]uint64 {
+	const workingSet = 1000
+	const rounds = 5
+	const chunk = 125
+	traces := make([][]uint64, numThreads)
+	for t := 0; t < numThreads; t++ {
+		base := uint64(t+1) << 32
+		unique := base | 1<<24
+		for r := 0; r < rounds; r++ {
+			for i := 0; i < workingSet; i++ {
+				traces[t] = append(traces[t], base+uint64(i)<<6)
+				if i%10 == 9 {
+					traces[t] = append(traces[t], unique)
+					unique += 1 << 6
+				}
+			}
+		}
+	}
+	counts := make(map[int]uint64)
+	for pos := 0; pos < len(traces[0]); pos += chunk {
+		for t := 0; t < numThreads; t++ {
+			end := pos + chunk
+			if end > len(traces[t]) {
+				end = len(traces[t])
+			}
+			consumer.Consume(t+1, traces[t][pos:end])
+			counts[t+1] += uint64(end - pos)
+		}
+	}
+	return counts
+}
+
+func TestInterleavedMRC(t *testing.T) {
+	const maxRTH = 20000
+	const cacheSize = 8000
+	factory := func(tid int) algorithm.RTHCalculator {
+		return algorithm.FullTraceCalculator()
+	}
+
+	consumer := memrecord.NewInterleavedRTHCalculatorConsumer(factory)
+	counts := feedMultiThreadTrace(consumer, 4)
+	total := uint64(0)
+	for _, c := range counts {
+		total += c
+	}
+	weighted := WeightedAverageMRC(consumer.GetCalculatorMap(), counts, total, maxRTH, cacheSize)
+	interleaved := InterleavedMRC(consumer.GetProcessCalculator(), maxRTH, cacheSize)
+	assert.Len(t, interleaved, cacheSize+1)
+	t.Logf("缓存大小2000时，加权平均Miss Rate %.4f，交织Miss Rate %.4f", weighted[2000], interleaved[2000])
+
+	// 单独看每个线程，2000个缓存行足以容纳其工作集；但4个线程共享缓存时，工作集合计4000个缓存行，2000个缓存行远远不够
+	assert.Less(t, weighted[2000], float32(0.5))
+	assert.Greater(t, interleaved[2000], float32(0.9))
+	assert.Greater(t, interleaved[2000]-weighted[2000], float32(0.5))
+
+	// 单线程时两种方式结果一致
+	consumer = memrecord.NewInterleavedRTHCalculatorConsumer(factory)
+	counts = feedMultiThreadTrace(consumer, 1)
+	weighted = WeightedAverageMRC(consumer.GetCalculatorMap(), counts, counts[1], maxRTH, cacheSize)
+	interleaved = InterleavedMRC(consumer.GetProcessCalculator(), maxRTH, cacheSize)
+	assert.Equal(t, weighted, interleaved)
+}
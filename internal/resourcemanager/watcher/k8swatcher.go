@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/utils"
 	"github.com/packagewjx/resourcemanager/internal/utils/k8s"
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
@@ -100,10 +101,19 @@ func (p *k8sWatcher) run(ctx context.Context, watchInterface watch.Interface) {
 			case watch.Modified:
 				condition = ProcessGroupStatusUpdate
 			}
+			var cgroupPath string
+			if condition != ProcessGroupStatusRemove && len(pidList) != 0 {
+				// 容器的cgroup都在Pod的cgroup之下，按Pod整体计数
+				cgroupPath, err = utils.PerfEventCgroupDir(pidList)
+				if err != nil {
+					logger.Printf("无法获取Pod %s 的cgroup，只能按进程计数：%v", pod.Name, err)
+				}
+			}
 			s := &ProcessGroupStatus{
 				Group: core.ProcessGroup{
-					Id:  pod.Name,
-					Pid: pidList,
+					Id:         pod.Name,
+					Pid:        pidList,
+					CgroupPath: cgroupPath,
 				},
 				Status: condition,
 			}
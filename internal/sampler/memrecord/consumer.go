@@ -0,0 +1,310 @@
+package memrecord
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 组合CacheLineAddressConsumer的工具。包装了其他Consumer的Consumer都实现了io.Closer，
+// Close时会关闭被包装的Consumer，记录结束后应调用CloseConsumer确保所有地址都已经处理完毕。
+
+// 关闭实现了io.Closer的Consumer，其他Consumer不做任何事
+func CloseConsumer(consumer CacheLineAddressConsumer) error {
+	if closer, ok := consumer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// 将地址流同时交给多个Consumer
+type multiplexConsumer struct {
+	lock      sync.Mutex
+	consumers []CacheLineAddressConsumer
+}
+
+// 创建复用器，Consume按顺序调用每个Consumer。同一时刻只有一个协程在调用下游的Consumer，
+// 因此下游的Consumer不需要是线程安全的。地址数组会被所有Consumer共享，Consumer不能修改它
+func NewMultiplexConsumer(consumers ...CacheLineAddressConsumer) CacheLineAddressConsumer {
+	return &multiplexConsumer{consumers: consumers}
+}
+
+func (m *multiplexConsumer) Consume(tid int, addr []uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, c := range m.consumers {
+		c.Consume(tid, addr)
+	}
+}
+
+// 关闭所有Consumer，返回第一个错误
+func (m *multiplexConsumer) Close() error {
+	var err error
+	for _, c := range m.consumers {
+		if e := CloseConsumer(c); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// 只将满足条件的地址交给下游Consumer
+type filterConsumer struct {
+	consumer  CacheLineAddressConsumer
+	acceptTid func(tid int) bool
+	accept    func(addr uint64) bool
+}
+
+func (f *filterConsumer) Consume(tid int, addr []uint64) {
+	if f.acceptTid != nil && !f.acceptTid(tid) {
+		return
+	}
+	if f.accept == nil {
+		f.consumer.Consume(tid, addr)
+		return
+	}
+	// 全部满足条件时直接使用原数组，避免复制
+	i := 0
+	for ; i < len(addr) && f.accept(addr[i]); i++ {
+	}
+	if i == len(addr) {
+		f.consumer.Consume(tid, addr)
+		return
+	}
+	filtered := make([]uint64, i, len(addr))
+	copy(filtered, addr[:i])
+	for _, a := range addr[i+1:] {
+		if f.accept(a) {
+			filtered = append(filtered, a)
+		}
+	}
+	if len(filtered) != 0 {
+		f.consumer.Consume(tid, filtered)
+	}
+}
+
+func (f *filterConsumer) Close() error {
+	return CloseConsumer(f.consumer)
+}
+
+// 只保留[start, end)范围内的地址
+func NewAddressRangeFilter(consumer CacheLineAddressConsumer, start, end uint64) CacheLineAddressConsumer {
+	return &filterConsumer{
+		consumer: consumer,
+		accept: func(addr uint64) bool {
+			return addr >= start && addr < end
+		},
+	}
+}
+
+// 只保留tids中线程的地址
+func NewTidFilter(consumer CacheLineAddressConsumer, tids []int) CacheLineAddressConsumer {
+	allow := make(map[int]struct{}, len(tids))
+	for _, tid := range tids {
+		allow[tid] = struct{}{}
+	}
+	return &filterConsumer{
+		consumer: consumer,
+		acceptTid: func(tid int) bool {
+			_, ok := allow[tid]
+			return ok
+		},
+	}
+}
+
+const samplingModulus = 1 << 24
+
+// 按地址的哈希值进行空间采样，保留约ratio比例的缓存行。同一个缓存行要么总是保留，要么总是丢弃，
+// 因此采样后的重用时间分布形状不变，使用时需要将重用时间与缓存容量按1/ratio放大。ratio不在(0, 1)内时不过滤
+func NewSamplingFilter(consumer CacheLineAddressConsumer, ratio float64) CacheLineAddressConsumer {
+	if ratio <= 0 || ratio >= 1 {
+		return &filterConsumer{consumer: consumer}
+	}
+	threshold := uint64(ratio * samplingModulus)
+	return &filterConsumer{
+		consumer: consumer,
+		accept: func(addr uint64) bool {
+			return mixAddress(addr)%samplingModulus < threshold
+		},
+	}
+}
+
+// splitmix64的混合函数，使相邻的缓存行得到不相关的哈希值
+func mixAddress(addr uint64) uint64 {
+	addr ^= addr >> 30
+	addr *= 0xbf58476d1ce4e5b9
+	addr ^= addr >> 27
+	addr *= 0x94d049bb133111eb
+	addr ^= addr >> 31
+	return addr
+}
+
+type shardItem struct {
+	tid  int
+	addr []uint64
+}
+
+// 按线程号将地址分发到多个工作协程处理的Consumer。同一个线程的地址总是由同一个协程按顺序处理，
+// 每个协程的队列有上限，队列满时Consume会阻塞，从而对记录器形成反压。
+// 下游Consumer会被多个协程同时调用，必须是线程安全的
+type ShardedConsumer struct {
+	consumer CacheLineAddressConsumer
+	lock     sync.RWMutex
+	closed   bool
+	queues   []chan shardItem
+	wg       sync.WaitGroup
+}
+
+// 创建workers个工作协程，每个协程的队列最多容纳queueSize批地址
+func NewShardedConsumer(consumer CacheLineAddressConsumer, workers, queueSize int) *ShardedConsumer {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	s := &ShardedConsumer{
+		consumer: consumer,
+		queues:   make([]chan shardItem, workers),
+	}
+	s.wg.Add(workers)
+	for i := range s.queues {
+		s.queues[i] = make(chan shardItem, queueSize)
+		go s.work(s.queues[i])
+	}
+	return s
+}
+
+func (s *ShardedConsumer) work(queue <-chan shardItem) {
+	defer s.wg.Done()
+	for item := range queue {
+		s.consumer.Consume(item.tid, item.addr)
+	}
+}
+
+func (s *ShardedConsumer) Consume(tid int, addr []uint64) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return
+	}
+	shard := tid % len(s.queues)
+	if shard < 0 {
+		shard = -shard
+	}
+	s.queues[shard] <- shardItem{tid: tid, addr: addr}
+}
+
+// 处理完队列中剩余的地址后关闭下游Consumer。关闭后的Consume调用会被忽略
+func (s *ShardedConsumer) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	for _, queue := range s.queues {
+		close(queue)
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+	return CloseConsumer(s.consumer)
+}
+
+// Consumer的处理统计
+type ConsumerStats struct {
+	Batches uint64        // Consume调用次数
+	Lines   uint64        // 缓存行地址数量
+	Threads int           // 出现过的线程数量
+	Elapsed time.Duration // 从创建开始经过的时间
+}
+
+// 平均每秒处理的批次数量
+func (s ConsumerStats) BatchRate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Batches) / s.Elapsed.Seconds()
+}
+
+// 平均每秒处理的缓存行数量
+func (s ConsumerStats) LineRate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Lines) / s.Elapsed.Seconds()
+}
+
+// 统计经过的地址数量并交给下游Consumer，consumer为nil时只统计
+type StatsConsumer struct {
+	batches  uint64 // 原子操作的字段放在开头以保证64位对齐
+	lines    uint64
+	consumer CacheLineAddressConsumer
+	start    time.Time
+	lock     sync.Mutex
+	threads  map[int]struct{}
+}
+
+func NewStatsConsumer(consumer CacheLineAddressConsumer) *StatsConsumer {
+	return &StatsConsumer{
+		consumer: consumer,
+		start:    time.Now(),
+		threads:  map[int]struct{}{},
+	}
+}
+
+func (s *StatsConsumer) Consume(tid int, addr []uint64) {
+	atomic.AddUint64(&s.batches, 1)
+	atomic.AddUint64(&s.lines, uint64(len(addr)))
+	s.lock.Lock()
+	s.threads[tid] = struct{}{}
+	s.lock.Unlock()
+	if s.consumer != nil {
+		s.consumer.Consume(tid, addr)
+	}
+}
+
+func (s *StatsConsumer) Stats() ConsumerStats {
+	s.lock.Lock()
+	threads := len(s.threads)
+	s.lock.Unlock()
+	return ConsumerStats{
+		Batches: atomic.LoadUint64(&s.batches),
+		Lines:   atomic.LoadUint64(&s.lines),
+		Threads: threads,
+		Elapsed: time.Since(s.start),
+	}
+}
+
+// 每隔interval输出一次这段时间内的处理速度，直到ctx结束
+func (s *StatsConsumer) Report(ctx context.Context, interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := s.Stats()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cur := s.Stats()
+		delta := ConsumerStats{
+			Batches: cur.Batches - last.Batches,
+			Lines:   cur.Lines - last.Lines,
+			Elapsed: cur.Elapsed - last.Elapsed,
+		}
+		logger.Printf("共 %d 个线程，%d 个缓存行；最近每秒 %.0f 批，%.0f 个缓存行",
+			cur.Threads, cur.Lines, delta.BatchRate(), delta.LineRate())
+		last = cur
+	}
+}
+
+func (s *StatsConsumer) Close() error {
+	if s.consumer == nil {
+		return nil
+	}
+	return CloseConsumer(s.consumer)
+}
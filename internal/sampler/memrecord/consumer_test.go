@@ -0,0 +1,175 @@
+package memrecord
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 线程安全的collectConsumer，并记录是否被关闭
+type syncCollectConsumer struct {
+	lock   sync.Mutex
+	addr   map[int][]uint64
+	closed bool
+}
+
+func (c *syncCollectConsumer) Consume(tid int, addr []uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.addr[tid] = append(c.addr[tid], addr...)
+}
+
+func (c *syncCollectConsumer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestMultiplexConsumer(t *testing.T) {
+	a := &syncCollectConsumer{addr: map[int][]uint64{}}
+	b := &collectConsumer{addr: map[int][]uint64{}}
+	consumer := NewMultiplexConsumer(a, b)
+	wg := sync.WaitGroup{}
+	for tid := 1; tid <= 4; tid++ {
+		wg.Add(1)
+		go func(tid int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				consumer.Consume(tid, []uint64{uint64(i)})
+			}
+		}(tid)
+	}
+	wg.Wait()
+	assert.NoError(t, CloseConsumer(consumer))
+	assert.True(t, a.closed)
+	assert.Equal(t, a.addr, b.addr)
+	for tid := 1; tid <= 4; tid++ {
+		assert.Len(t, b.addr[tid], 100)
+	}
+}
+
+func TestFilterConsumer(t *testing.T) {
+	c := &collectConsumer{addr: map[int][]uint64{}}
+	consumer := NewAddressRangeFilter(c, 0x100, 0x200)
+	consumer.Consume(1, []uint64{0x100, 0x140})
+	consumer.Consume(1, []uint64{0x40, 0x180, 0x200, 0x1c0})
+	consumer.Consume(2, []uint64{0x40, 0x300})
+	assert.Equal(t, map[int][]uint64{1: {0x100, 0x140, 0x180, 0x1c0}}, c.addr)
+
+	c = &collectConsumer{addr: map[int][]uint64{}}
+	consumer = NewTidFilter(c, []int{2, 3})
+	consumer.Consume(1, []uint64{1})
+	consumer.Consume(2, []uint64{2})
+	consumer.Consume(3, []uint64{3})
+	assert.Equal(t, map[int][]uint64{2: {2}, 3: {3}}, c.addr)
+
+	// 同一个缓存行总是同时保留或丢弃，保留比例接近ratio
+	c = &collectConsumer{addr: map[int][]uint64{}}
+	consumer = NewSamplingFilter(c, 0.25)
+	addr := make([]uint64, 100000)
+	for i := range addr {
+		addr[i] = uint64(i) << 6
+	}
+	consumer.Consume(1, addr)
+	consumer.Consume(1, addr)
+	kept := len(c.addr[1]) / 2
+	assert.InDelta(t, 25000, kept, 1000)
+	assert.Equal(t, c.addr[1][:kept], c.addr[1][kept:])
+
+	c = &collectConsumer{addr: map[int][]uint64{}}
+	NewSamplingFilter(c, 1).Consume(1, addr)
+	assert.Len(t, c.addr[1], len(addr))
+}
+
+func TestShardedConsumer(t *testing.T) {
+	c := &syncCollectConsumer{addr: map[int][]uint64{}}
+	consumer := NewShardedConsumer(c, 3, 2)
+	for i := 0; i < 1000; i++ {
+		for tid := 1; tid <= 5; tid++ {
+			consumer.Consume(tid, []uint64{uint64(i)})
+		}
+	}
+	assert.NoError(t, consumer.Close())
+	assert.True(t, c.closed)
+	for tid := 1; tid <= 5; tid++ {
+		// 同一线程的地址保持顺序
+		assert.Len(t, c.addr[tid], 1000)
+		for i, a := range c.addr[tid] {
+			assert.Equal(t, uint64(i), a)
+		}
+	}
+	// 关闭后的调用被忽略
+	consumer.Consume(1, []uint64{1})
+	assert.Len(t, c.addr[1], 1000)
+	assert.NoError(t, consumer.Close())
+}
+
+// 下游处理慢时，队列满后Consume阻塞
+func TestShardedConsumerBackpressure(t *testing.T) {
+	block := make(chan struct{})
+	blocking := &blockingConsumer{block: block}
+	consumer := NewShardedConsumer(blocking, 1, 1)
+	done := make(chan struct{})
+	go func() {
+		// 第一批被工作协程取走阻塞，第二批进入队列，第三批阻塞
+		for i := 0; i < 3; i++ {
+			consumer.Consume(1, []uint64{uint64(i)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("队列满时Consume没有阻塞")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(block)
+	<-done
+	assert.NoError(t, consumer.Close())
+	assert.Equal(t, 3, blocking.count)
+}
+
+type blockingConsumer struct {
+	block <-chan struct{}
+	count int
+}
+
+func (b *blockingConsumer) Consume(_ int, _ []uint64) {
+	<-b.block
+	b.count++
+}
+
+func TestStatsConsumer(t *testing.T) {
+	c := &collectConsumer{addr: map[int][]uint64{}}
+	stats := NewStatsConsumer(c)
+	stats.Consume(1, []uint64{1, 2, 3})
+	stats.Consume(2, []uint64{4})
+	stats.Consume(1, []uint64{5, 6})
+	s := stats.Stats()
+	assert.Equal(t, uint64(3), s.Batches)
+	assert.Equal(t, uint64(6), s.Lines)
+	assert.Equal(t, 2, s.Threads)
+	assert.Len(t, c.addr[1], 5)
+
+	s = ConsumerStats{Batches: 10, Lines: 1000, Elapsed: 2 * time.Second}
+	assert.Equal(t, 5.0, s.BatchRate())
+	assert.Equal(t, 500.0, s.LineRate())
+	assert.Zero(t, ConsumerStats{}.LineRate())
+	assert.NoError(t, NewStatsConsumer(nil).Close())
+}
+
+func TestRTHCalculatorConsumerConcurrent(t *testing.T) {
+	consumer := NewInterleavedRTHCalculatorConsumer(factoryFullTrace)
+	wg := sync.WaitGroup{}
+	for tid := 1; tid <= 8; tid++ {
+		wg.Add(1)
+		go func(tid int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				consumer.Consume(tid, []uint64{uint64(i % 10), uint64(tid) << 20})
+			}
+		}(tid)
+	}
+	wg.Wait()
+	assert.Len(t, consumer.GetCalculatorMap(), 8)
+	assert.NotNil(t, consumer.GetProcessCalculator())
+}
@@ -0,0 +1,167 @@
+package memrecord
+
+import (
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/utils"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+/*
+Pin附着到进程后，Pin工具在目标进程中运行，工具文件、管道与指令数量文件都是在目标进程的挂载名称空间中按路径打开的，
+线程号也是目标进程所在PID名称空间中的线程号。因此对容器内的进程，需要将这些文件放在容器的根目录中，
+传给Pin容器内的路径，本进程通过/proc/<pid>/root访问，并将线程号映射回本名称空间的线程号。
+
+perf与perf_event_open的采样由内核按打开事件的进程所在的名称空间报告线程号，不需要这些处理。
+*/
+
+// 容器内放置Pin文件的目录，相对于容器的根目录
+const containerStageDir = "tmp"
+
+// Pin追踪容器内进程时使用的文件，Local开头的为容器内的路径，其余为本进程访问的路径
+type pinStage struct {
+	dir            string
+	toolPath       string
+	fifoPath       string
+	iCountPath     string
+	localToolPath  string
+	localFifoPath  string
+	localCountPath string
+}
+
+// 判断进程是否在容器中，是则返回容器根目录在本进程中的路径。rootDir不为空时直接使用rootDir作为容器根目录
+func resolveContainerRoot(pid int, rootDir string) (string, bool, error) {
+	if rootDir != "" {
+		return rootDir, true, nil
+	}
+	inContainer, err := utils.InOtherMountNamespace(pid)
+	if err != nil || !inContainer {
+		return "", false, err
+	}
+	return utils.ProcessRootPath(pid), true, nil
+}
+
+// 在容器根目录中创建临时目录，复制Pin工具并创建管道
+func stagePinFiles(root, toolPath, requestName string) (*pinStage, error) {
+	base, err := ioutil.TempDir(filepath.Join(root, containerStageDir), "resourcemanager-pin.*")
+	if err != nil {
+		return nil, errors.Wrap(err, "在容器中创建临时目录出错")
+	}
+	// 目标进程可能以非root用户运行，需要能够在目录中创建指令数量文件
+	if err = os.Chmod(base, 0777); err != nil {
+		_ = os.RemoveAll(base)
+		return nil, errors.Wrap(err, "设置临时目录权限出错")
+	}
+	local := filepath.Join("/", containerStageDir, filepath.Base(base))
+	stage := &pinStage{
+		dir:            base,
+		toolPath:       filepath.Join(base, filepath.Base(toolPath)),
+		fifoPath:       filepath.Join(base, "trace.fifo"),
+		iCountPath:     filepath.Join(base, fmt.Sprintf("%s.icount.csv", filepath.Base(requestName))),
+		localToolPath:  filepath.Join(local, filepath.Base(toolPath)),
+		localFifoPath:  filepath.Join(local, "trace.fifo"),
+		localCountPath: filepath.Join(local, fmt.Sprintf("%s.icount.csv", filepath.Base(requestName))),
+	}
+	if err = copyFile(toolPath, stage.toolPath, 0755); err != nil {
+		_ = os.RemoveAll(base)
+		return nil, err
+	}
+	oldMask := syscall.Umask(0)
+	err = syscall.Mkfifo(stage.fifoPath, 0666)
+	syscall.Umask(oldMask)
+	if err != nil {
+		_ = os.RemoveAll(base)
+		return nil, errors.Wrap(err, "在容器中创建管道出错")
+	}
+	return stage, nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "打开Pin工具出错")
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return errors.Wrap(err, "复制Pin工具到容器出错")
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "复制Pin工具到容器出错")
+}
+
+// 将容器内的线程号映射为本名称空间的线程号后交给下游Consumer
+type tidMappingConsumer struct {
+	consumer CacheLineAddressConsumer
+	pid      int
+	lock     sync.Mutex
+	tids     map[int]int
+}
+
+func newTidMappingConsumer(consumer CacheLineAddressConsumer, pid int) *tidMappingConsumer {
+	c := &tidMappingConsumer{
+		consumer: consumer,
+		pid:      pid,
+		tids:     map[int]int{},
+	}
+	c.refresh()
+	return c
+}
+
+func (c *tidMappingConsumer) refresh() {
+	tids, err := utils.NamespaceTidMap(c.pid)
+	if err != nil {
+		return
+	}
+	for nsTid, tid := range tids {
+		c.tids[nsTid] = tid
+	}
+}
+
+// 返回本名称空间的线程号。找不到时重新读取一次，线程已经退出时返回原线程号
+func (c *tidMappingConsumer) mapTid(nsTid int) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tid, ok := c.tids[nsTid]
+	if !ok {
+		c.refresh()
+		if tid, ok = c.tids[nsTid]; !ok {
+			c.tids[nsTid] = nsTid
+			tid = nsTid
+		}
+	}
+	return tid
+}
+
+func (c *tidMappingConsumer) Consume(tid int, addr []uint64) {
+	c.consumer.Consume(c.mapTid(tid), addr)
+}
+
+func (c *tidMappingConsumer) Close() error {
+	return CloseConsumer(c.consumer)
+}
+
+// 映射以线程号为键的计数，键为0的总数保持不变
+func (c *tidMappingConsumer) mapCounts(counts map[int]uint64) map[int]uint64 {
+	if counts == nil {
+		return nil
+	}
+	res := make(map[int]uint64, len(counts))
+	for tid, cnt := range counts {
+		if tid != 0 {
+			tid = c.mapTid(tid)
+		}
+		res[tid] += cnt
+	}
+	return res
+}
@@ -0,0 +1,71 @@
+package memrecord
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestStagePinFiles(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "tmp.container.*")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(root)
+	}()
+	assert.NoError(t, os.Mkdir(filepath.Join(root, containerStageDir), 0777))
+	tool := filepath.Join(root, "MemTrace2.so")
+	assert.NoError(t, ioutil.WriteFile(tool, []byte("tool"), 0644))
+
+	stage, err := stagePinFiles(root, tool, "group-1")
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(stage.toolPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "tool", string(content))
+	info, err := os.Stat(stage.fifoPath)
+	assert.NoError(t, err)
+	assert.NotZero(t, info.Mode()&os.ModeNamedPipe)
+
+	// 容器内的路径与本进程访问的路径对应
+	for local, host := range map[string]string{
+		stage.localToolPath:  stage.toolPath,
+		stage.localFifoPath:  stage.fifoPath,
+		stage.localCountPath: stage.iCountPath,
+	} {
+		assert.True(t, filepath.IsAbs(local))
+		assert.Equal(t, filepath.Join(root, local), host)
+	}
+
+	_, err = stagePinFiles(root, filepath.Join(root, "not-exist.so"), "group-1")
+	assert.Error(t, err)
+}
+
+func TestResolveContainerRoot(t *testing.T) {
+	_, inContainer, err := resolveContainerRoot(os.Getpid(), "")
+	assert.NoError(t, err)
+	assert.False(t, inContainer)
+	root, inContainer, err := resolveContainerRoot(os.Getpid(), "/var/lib/docker/rootfs")
+	assert.NoError(t, err)
+	assert.True(t, inContainer)
+	assert.Equal(t, "/var/lib/docker/rootfs", root)
+}
+
+func TestTidMappingConsumer(t *testing.T) {
+	c := &collectConsumer{addr: map[int][]uint64{}}
+	consumer := newTidMappingConsumer(c, os.Getpid())
+	// 模拟容器内的线程号
+	consumer.tids[1] = os.Getpid()
+	consumer.Consume(1, []uint64{1})
+	tid := syscall.Gettid()
+	consumer.Consume(tid, []uint64{2})
+	// 已经不存在的线程保持原线程号
+	consumer.Consume(-5, []uint64{3})
+	assert.Equal(t, []uint64{1}, c.addr[os.Getpid()][:1])
+	assert.Contains(t, c.addr[tid], uint64(2))
+	assert.Equal(t, []uint64{3}, c.addr[-5])
+
+	assert.Equal(t, map[int]uint64{0: 10, os.Getpid(): 10}, consumer.mapCounts(map[int]uint64{0: 10, 1: 10}))
+	assert.Nil(t, consumer.mapCounts(nil))
+}
@@ -0,0 +1,125 @@
+package memrecord
+
+import (
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/utils/perfevent"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// 使用instructions硬件事件统计每个线程执行的用户态指令数量，与采样的地址一起用于加权计算MRC
+type instructionCounter struct {
+	lock   sync.Mutex
+	fds    map[int]int    // 正在计数的线程
+	counts map[int]uint64 // 已经结束计数的线程
+	err    error          // 第一次打开事件出错的原因
+}
+
+func newInstructionCounter() *instructionCounter {
+	return &instructionCounter{
+		fds:    map[int]int{},
+		counts: map[int]uint64{},
+	}
+}
+
+// 开始统计线程的指令数量，已经在统计的线程不做任何事
+func (c *instructionCounter) open(tid int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.fds[tid]; ok {
+		return nil
+	}
+	fd, err := perfevent.Open(&perfevent.Attr{
+		Type:       perfevent.TypeHardware,
+		Config:     perfevent.CountHWInstructions,
+		ReadFormat: perfevent.FormatTotalTimeEnabled | perfevent.FormatTotalTimeRunning,
+		Bits:       perfevent.BitExcludeKernel | perfevent.BitExcludeHv,
+	}, tid, -1, -1, 0)
+	if err != nil {
+		if c.err == nil {
+			c.err = err
+		}
+		return err
+	}
+	c.fds[tid] = fd
+	return nil
+}
+
+// 结束统计线程的指令数量。线程退出后计数仍然可以读取
+func (c *instructionCounter) close(tid int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closeLocked(tid)
+}
+
+func (c *instructionCounter) closeLocked(tid int) {
+	fd, ok := c.fds[tid]
+	if !ok {
+		return
+	}
+	if count, err := perfevent.ReadCount(fd); err == nil {
+		c.counts[tid] += count.Scaled()
+	}
+	_ = syscall.Close(fd)
+	delete(c.fds, tid)
+}
+
+// 统计进程中新出现的线程，并结束已经退出的线程。进程已经退出时返回false。
+// 两次扫描之间创建并退出的线程无法统计
+func (c *instructionCounter) scan(pid int) bool {
+	infos, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return false
+	}
+	alive := map[int]struct{}{}
+	for _, info := range infos {
+		tid, err := strconv.Atoi(info.Name())
+		if err != nil {
+			continue
+		}
+		alive[tid] = struct{}{}
+		_ = c.open(tid)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for tid := range c.fds {
+		if _, ok := alive[tid]; !ok {
+			c.closeLocked(tid)
+		}
+	}
+	return true
+}
+
+// 结束所有线程的统计，返回每个线程的指令数量与总数。没有成功统计任何线程时返回错误
+func (c *instructionCounter) finish() (map[int]uint64, uint64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for tid := range c.fds {
+		c.closeLocked(tid)
+	}
+	if len(c.counts) == 0 {
+		if c.err != nil {
+			return nil, 0, c.err
+		}
+		return nil, 0, fmt.Errorf("没有统计到任何线程的指令数量")
+	}
+	total := uint64(0)
+	for _, cnt := range c.counts {
+		total += cnt
+	}
+	return c.counts, total, nil
+}
+
+// 将统计的指令数量写入结果，没有统计结果时使用采样数量代替
+func (c *instructionCounter) fillResult(res *Result) error {
+	counts, total, err := c.finish()
+	if err != nil {
+		res.fillInstructionsWithSamples()
+		return err
+	}
+	res.ThreadInstructionCount = counts
+	res.TotalInstructions = total
+	return nil
+}
@@ -0,0 +1,50 @@
+package memrecord
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestInstructionCounter(t *testing.T) {
+	c := newInstructionCounter()
+	if err := c.open(os.Getpid()); err != nil {
+		t.Log("无法打开instructions事件，检查回退结果：", err)
+		res := &Result{}
+		res.addSamples(1, 10)
+		res.addSamples(2, 5)
+		assert.Error(t, c.fillResult(res))
+		assert.Equal(t, map[int]uint64{1: 10, 2: 5}, res.ThreadInstructionCount)
+		assert.Equal(t, uint64(15), res.TotalInstructions)
+		return
+	}
+	assert.True(t, c.scan(os.Getpid()))
+	sum := 0
+	for i := 0; i < 1000000; i++ {
+		sum += i
+	}
+	counts, total, err := c.finish()
+	assert.NoError(t, err)
+	assert.NotZero(t, counts[os.Getpid()])
+	assert.NotZero(t, total)
+}
+
+func TestFindChildPid(t *testing.T) {
+	assert.Equal(t, 1, parseStatPpid("123 (a (b) c) S 1 123 123 0 -1"))
+	assert.Equal(t, 0, parseStatPpid("123 (a"))
+
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	var pid int
+	for i := 0; i < 10 && pid == 0; i++ {
+		pid = findChildPid(os.Getpid())
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, cmd.Process.Pid, pid)
+}
@@ -0,0 +1,78 @@
+package memrecord
+
+import (
+	"context"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/utils/perfevent"
+	"io/ioutil"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// 测量进程在interval时间内所有线程的用户态IPC。只统计测量开始时已经存在的线程
+func measureIPC(ctx context.Context, pid int, interval time.Duration) (float64, error) {
+	infos, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return 0, fmt.Errorf("进程 %d 已经退出", pid)
+	}
+	var fds []int
+	defer func() {
+		for _, fd := range fds {
+			_ = syscall.Close(fd)
+		}
+	}()
+	var openErr error
+	for _, info := range infos {
+		tid, err := strconv.Atoi(info.Name())
+		if err != nil {
+			continue
+		}
+		// 每个线程两个事件，依次为instructions与cycles
+		for _, config := range []uint64{perfevent.CountHWInstructions, perfevent.CountHWCPUCycles} {
+			fd, err := perfevent.Open(&perfevent.Attr{
+				Type:       perfevent.TypeHardware,
+				Config:     config,
+				ReadFormat: perfevent.FormatTotalTimeEnabled | perfevent.FormatTotalTimeRunning,
+				Bits:       perfevent.BitExcludeKernel | perfevent.BitExcludeHv,
+			}, tid, -1, -1, 0)
+			if err != nil {
+				openErr = err
+				break
+			}
+			fds = append(fds, fd)
+		}
+		if len(fds)%2 == 1 {
+			// 只打开了instructions，丢弃这个线程
+			_ = syscall.Close(fds[len(fds)-1])
+			fds = fds[:len(fds)-1]
+		}
+	}
+	if len(fds) == 0 {
+		if openErr != nil {
+			return 0, openErr
+		}
+		return 0, fmt.Errorf("进程 %d 没有可以测量的线程", pid)
+	}
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-time.After(interval):
+	}
+
+	var instructions, cycles uint64
+	for i := 0; i < len(fds); i += 2 {
+		inst, err1 := perfevent.ReadCount(fds[i])
+		cyc, err2 := perfevent.ReadCount(fds[i+1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		instructions += inst.Scaled()
+		cycles += cyc.Scaled()
+	}
+	if cycles == 0 {
+		return 0, fmt.Errorf("进程 %d 在测量期间没有运行", pid)
+	}
+	return float64(instructions) / float64(cycles), nil
+}
@@ -0,0 +1,164 @@
+package memrecord
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// 包装MemRecorder，使RecordProcess遵守请求中的TraceLimits。达到限制时取消被包装的记录器的追踪，
+// 记录器结束并脱离进程后，返回的结果标记为部分结果。monitorInterval为测量IPC的时间窗口
+func NewLimitedRecorder(recorder MemRecorder, monitorInterval time.Duration) MemRecorder {
+	if monitorInterval <= 0 {
+		monitorInterval = time.Second
+	}
+	return &limitedRecorder{
+		recorder:        recorder,
+		monitorInterval: monitorInterval,
+		logger:          log.New(os.Stdout, "LimitedRecorder: ", log.LstdFlags|log.Lshortfile|log.Lmsgprefix),
+	}
+}
+
+type limitedRecorder struct {
+	recorder        MemRecorder
+	monitorInterval time.Duration
+	logger          *log.Logger
+}
+
+var _ MemRecorder = &limitedRecorder{}
+
+func (l *limitedRecorder) RecordCommand(ctx context.Context, request *RunRequest) (<-chan *Result, error) {
+	return l.recorder.RecordCommand(ctx, request)
+}
+
+// 记录第一个触发的限制并取消追踪
+type traceStopper struct {
+	once   sync.Once
+	reason StopReason
+	cancel context.CancelFunc
+}
+
+func (s *traceStopper) stop(reason StopReason) {
+	s.once.Do(func() {
+		s.reason = reason
+		s.cancel()
+	})
+}
+
+// 达到地址数量上限后丢弃之后的地址并停止追踪
+type budgetConsumer struct {
+	consumer CacheLineAddressConsumer
+	lock     sync.Mutex
+	budget   uint64
+	stopper  *traceStopper
+}
+
+func (b *budgetConsumer) Consume(tid int, addr []uint64) {
+	b.lock.Lock()
+	if b.budget == 0 {
+		b.lock.Unlock()
+		return
+	}
+	if uint64(len(addr)) >= b.budget {
+		addr = addr[:b.budget]
+		b.budget = 0
+		b.lock.Unlock()
+		b.consumer.Consume(tid, addr)
+		b.stopper.stop(StopReasonAddresses)
+		return
+	}
+	b.budget -= uint64(len(addr))
+	b.lock.Unlock()
+	b.consumer.Consume(tid, addr)
+}
+
+func (b *budgetConsumer) Close() error {
+	return CloseConsumer(b.consumer)
+}
+
+func (l *limitedRecorder) RecordProcess(ctx context.Context, request *AttachRequest) (<-chan *Result, error) {
+	limits := request.Limits
+	if !limits.enabled() {
+		return l.recorder.RecordProcess(ctx, request)
+	}
+
+	// 在追踪之前测量基准IPC，无法测量时不限制减速
+	var baseline float64
+	if limits.MaxSlowdown > 0 {
+		var err error
+		baseline, err = measureIPC(ctx, request.Pid, l.monitorInterval)
+		if err != nil {
+			l.logger.Printf("%s: 无法测量进程 %d 的IPC，不限制减速：%v", request.Name, request.Pid, err)
+			baseline = 0
+		}
+	}
+
+	traceCtx, cancel := context.WithCancel(ctx)
+	stopper := &traceStopper{cancel: cancel}
+	inner := *request
+	if limits.MaxAddresses > 0 {
+		inner.Consumer = &budgetConsumer{
+			consumer: request.Consumer,
+			budget:   limits.MaxAddresses,
+			stopper:  stopper,
+		}
+	}
+	ch, err := l.recorder.RecordProcess(traceCtx, &inner)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if limits.MaxDuration > 0 {
+		timer := time.AfterFunc(limits.MaxDuration, func() {
+			stopper.stop(StopReasonDuration)
+		})
+		go func() {
+			<-traceCtx.Done()
+			timer.Stop()
+		}()
+	}
+	if baseline > 0 {
+		go l.monitorSlowdown(traceCtx, request, baseline, stopper)
+	}
+
+	resCh := make(chan *Result, 1)
+	go func() {
+		res := <-ch
+		if ctx.Err() != nil {
+			stopper.stop(StopReasonCanceled)
+		} else {
+			// 追踪正常结束时也需要释放traceCtx，之后的stop不会改变原因
+			stopper.stop(StopReasonNone)
+		}
+		if res != nil && stopper.reason != StopReasonNone {
+			res.Partial = true
+			res.StopReason = stopper.reason
+			l.logger.Printf("%s: 追踪提前结束（%s），共采集 %d 条地址", request.Name, stopper.reason, res.TotalSamples)
+		}
+		resCh <- res
+		close(resCh)
+	}()
+	return resCh, nil
+}
+
+// 追踪期间持续测量IPC，相对基准的减速超过限制时停止追踪
+func (l *limitedRecorder) monitorSlowdown(ctx context.Context, request *AttachRequest, baseline float64, stopper *traceStopper) {
+	for ctx.Err() == nil {
+		ipc, err := measureIPC(ctx, request.Pid, l.monitorInterval)
+		if err != nil {
+			if ctx.Err() == nil {
+				l.logger.Printf("%s: 测量进程 %d 的IPC出错，停止监测减速：%v", request.Name, request.Pid, err)
+			}
+			return
+		}
+		if ipc <= 0 || baseline/ipc > request.Limits.MaxSlowdown {
+			l.logger.Printf("%s: 进程 %d 的IPC由 %.3f 下降到 %.3f，超过减速限制 %.1f 倍", request.Name, request.Pid,
+				baseline, ipc, request.Limits.MaxSlowdown)
+			stopper.stop(StopReasonSlowdown)
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package memrecord
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// 每毫秒产生一批地址，直到ctx结束或者产生total批
+type streamRecorder struct {
+	total int
+}
+
+func (s *streamRecorder) record(ctx context.Context, consumer CacheLineAddressConsumer) <-chan *Result {
+	ch := make(chan *Result, 1)
+	go func() {
+		res := &Result{}
+		for i := 0; i < s.total; i++ {
+			select {
+			case <-ctx.Done():
+				i = s.total
+				continue
+			case <-time.After(time.Millisecond):
+			}
+			consumer.Consume(1, []uint64{1, 2, 3, 4})
+			res.addSamples(1, 4)
+		}
+		res.fillInstructionsWithSamples()
+		ch <- res
+		close(ch)
+	}()
+	return ch
+}
+
+func (s *streamRecorder) RecordCommand(ctx context.Context, request *RunRequest) (<-chan *Result, error) {
+	return s.record(ctx, request.Consumer), nil
+}
+
+func (s *streamRecorder) RecordProcess(ctx context.Context, request *AttachRequest) (<-chan *Result, error) {
+	return s.record(ctx, request.Consumer), nil
+}
+
+func TestLimitedRecorder(t *testing.T) {
+	record := func(ctx context.Context, limits TraceLimits) (*Result, *collectConsumer) {
+		recorder := NewLimitedRecorder(&streamRecorder{total: 100}, 10*time.Millisecond)
+		consumer := &collectConsumer{addr: map[int][]uint64{}}
+		ch, err := recorder.RecordProcess(ctx, &AttachRequest{
+			BaseRequest: BaseRequest{Name: "test", Consumer: consumer},
+			Pid:         os.Getpid(),
+			Limits:      limits,
+		})
+		assert.NoError(t, err)
+		return <-ch, consumer
+	}
+
+	// 没有限制时完整追踪
+	res, consumer := record(context.Background(), TraceLimits{})
+	assert.False(t, res.Partial)
+	assert.Equal(t, uint64(400), res.TotalSamples)
+
+	// 地址数量上限，超出的部分被丢弃
+	res, consumer = record(context.Background(), TraceLimits{MaxAddresses: 10})
+	assert.True(t, res.Partial)
+	assert.Equal(t, StopReasonAddresses, res.StopReason)
+	assert.Len(t, consumer.addr[1], 10)
+
+	// 追踪时长
+	res, _ = record(context.Background(), TraceLimits{MaxDuration: 20 * time.Millisecond})
+	assert.True(t, res.Partial)
+	assert.Equal(t, StopReasonDuration, res.StopReason)
+	assert.Less(t, res.TotalSamples, uint64(400))
+
+	// 限制没有达到时不是部分结果
+	res, _ = record(context.Background(), TraceLimits{MaxDuration: time.Minute, MaxAddresses: 1000})
+	assert.False(t, res.Partial)
+	assert.Equal(t, StopReasonNone, res.StopReason)
+
+	// 调用者取消
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	res, _ = record(ctx, TraceLimits{MaxDuration: time.Minute})
+	assert.True(t, res.Partial)
+	assert.Equal(t, StopReasonCanceled, res.StopReason)
+}
@@ -0,0 +1,450 @@
+package memrecord
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+perf.data文件格式解析，参考linux/tools/perf/Documentation/perf.data-file-format.txt。
+只支持非pipe模式、小端序的文件，只解析PERF_RECORD_SAMPLE记录。
+*/
+
+const (
+	perfFileMagic      = 0x32454c4946524550 // "PERFILE2"
+	perfFileHeaderSize = 104
+
+	perfRecordSample = 9
+
+	perfTypeTracepoint = 2
+
+	perfHeaderTracingData = 1 // 特性段中tracing data的位序号
+)
+
+const (
+	perfSampleIP = 1 << iota
+	perfSampleTID
+	perfSampleTime
+	perfSampleAddr
+	perfSampleRead
+	perfSampleCallchain
+	perfSampleID
+	perfSampleCPU
+	perfSamplePeriod
+	perfSampleStreamID
+	perfSampleRaw
+	perfSampleBranchStack
+	perfSampleRegsUser
+	perfSampleStackUser
+	perfSampleWeight
+	perfSampleDataSrc
+	perfSampleIdentifier
+)
+
+const (
+	perfFormatTotalTimeEnabled = 1 << iota
+	perfFormatTotalTimeRunning
+	perfFormatID
+	perfFormatGroup
+	perfFormatLost
+)
+
+// 与PEBS地址内核模块的pebs_addr tracepoint布局一致，tracing data中找不到格式时使用
+const (
+	defaultRawTidOffset  = 8
+	defaultRawAddrOffset = 16
+)
+
+// 缓存行地址掩码，同时去掉地址高16位
+const cacheLineMask = 0x0000FFFFFFFFFFC0
+
+// 每个线程攒够这么多条地址后交给Consumer
+const addrBatchSize = 4096
+
+type perfFileSection struct {
+	Offset uint64
+	Size   uint64
+}
+
+type perfFileHeader struct {
+	Magic      uint64
+	Size       uint64
+	AttrSize   uint64
+	Attrs      perfFileSection
+	Data       perfFileSection
+	EventTypes perfFileSection
+	Features   [4]uint64
+}
+
+// raw数据中的一个字段
+type rawField struct {
+	offset int
+	size   int
+}
+
+type perfEventAttr struct {
+	typ        uint32
+	config     uint64
+	sampleType uint64
+	readFormat uint64
+	// tracepoint的raw数据中线程号与地址字段的位置，不是地址tracepoint时为nil
+	rawTid  *rawField
+	rawAddr *rawField
+}
+
+// 是否能从这个事件的采样中得到地址
+func (a *perfEventAttr) hasAddr() bool {
+	return a.sampleType&perfSampleAddr != 0 || (a.sampleType&perfSampleRaw != 0 && a.rawAddr != nil)
+}
+
+type perfDataFile struct {
+	r        io.ReaderAt
+	header   perfFileHeader
+	attrs    []*perfEventAttr
+	idToAttr map[uint64]*perfEventAttr
+}
+
+// perf.data读取统计
+type perfDataStat struct {
+	ThreadSampleCount map[int]uint64 // 每个线程读取到的地址数量
+	Samples           uint64         // 读取到的地址总数
+	ZeroAddr          uint64         // 地址为0而被忽略的记录数量
+}
+
+func openPerfData(r io.ReaderAt) (*perfDataFile, error) {
+	f := &perfDataFile{r: r, idToAttr: map[uint64]*perfEventAttr{}}
+	err := binary.Read(io.NewSectionReader(r, 0, perfFileHeaderSize), binary.LittleEndian, &f.header)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取perf.data文件头出错")
+	}
+	if f.header.Magic != perfFileMagic {
+		return nil, fmt.Errorf("不是perf.data文件或者文件为大端序")
+	}
+	if f.header.Size != perfFileHeaderSize {
+		return nil, fmt.Errorf("不支持pipe模式的perf.data文件")
+	}
+	if err = f.readAttrs(); err != nil {
+		return nil, err
+	}
+	formats, err := f.readTracepointFormats()
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range f.attrs {
+		if attr.typ != perfTypeTracepoint || attr.sampleType&perfSampleRaw == 0 {
+			continue
+		}
+		if fields, ok := formats[attr.config]; ok {
+			// 没有地址字段的tracepoint不参与解析
+			attr.rawAddr = fields["dla"]
+			attr.rawTid = fields["tid"]
+		} else if formats == nil {
+			attr.rawAddr = &rawField{offset: defaultRawAddrOffset, size: 8}
+			attr.rawTid = &rawField{offset: defaultRawTidOffset, size: 4}
+		}
+	}
+	return f, nil
+}
+
+func (f *perfDataFile) readAttrs() error {
+	attrSize := f.header.AttrSize
+	if attrSize < 16+48 {
+		return fmt.Errorf("perf_event_attr大小%d错误", attrSize)
+	}
+	buf := make([]byte, attrSize)
+	for off := uint64(0); off+attrSize <= f.header.Attrs.Size; off += attrSize {
+		if _, err := f.r.ReadAt(buf, int64(f.header.Attrs.Offset+off)); err != nil {
+			return errors.Wrap(err, "读取perf_event_attr出错")
+		}
+		attr := &perfEventAttr{
+			typ:        binary.LittleEndian.Uint32(buf[0:]),
+			config:     binary.LittleEndian.Uint64(buf[8:]),
+			sampleType: binary.LittleEndian.Uint64(buf[24:]),
+			readFormat: binary.LittleEndian.Uint64(buf[32:]),
+		}
+		f.attrs = append(f.attrs, attr)
+
+		// 每个attr后面紧跟该事件所有id所在的段
+		ids := perfFileSection{
+			Offset: binary.LittleEndian.Uint64(buf[attrSize-16:]),
+			Size:   binary.LittleEndian.Uint64(buf[attrSize-8:]),
+		}
+		idBuf := make([]byte, ids.Size)
+		if _, err := f.r.ReadAt(idBuf, int64(ids.Offset)); err != nil {
+			return errors.Wrap(err, "读取事件id出错")
+		}
+		for i := 0; i+8 <= len(idBuf); i += 8 {
+			f.idToAttr[binary.LittleEndian.Uint64(idBuf[i:])] = attr
+		}
+	}
+	if len(f.attrs) == 0 {
+		return fmt.Errorf("perf.data中没有事件")
+	}
+	return nil
+}
+
+var (
+	tracepointFormatRegexp = regexp.MustCompile("name: (\\S+)\nID: (\\d+)\nformat:\n((?s:.*?))\nprint fmt:")
+	tracepointFieldRegexp  = regexp.MustCompile("\tfield:([^;]+);\toffset:(\\d+);\tsize:(\\d+);")
+)
+
+// 从tracing data特性段中读取各个tracepoint的字段格式，以tracepoint的ID为键。没有tracing data时返回nil
+func (f *perfDataFile) readTracepointFormats() (map[uint64]map[string]*rawField, error) {
+	if f.header.Features[0]&(1<<perfHeaderTracingData) == 0 {
+		return nil, nil
+	}
+	// 特性段表位于数据段之后，按位序排列，tracing data之前只有位0的特性
+	sectionOffset := f.header.Data.Offset + f.header.Data.Size
+	if f.header.Features[0]&1 != 0 {
+		sectionOffset += 16
+	}
+	section := perfFileSection{}
+	err := binary.Read(io.NewSectionReader(f.r, int64(sectionOffset), 16), binary.LittleEndian, &section)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取tracing data段出错")
+	}
+	content := make([]byte, section.Size)
+	if _, err = f.r.ReadAt(content, int64(section.Offset)); err != nil {
+		return nil, errors.Wrap(err, "读取tracing data出错")
+	}
+
+	formats := map[uint64]map[string]*rawField{}
+	for _, match := range tracepointFormatRegexp.FindAllSubmatch(content, -1) {
+		id, _ := strconv.ParseUint(string(match[2]), 10, 64)
+		fields := map[string]*rawField{}
+		for _, field := range tracepointFieldRegexp.FindAllSubmatch(match[3], -1) {
+			// 字段声明形如`unsigned long dla`或者`char comm[16]`，取最后一个单词作为字段名
+			decl := strings.Fields(string(field[1]))
+			name := decl[len(decl)-1]
+			if i := strings.IndexByte(name, '['); i != -1 {
+				name = name[:i]
+			}
+			offset, _ := strconv.Atoi(string(field[2]))
+			size, _ := strconv.Atoi(string(field[3]))
+			fields[name] = &rawField{offset: offset, size: size}
+		}
+		formats[id] = fields
+	}
+	return formats, nil
+}
+
+// 找到采样所属的事件
+func (f *perfDataFile) sampleAttr(body []byte) *perfEventAttr {
+	first := f.attrs[0]
+	if first.sampleType&perfSampleIdentifier != 0 {
+		if len(body) < 8 {
+			return nil
+		}
+		return f.idToAttr[binary.LittleEndian.Uint64(body)]
+	}
+	if first.sampleType&perfSampleID != 0 {
+		// 没有IDENTIFIER时，所有事件的sample_type相同，ID位于固定位置
+		offset := 0
+		for _, flag := range []uint64{perfSampleIP, perfSampleTID, perfSampleTime, perfSampleAddr} {
+			if first.sampleType&flag != 0 {
+				offset += 8
+			}
+		}
+		if len(body) < offset+8 {
+			return nil
+		}
+		return f.idToAttr[binary.LittleEndian.Uint64(body[offset:])]
+	}
+	return first
+}
+
+// 读取read_format对应的数据大小
+func readFormatSize(readFormat uint64, body []byte, offset int) (int, error) {
+	extra := 0
+	for _, flag := range []uint64{perfFormatTotalTimeEnabled, perfFormatTotalTimeRunning} {
+		if readFormat&flag != 0 {
+			extra += 8
+		}
+	}
+	perValue := 8
+	for _, flag := range []uint64{perfFormatID, perfFormatLost} {
+		if readFormat&flag != 0 {
+			perValue += 8
+		}
+	}
+	if readFormat&perfFormatGroup == 0 {
+		return extra + perValue, nil
+	}
+	if len(body) < offset+8 {
+		return 0, fmt.Errorf("采样记录长度不足")
+	}
+	nr := int(binary.LittleEndian.Uint64(body[offset:]))
+	return 8 + extra + nr*perValue, nil
+}
+
+// 解析一条采样记录，得到线程号与地址。ok为false表示这条采样不包含地址
+func (f *perfDataFile) parseSample(body []byte) (tid int, addr uint64, ok bool, err error) {
+	attr := f.sampleAttr(body)
+	if attr == nil || !attr.hasAddr() {
+		return 0, 0, false, nil
+	}
+	st := attr.sampleType
+	offset := 0
+	next := func(size int) ([]byte, error) {
+		if offset+size > len(body) {
+			return nil, fmt.Errorf("采样记录长度不足")
+		}
+		b := body[offset : offset+size]
+		offset += size
+		return b, nil
+	}
+	var b []byte
+	if st&perfSampleIdentifier != 0 {
+		if _, err = next(8); err != nil {
+			return
+		}
+	}
+	if st&perfSampleIP != 0 {
+		if _, err = next(8); err != nil {
+			return
+		}
+	}
+	if st&perfSampleTID != 0 {
+		if b, err = next(8); err != nil {
+			return
+		}
+		tid = int(binary.LittleEndian.Uint32(b[4:]))
+	}
+	if st&perfSampleTime != 0 {
+		if _, err = next(8); err != nil {
+			return
+		}
+	}
+	if st&perfSampleAddr != 0 {
+		if b, err = next(8); err != nil {
+			return
+		}
+		return tid, binary.LittleEndian.Uint64(b), true, nil
+	}
+	for _, flag := range []uint64{perfSampleID, perfSampleStreamID, perfSampleCPU, perfSamplePeriod} {
+		if st&flag != 0 {
+			if _, err = next(8); err != nil {
+				return
+			}
+		}
+	}
+	if st&perfSampleRead != 0 {
+		var size int
+		if size, err = readFormatSize(attr.readFormat, body, offset); err != nil {
+			return
+		}
+		if _, err = next(size); err != nil {
+			return
+		}
+	}
+	if st&perfSampleCallchain != 0 {
+		if b, err = next(8); err != nil {
+			return
+		}
+		if _, err = next(8 * int(binary.LittleEndian.Uint64(b))); err != nil {
+			return
+		}
+	}
+	if b, err = next(4); err != nil {
+		return
+	}
+	raw, err := next(int(binary.LittleEndian.Uint32(b)))
+	if err != nil {
+		return
+	}
+	readRaw := func(field *rawField) (uint64, bool) {
+		if field.offset+field.size > len(raw) {
+			return 0, false
+		}
+		switch field.size {
+		case 4:
+			return uint64(binary.LittleEndian.Uint32(raw[field.offset:])), true
+		case 8:
+			return binary.LittleEndian.Uint64(raw[field.offset:]), true
+		default:
+			return 0, false
+		}
+	}
+	addr, ok = readRaw(attr.rawAddr)
+	if !ok {
+		return 0, 0, false, fmt.Errorf("raw数据中的地址字段无效")
+	}
+	// 使用tracepoint记录的线程号，而不是触发tracepoint的线程
+	if attr.rawTid != nil {
+		if rawTid, tidOk := readRaw(attr.rawTid); tidOk {
+			tid = int(rawTid)
+		}
+	}
+	return tid, addr, true, nil
+}
+
+// 依次读取数据段中的所有地址采样，地址已经转换为缓存行地址，地址为0的记录会被忽略
+func (f *perfDataFile) forEachSample(fn func(tid int, addr uint64)) (zero uint64, err error) {
+	reader := bufio.NewReaderSize(io.NewSectionReader(f.r, int64(f.header.Data.Offset), int64(f.header.Data.Size)),
+		1<<20)
+	header := make([]byte, 8)
+	body := make([]byte, 0, 1<<16)
+	for {
+		if _, err = io.ReadFull(reader, header); err == io.EOF {
+			return zero, nil
+		} else if err != nil {
+			return zero, errors.Wrap(err, "读取记录头出错")
+		}
+		typ := binary.LittleEndian.Uint32(header)
+		size := int(binary.LittleEndian.Uint16(header[6:]))
+		if size < len(header) {
+			return zero, fmt.Errorf("记录大小%d错误", size)
+		}
+		body = body[:size-len(header)]
+		if _, err = io.ReadFull(reader, body); err != nil {
+			return zero, errors.Wrap(err, "读取记录出错")
+		}
+		if typ != perfRecordSample {
+			continue
+		}
+		tid, addr, ok, err := f.parseSample(body)
+		if err != nil {
+			return zero, err
+		} else if !ok {
+			continue
+		}
+		addr &= cacheLineMask
+		if addr == 0 {
+			zero++
+			continue
+		}
+		fn(tid, addr)
+	}
+}
+
+// 读取perf.data文件中的所有地址采样，按线程分批交给consumer
+func consumePerfData(path string, consumer CacheLineAddressConsumer) (*perfDataStat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "打开perf.data文件出错")
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	f, err := openPerfData(file)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := &perfDataStat{ThreadSampleCount: map[int]uint64{}}
+	batcher := newAddrBatcher(consumer, addrBatchSize)
+	stat.ZeroAddr, err = f.forEachSample(func(tid int, addr uint64) {
+		batcher.add(tid, addr)
+		stat.ThreadSampleCount[tid]++
+		stat.Samples++
+	})
+	batcher.flush()
+	return stat, err
+}
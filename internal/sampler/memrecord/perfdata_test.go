@@ -0,0 +1,80 @@
+package memrecord
+
+import (
+	"encoding/binary"
+	"github.com/packagewjx/resourcemanager/test"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type collectConsumer struct {
+	addr map[int][]uint64
+}
+
+func (c *collectConsumer) Consume(tid int, addr []uint64) {
+	c.addr[tid] = append(c.addr[tid], addr...)
+}
+
+func TestConsumePerfData(t *testing.T) {
+	consumer := &collectConsumer{addr: map[int][]uint64{}}
+	stat, err := consumePerfData(filepath.Join(test.GetTestDataDir(), "perf.perfaddr.data"), consumer)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(326), stat.Samples)
+	assert.Equal(t, uint64(1), stat.ZeroAddr)
+	assert.Equal(t, map[int]uint64{9809: 326}, stat.ThreadSampleCount)
+	assert.Len(t, consumer.addr, 1)
+	assert.Len(t, consumer.addr[9809], 326)
+	for _, addr := range consumer.addr[9809] {
+		assert.NotZero(t, addr)
+		assert.Zero(t, addr&^cacheLineMask)
+	}
+}
+
+func TestOpenPerfData(t *testing.T) {
+	file, err := os.Open(filepath.Join(test.GetTestDataDir(), "perf.perfaddr.data"))
+	assert.NoError(t, err)
+	defer func() {
+		_ = file.Close()
+	}()
+	f, err := openPerfData(file)
+	assert.NoError(t, err)
+	assert.Len(t, f.attrs, 3)
+	assert.Len(t, f.idToAttr, 12)
+	// 前两个为mem_inst_retired事件，不包含地址
+	assert.False(t, f.attrs[0].hasAddr())
+	assert.False(t, f.attrs[1].hasAddr())
+	// pebs_addr的字段位置从tracing data中读取
+	tp := f.attrs[2]
+	assert.True(t, tp.hasAddr())
+	assert.Equal(t, &rawField{offset: 16, size: 8}, tp.rawAddr)
+	assert.Equal(t, &rawField{offset: 8, size: 4}, tp.rawTid)
+}
+
+func TestParseSampleAddr(t *testing.T) {
+	// 带有PERF_SAMPLE_ADDR的采样：IDENTIFIER, IP, TID, ADDR
+	attr := &perfEventAttr{sampleType: perfSampleIdentifier | perfSampleIP | perfSampleTID | perfSampleAddr}
+	f := &perfDataFile{attrs: []*perfEventAttr{attr}, idToAttr: map[uint64]*perfEventAttr{7: attr}}
+	body := make([]byte, 32)
+	binary.LittleEndian.PutUint64(body[0:], 7)
+	binary.LittleEndian.PutUint32(body[16:], 100)
+	binary.LittleEndian.PutUint32(body[20:], 101)
+	binary.LittleEndian.PutUint64(body[24:], 0x7fff12345678)
+	tid, addr, ok, err := f.parseSample(body)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 101, tid)
+	assert.Equal(t, uint64(0x7fff12345678), addr)
+
+	// 未知的id
+	binary.LittleEndian.PutUint64(body[0:], 8)
+	_, _, ok, err = f.parseSample(body)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// 长度不足
+	binary.LittleEndian.PutUint64(body[0:], 7)
+	_, _, _, err = f.parseSample(body[:20])
+	assert.Error(t, err)
+}
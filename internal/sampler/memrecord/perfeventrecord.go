@@ -0,0 +1,436 @@
+package memrecord
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/utils"
+	"github.com/packagewjx/resourcemanager/internal/utils/perfevent"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// PEBS访存事件的原始编码（umask<<8 | event），Skylake及之后的Intel服务器处理器相同
+const (
+	eventMemInstRetiredAllLoads  = 0x81d0
+	eventMemInstRetiredAllStores = 0x82d0
+)
+
+const (
+	perfEventPollTimeout    = 100 // 毫秒
+	perfEventRescanInterval = 500 * time.Millisecond
+)
+
+// 使用perf_event_open直接打开PEBS事件采集访存地址，不需要perf可执行文件。
+// samplePeriod为采样周期，duration为采样时长（0为不限制），traceCount为采集的地址数量上限（0为不限制），
+// bufferPages为每个CPU环形缓冲区的页数。
+func NewPerfEventRecorder(samplePeriod uint64, duration time.Duration, traceCount, bufferPages int) (MemRecorder, error) {
+	if bufferPages <= 0 || bufferPages&(bufferPages-1) != 0 {
+		return nil, fmt.Errorf("环形缓冲区页数 %d 必须为2的幂", bufferPages)
+	}
+	if samplePeriod < 1 {
+		samplePeriod = 1
+	}
+	return &perfEventRecorder{
+		samplePeriod: samplePeriod,
+		duration:     duration,
+		traceCount:   traceCount,
+		bufferPages:  bufferPages,
+		logger:       log.New(os.Stdout, "PerfEventRecorder: ", log.LstdFlags|log.Lshortfile|log.Lmsgprefix),
+	}, nil
+}
+
+type perfEventRecorder struct {
+	samplePeriod uint64
+	duration     time.Duration
+	traceCount   int
+	bufferPages  int
+	logger       *log.Logger
+}
+
+var _ MemRecorder = &perfEventRecorder{}
+
+// 一个线程在每个CPU上的所有事件。事件设置了inherit，线程之后创建的线程与进程继承这些事件，不会因为扫描间隔而遗漏。
+// 内核不允许映射不绑定CPU的inherit事件，因此每个CPU打开一组事件，同一个CPU上的事件共用一个环形缓冲区
+type perfEventThread struct {
+	tid int
+	fds []int
+}
+
+// 一个CPU的环形缓冲区，fd为第一个打开的事件
+type perfEventRing struct {
+	cpu int
+	fd  int
+	rb  *perfevent.RingBuffer
+}
+
+type perfEventContext struct {
+	name    string
+	pid     int
+	kill    bool
+	cmd     *exec.Cmd     // RecordCommand启动的进程，RecordProcess时为nil
+	exited  chan struct{} // cmd退出后关闭
+	epfd    int
+	cpus    []int
+	threads map[int]*perfEventThread
+	rings   map[int]*perfEventRing   // 以CPU为键
+	fdRing  map[int32]*perfEventRing // 以环形缓冲区的fd为键
+	batcher *addrBatcher
+	counter *instructionCounter
+	res     *Result
+	lost    uint64
+	zero    uint64
+}
+
+func (p *perfEventRecorder) newContext(name string, consumer CacheLineAddressConsumer) (*perfEventContext, error) {
+	cpus, err := utils.OnlineCPUs()
+	if err != nil {
+		return nil, err
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建epoll出错")
+	}
+	return &perfEventContext{
+		name:    name,
+		epfd:    epfd,
+		cpus:    cpus,
+		threads: map[int]*perfEventThread{},
+		rings:   map[int]*perfEventRing{},
+		fdRing:  map[int32]*perfEventRing{},
+		batcher: newAddrBatcher(consumer, addrBatchSize),
+		counter: newInstructionCounter(),
+		res: &Result{
+			ThreadSampleCount: map[int]uint64{},
+		},
+	}, nil
+}
+
+// 在线程上打开所有PEBS事件。线程已经退出时返回的错误为ESRCH
+func (p *perfEventRecorder) openThread(pCtx *perfEventContext, tid int) error {
+	t := &perfEventThread{tid: tid}
+	closeOpened := func() {
+		for _, fd := range t.fds {
+			if ring, ok := pCtx.fdRing[int32(fd)]; ok {
+				_ = ring.rb.Close()
+				delete(pCtx.rings, ring.cpu)
+				delete(pCtx.fdRing, int32(fd))
+			}
+			_ = syscall.Close(fd)
+		}
+	}
+	bufferBytes := p.bufferPages * os.Getpagesize()
+	for _, cpu := range pCtx.cpus {
+		for _, event := range []uint64{eventMemInstRetiredAllLoads, eventMemInstRetiredAllStores} {
+			attr := &perfevent.Attr{
+				Type:         perfevent.TypeRaw,
+				Config:       event,
+				SamplePeriod: p.samplePeriod,
+				SampleType:   perfevent.SampleTID | perfevent.SampleAddr,
+				// 只采集用户态地址，内核地址去掉高16位后会与用户态地址混淆
+				Bits: perfevent.BitDisabled | perfevent.BitInherit | perfevent.BitExcludeKernel |
+					perfevent.BitExcludeHv | perfevent.BitWatermark,
+				WakeupEvents: uint32(bufferBytes / 4),
+			}
+			var fd int
+			var err error
+			// 部分处理器不支持精确等级2，降级后重试
+			for _, precise := range []uint64{2, 1} {
+				attr.SetPreciseIP(precise)
+				fd, err = perfevent.Open(attr, tid, cpu, -1, 0)
+				if errors.Cause(err) != syscall.EOPNOTSUPP && errors.Cause(err) != syscall.EINVAL {
+					break
+				}
+			}
+			if err != nil {
+				closeOpened()
+				return err
+			}
+			t.fds = append(t.fds, fd)
+			if ring, ok := pCtx.rings[cpu]; ok {
+				err = perfevent.SetOutput(fd, ring.fd)
+			} else {
+				err = p.newRing(pCtx, cpu, fd)
+			}
+			if err != nil {
+				closeOpened()
+				return errors.Wrap(err, "设置环形缓冲区出错")
+			}
+		}
+	}
+
+	for _, fd := range t.fds {
+		if err := perfevent.Enable(fd, false); err != nil {
+			closeOpened()
+			return errors.Wrap(err, "启用事件出错")
+		}
+	}
+	pCtx.threads[tid] = t
+	return nil
+}
+
+// 以fd为CPU创建环形缓冲区。fd关闭之前环形缓冲区一直有效，因此所有事件都在采集结束时才关闭
+func (p *perfEventRecorder) newRing(pCtx *perfEventContext, cpu, fd int) error {
+	rb, err := perfevent.NewRingBuffer(fd, p.bufferPages)
+	if err != nil {
+		return err
+	}
+	err = syscall.EpollCtl(pCtx.epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
+		Events: syscall.EPOLLIN,
+		Fd:     int32(fd),
+	})
+	if err != nil {
+		_ = rb.Close()
+		return errors.Wrap(err, "添加epoll监听出错")
+	}
+	ring := &perfEventRing{cpu: cpu, fd: fd, rb: rb}
+	pCtx.rings[cpu] = ring
+	pCtx.fdRing[int32(fd)] = ring
+	return nil
+}
+
+// 读取环形缓冲区中的所有采样
+func (p *perfEventRecorder) drain(pCtx *perfEventContext, ring *perfEventRing) {
+	ring.rb.Read(func(typ uint32, misc uint16, body []byte) {
+		switch typ {
+		case perfevent.RecordSample:
+			// SampleTID | SampleAddr：pid u32, tid u32, addr u64
+			if len(body) < 16 {
+				return
+			}
+			tid := int(binary.LittleEndian.Uint32(body[4:]))
+			addr := binary.LittleEndian.Uint64(body[8:]) & cacheLineMask
+			if addr == 0 {
+				pCtx.zero++
+				return
+			}
+			pCtx.batcher.add(tid, addr)
+			pCtx.res.addSamples(tid, 1)
+		case perfevent.RecordLost:
+			if len(body) >= 16 {
+				pCtx.lost += binary.LittleEndian.Uint64(body[8:])
+			}
+		}
+	})
+}
+
+// 停止所有事件，读取剩余的采样后关闭
+func (p *perfEventRecorder) closeAll(pCtx *perfEventContext) {
+	for _, t := range pCtx.threads {
+		for _, fd := range t.fds {
+			_ = perfevent.Disable(fd, false)
+		}
+	}
+	for _, ring := range pCtx.rings {
+		p.drain(pCtx, ring)
+		_ = ring.rb.Close()
+	}
+	for _, t := range pCtx.threads {
+		for _, fd := range t.fds {
+			// 关闭后epoll会自动移除监听
+			_ = syscall.Close(fd)
+		}
+	}
+	pCtx.threads = map[int]*perfEventThread{}
+	pCtx.rings = map[int]*perfEventRing{}
+	pCtx.fdRing = map[int32]*perfEventRing{}
+}
+
+// 创建环形缓冲区的线程退出后，事件一直处于挂起状态，不再监听，否则epoll会一直返回。
+// 继承了事件的线程仍可能写入这个环形缓冲区，由定期读取处理
+func (p *perfEventRecorder) ringHungUp(pCtx *perfEventContext, ring *perfEventRing) {
+	p.drain(pCtx, ring)
+	_ = syscall.EpollCtl(pCtx.epfd, syscall.EPOLL_CTL_DEL, ring.fd, nil)
+	delete(pCtx.fdRing, int32(ring.fd))
+}
+
+// 在进程已有的所有线程上打开事件，之后创建的线程通过inherit继承事件
+func (p *perfEventRecorder) openThreads(pCtx *perfEventContext) error {
+	infos, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pCtx.pid))
+	if err != nil {
+		return errors.Wrap(err, "读取进程线程出错")
+	}
+	for _, info := range infos {
+		tid, err := strconv.Atoi(info.Name())
+		if err != nil {
+			continue
+		}
+		err = p.openThread(pCtx, tid)
+		if err != nil && errors.Cause(err) != syscall.ESRCH {
+			p.logger.Printf("%s: 线程 %d 打开事件出错：%v", pCtx.name, tid, err)
+		}
+	}
+	return nil
+}
+
+func (p *perfEventRecorder) record(ctx context.Context, pCtx *perfEventContext, resCh chan<- *Result) {
+	var stopReason string
+	defer func() {
+		p.closeAll(pCtx)
+		_ = syscall.Close(pCtx.epfd)
+		pCtx.batcher.flush()
+		if err := pCtx.counter.fillResult(pCtx.res); err != nil {
+			p.logger.Printf("%s: 无法统计指令数量，使用采样数量代替：%v", pCtx.name, err)
+		}
+		if pCtx.cmd != nil {
+			select {
+			case <-pCtx.exited:
+			default:
+				// 由本程序启动的进程在采集结束后一并结束
+				_ = pCtx.cmd.Process.Kill()
+				<-pCtx.exited
+			}
+		} else if pCtx.kill {
+			_ = syscall.Kill(pCtx.pid, syscall.SIGKILL)
+		}
+		p.logger.Printf("%s: 采集结束（%s），总共采集 %d 条内存访问地址，丢失 %d 条，地址为0的记录 %d 条", pCtx.name,
+			stopReason, pCtx.res.TotalSamples, pCtx.lost, pCtx.zero)
+		resCh <- pCtx.res
+		close(resCh)
+	}()
+
+	var timeout <-chan time.Time
+	if p.duration > 0 {
+		timeout = time.After(p.duration)
+	}
+	events := make([]syscall.EpollEvent, 64)
+	lastScan := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			stopReason = "采集被取消"
+			return
+		case <-timeout:
+			stopReason = "达到采集时长"
+			return
+		case <-pCtx.exited:
+			stopReason = "进程已退出"
+			return
+		default:
+		}
+
+		n, err := syscall.EpollWait(pCtx.epfd, events, perfEventPollTimeout)
+		if err != nil && err != syscall.EINTR {
+			stopReason = "等待事件出错"
+			pCtx.res.Err = errors.Wrap(err, "等待事件出错")
+			return
+		}
+		for i := 0; i < n; i++ {
+			ring, ok := pCtx.fdRing[events[i].Fd]
+			if !ok {
+				continue
+			}
+			if events[i].Events&syscall.EPOLLHUP != 0 {
+				p.ringHungUp(pCtx, ring)
+			} else {
+				p.drain(pCtx, ring)
+			}
+		}
+		if p.traceCount > 0 && pCtx.res.TotalSamples >= uint64(p.traceCount) {
+			stopReason = "达到采集数量"
+			return
+		}
+		if time.Since(lastScan) >= perfEventRescanInterval {
+			lastScan = time.Now()
+			// 指令数量只用于加权，打开失败时在结束时使用采样数量代替
+			if !pCtx.counter.scan(pCtx.pid) {
+				stopReason = "进程已退出"
+				return
+			}
+			// 读取没有达到唤醒阈值的数据，避免长时间积压
+			for _, ring := range pCtx.rings {
+				p.drain(pCtx, ring)
+			}
+		}
+	}
+}
+
+// 启动命令并在执行用户代码之前打开事件。
+// 子进程以ptrace方式启动，exec之后会停止，打开事件后再让其继续执行。ptrace的操作必须在同一个系统线程中完成
+func (p *perfEventRecorder) startCommand(request *RunRequest, pCtx *perfEventContext) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd := exec.Command(request.Cmd, request.Args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "启动命令出错")
+	}
+	pid := cmd.Process.Pid
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil || !ws.Stopped() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return fmt.Errorf("等待命令启动出错，状态 %v：%v", ws, err)
+	}
+
+	pCtx.pid = pid
+	pCtx.cmd = cmd
+	// 子进程的所有线程都由这个线程创建，在这里打开事件后全部继承
+	if err := p.openThread(pCtx, pid); err != nil {
+		p.closeAll(pCtx)
+		_ = cmd.Process.Kill()
+		_ = syscall.PtraceDetach(pid)
+		_ = cmd.Wait()
+		return errors.Wrap(err, "打开PEBS事件出错")
+	}
+	// 指令数量只用于加权，打开失败时在结束时使用采样数量代替
+	_ = pCtx.counter.open(pid)
+	if err := syscall.PtraceDetach(pid); err != nil {
+		p.closeAll(pCtx)
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return errors.Wrap(err, "恢复命令执行出错")
+	}
+	p.logger.Printf("%s: 命令 %d 启动：%s", pCtx.name, pid, cmd.String())
+
+	pCtx.exited = make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(pCtx.exited)
+	}()
+	return nil
+}
+
+func (p *perfEventRecorder) RecordCommand(ctx context.Context, request *RunRequest) (<-chan *Result, error) {
+	resCh := make(chan *Result, 1)
+	pCtx, err := p.newContext(request.Name, request.Consumer)
+	if err != nil {
+		return nil, err
+	}
+	pCtx.kill = request.Kill
+	if err = p.startCommand(request, pCtx); err != nil {
+		_ = syscall.Close(pCtx.epfd)
+		return nil, err
+	}
+	go p.record(ctx, pCtx, resCh)
+	return resCh, nil
+}
+
+func (p *perfEventRecorder) RecordProcess(ctx context.Context, request *AttachRequest) (<-chan *Result, error) {
+	resCh := make(chan *Result, 1)
+	pCtx, err := p.newContext(request.Name, request.Consumer)
+	if err != nil {
+		return nil, err
+	}
+	pCtx.pid = request.Pid
+	pCtx.kill = request.Kill
+	if err = p.openThreads(pCtx); err == nil && len(pCtx.threads) == 0 {
+		err = fmt.Errorf("没有可以打开事件的线程")
+	}
+	if err != nil {
+		p.closeAll(pCtx)
+		_ = syscall.Close(pCtx.epfd)
+		return nil, errors.Wrap(err, fmt.Sprintf("无法在进程 %d 上打开PEBS事件", request.Pid))
+	}
+	_ = pCtx.counter.scan(pCtx.pid)
+	go p.record(ctx, pCtx, resCh)
+	return resCh, nil
+}
@@ -0,0 +1,51 @@
+package memrecord
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewPerfEventRecorder(t *testing.T) {
+	_, err := NewPerfEventRecorder(100, time.Second, 0, 3)
+	assert.Error(t, err)
+
+	r, err := NewPerfEventRecorder(0, time.Second, 0, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), r.(*perfEventRecorder).samplePeriod)
+
+	// 不存在的进程
+	_, err = r.RecordProcess(context.Background(), &AttachRequest{
+		BaseRequest: BaseRequest{Name: "test", Consumer: &testConsumer{t: t}},
+		Pid:         1 << 30,
+	})
+	assert.Error(t, err)
+}
+
+func TestPerfEventRecordLs(t *testing.T) {
+	r, err := NewPerfEventRecorder(100, 3*time.Second, 0, 8)
+	assert.NoError(t, err)
+	ch, err := r.RecordCommand(context.Background(), &RunRequest{
+		BaseRequest: BaseRequest{Name: "test", Consumer: &testConsumer{t: t}},
+		Cmd:         "ls",
+		Args:        []string{"-lR", "/usr/lib"},
+	})
+	// 虚拟机等没有PEBS的环境中无法打开精确事件
+	if cause := errors.Cause(err); cause == syscall.ENOENT || cause == syscall.EOPNOTSUPP {
+		t.Skipf("不支持PEBS：%v", err)
+	}
+	if !assert.NoError(t, err) {
+		return
+	}
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有在时间内执行完毕")
+	case res := <-ch:
+		assert.NoError(t, res.Err)
+		assert.NotZero(t, res.TotalInstructions)
+		assert.NotZero(t, len(res.ThreadInstructionCount))
+	}
+}
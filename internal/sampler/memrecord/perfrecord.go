@@ -1,7 +1,6 @@
 package memrecord
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
@@ -12,7 +11,6 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
@@ -35,13 +33,11 @@ func NewPerfRecorder(overflowCount int, switchOutput, perfExecPath string) (MemR
 	if overflowCount < 1 {
 		overflowCount = 1
 	}
-	_, file, _, _ := runtime.Caller(0)
 
 	return &perfRecorder{
 		overflowCount: overflowCount,
 		switchOutput:  "--switch-output=" + switchOutput,
 		perfExecPath:  perfExecPath,
-		currentPath:   filepath.Dir(file),
 		logger:        log.New(os.Stdout, "PerfRecorder: ", log.LstdFlags|log.Lshortfile|log.Lmsgprefix),
 	}, nil
 }
@@ -50,7 +46,6 @@ type perfRecorder struct {
 	overflowCount int
 	switchOutput  string
 	perfExecPath  string
-	currentPath   string
 	logger        *log.Logger
 }
 
@@ -63,6 +58,9 @@ type perfRecordContext struct {
 	fileWatcher *fsnotify.Watcher
 	consumer    CacheLineAddressConsumer
 	readCnt     uint64
+	pid         int // 被采集的进程，RecordCommand时在perf启动命令后获取
+	counter     *instructionCounter
+	countDone   chan struct{} // 指令数量统计结束后关闭
 }
 
 func (p *perfRecorder) newContext(ctx context.Context, consumer CacheLineAddressConsumer) (*perfRecordContext, error) {
@@ -92,19 +90,34 @@ func (p *perfRecorder) newContext(ctx context.Context, consumer CacheLineAddress
 		fileWatcher: watcher,
 		consumer:    consumer,
 		readCnt:     0,
+		counter:     newInstructionCounter(),
+		countDone:   make(chan struct{}),
 	}, nil
 }
 
 func (p *perfRecorder) perfCmdRunner(perfCtx *perfRecordContext) {
 	p.logger.Println("执行Perf命令", strings.Join(perfCtx.perfCmd.Args, " "))
-	_ = perfCtx.perfCmd.Start()
+	if err := perfCtx.perfCmd.Start(); err != nil {
+		p.logger.Println("Perf进程启动错误", err)
+		close(perfCtx.countDone)
+		_ = perfCtx.fileWatcher.Close()
+		return
+	}
 	errCh := make(chan error)
 	go func() {
 		errCh <- perfCtx.perfCmd.Wait()
 		close(errCh)
+	}()
+	defer func() {
+		// 先结束指令数量统计，再通知resultReader结束
+		close(perfCtx.countDone)
 		_ = perfCtx.fileWatcher.Close()
 	}()
 
+	// perf与被采集的程序同时执行，定期为新的线程打开指令计数事件
+	ticker := time.NewTicker(perfEventRescanInterval)
+	defer ticker.Stop()
+	done := perfCtx.ctx.Done()
 	for {
 		select {
 		case err := <-errCh:
@@ -114,22 +127,39 @@ func (p *perfRecorder) perfCmdRunner(perfCtx *perfRecordContext) {
 				p.logger.Println("Perf进程正常退出")
 			}
 			return
-		case <-perfCtx.ctx.Done():
+		case <-done:
 			_ = syscall.Kill(perfCtx.perfCmd.Process.Pid, syscall.SIGINT)
+			// 只发送一次信号
+			done = nil
+		case <-ticker.C:
+			p.scanInstructionCounter(perfCtx)
+		}
+	}
+}
+
+// 为被采集进程的线程打开指令计数事件。RecordCommand时被采集的进程是perf的子进程
+func (p *perfRecorder) scanInstructionCounter(perfCtx *perfRecordContext) {
+	if perfCtx.pid == 0 {
+		perfCtx.pid = findChildPid(perfCtx.perfCmd.Process.Pid)
+		if perfCtx.pid == 0 {
+			return
 		}
 	}
+	perfCtx.counter.scan(perfCtx.pid)
 }
 
 func (p *perfRecorder) resultReader(perfCtx *perfRecordContext) {
 	ended := false
 	evCh := perfCtx.fileWatcher.Events
 	res := &Result{
-		ThreadInstructionCount: map[int]uint64{},
-		TotalInstructions:      0,
-		Err:                    nil,
+		ThreadSampleCount: map[int]uint64{},
 	}
 	defer func() {
 		perfCtx.cancelFunc()
+		<-perfCtx.countDone
+		if err := perfCtx.counter.fillResult(res); err != nil {
+			p.logger.Printf("无法统计指令数量，使用采样数量代替：%v", err)
+		}
 		p.logger.Printf("地址追踪读取结束。一共采集 %d 条地址记录", perfCtx.readCnt)
 		_ = os.RemoveAll(perfCtx.tmpDir)
 		perfCtx.resCh <- res
@@ -143,15 +173,8 @@ func (p *perfRecorder) resultReader(perfCtx *perfRecordContext) {
 			file := filepath.Base(ev.Name)
 			if file != "perf.data" && ev.Op == fsnotify.Create {
 				p.logger.Printf("正在读取文件 %s", ev.Name)
-				if file != "perf.data" {
-					result := p.parseResult(ev.Name, &perfCtx.readCnt)
-					for tid, addrList := range result {
-						res.TotalInstructions += uint64(len(addrList))
-						res.ThreadInstructionCount[tid] += uint64(len(addrList))
-						perfCtx.consumer.Consume(tid, addrList)
-					}
-					_ = os.Remove(ev.Name)
-				}
+				p.parseResult(ev.Name, perfCtx, res)
+				_ = os.Remove(ev.Name)
 			}
 			if !ok {
 				return
@@ -160,42 +183,20 @@ func (p *perfRecorder) resultReader(perfCtx *perfRecordContext) {
 	}
 }
 
-func (p *perfRecorder) parseResult(file string, readCnt *uint64) map[int][]uint64 {
-	res := map[int][]uint64{}
-	readWriter := bytes.NewBuffer(make([]byte, 0, 4096))
-	perfScriptCmd := exec.Command(filepath.Join(p.perfExecPath, "perf"), "script", "-s", filepath.Join(p.currentPath, "perfaddr.py"),
-		"-i", file)
-	perfScriptCmd.Env = append(perfScriptCmd.Env, "PERF_EXEC_PATH="+p.perfExecPath)
-	perfScriptCmd.Stdout = readWriter
-	perfScriptCmd.Stderr = os.Stdout
-	_ = perfScriptCmd.Start()
-
-	ended := false
-	go func() {
-		_ = perfScriptCmd.Wait()
-		ended = true
-	}()
-	const lineLen = 30
-	buf := make([]byte, lineLen)
-	zeroCount := 0
-	originalCount := *readCnt
-	for !ended || readWriter.Len() > 0 {
-		if readWriter.Len() < lineLen {
-			<-time.After(100 * time.Millisecond)
-		}
-		_, _ = readWriter.Read(buf)
-		tid, _ := strconv.ParseInt(string(buf[:10]), 0, 32)
-		addr, _ := strconv.ParseUint(string(buf[11:len(buf)-1]), 0, 64)
-		if addr == 0 {
-			zeroCount++
-			continue
+// 解析perf输出的perf.data文件，地址直接交给Consumer，并将采样数量累加到结果中
+func (p *perfRecorder) parseResult(file string, perfCtx *perfRecordContext, res *Result) {
+	stat, err := consumePerfData(file, perfCtx.consumer)
+	if err != nil {
+		p.logger.Printf("解析文件 %s 出错：%v", file, err)
+		if stat == nil {
+			return
 		}
-		addr &= 0x0000FFFFFFFFFFC0
-		res[int(tid)] = append(res[int(tid)], addr)
-		*readCnt++
 	}
-	p.logger.Printf("在文件 %s 读取到地址共 %d 条，其中为 0 的记录 %d 条", file, *readCnt-originalCount, zeroCount)
-	return res
+	for tid, cnt := range stat.ThreadSampleCount {
+		res.addSamples(tid, cnt)
+	}
+	perfCtx.readCnt += stat.Samples
+	p.logger.Printf("在文件 %s 读取到地址共 %d 条，其中为 0 的记录 %d 条", file, stat.Samples, stat.ZeroAddr)
 }
 
 func (p *perfRecorder) RecordCommand(ctx context.Context, request *RunRequest) (<-chan *Result, error) {
@@ -217,6 +218,8 @@ func (p *perfRecorder) RecordProcess(ctx context.Context, request *AttachRequest
 		return nil, err
 	}
 	newContext.perfCmd.Args = append(newContext.perfCmd.Args, "-p", strconv.FormatInt(int64(request.Pid), 10))
+	newContext.pid = request.Pid
+	newContext.counter.scan(request.Pid)
 
 	go p.perfCmdRunner(newContext)
 	go p.resultReader(newContext)
@@ -28,12 +28,15 @@ func TestReader(t *testing.T) {
 		core.RootConfig.MemTrace.PerfRecordConfig.PerfExecPath)
 	assert.NoError(t, err)
 	p := m.(*perfRecorder)
-	var cnt uint64
-	result := p.parseResult(filepath.Join(test.GetTestDataDir(), "perf.perfaddr.data"), &cnt)
-	assert.NotZero(t, len(result))
-	for tid, uint64s := range result {
+	res := &Result{}
+	p.parseResult(filepath.Join(test.GetTestDataDir(), "perf.perfaddr.data"), &perfRecordContext{
+		consumer: &testConsumer{t: t},
+	}, res)
+	assert.Equal(t, uint64(326), res.TotalSamples)
+	assert.NotZero(t, len(res.ThreadSampleCount))
+	for tid, cnt := range res.ThreadSampleCount {
 		assert.NotZero(t, tid)
-		assert.NotZero(t, len(uint64s))
+		assert.NotZero(t, cnt)
 	}
 }
 
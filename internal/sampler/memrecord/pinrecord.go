@@ -7,6 +7,7 @@ import (
 	"encoding/csv"
 	"fmt"
 	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/utils"
 	"github.com/pkg/errors"
 	"io"
 	"io/ioutil"
@@ -17,6 +18,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 	"unsafe"
 )
@@ -34,12 +36,21 @@ type pinContext struct {
 	pinCmd     *exec.Cmd
 	kill       bool
 	resCh      chan *Result
-	readCnt    uint // 性能优化使用，监测读取速度
+	readCnt    uint    // 性能优化使用，监测读取速度
+	samples    *Result // 只记录采样数量
 	fifoPath   string
 	iCountPath string
 	consumer   CacheLineAddressConsumer
+	stageDir   string              // 追踪容器内进程时在容器中创建的临时目录
+	tidMap     *tidMappingConsumer // 追踪容器内进程时映射线程号
+	exited     chan struct{}       // Pin进程退出或者启动失败后关闭
 }
 
+const (
+	pinExitGrace   = time.Second     // 追踪结束后等待Pin自行退出的时间
+	pinStopTimeout = 5 * time.Second // 发送SIGINT后等待Pin退出的时间，超时后强制结束
+)
+
 func checkKernelConfig() error {
 	file, err := ioutil.ReadFile("/proc/sys/kernel/yama/ptrace_scope")
 	if err != nil {
@@ -90,9 +101,8 @@ outerLoop:
 	for cnt, err = reader.Read(buf); err == nil || (err == io.EOF && cnt != 0); cnt, err = reader.Read(buf) {
 		select {
 		case <-ctx.Done():
+			// 管道中剩余的内容由调用者丢弃
 			m.logger.Println("采集中途结束")
-			// 需要将管道内容重导向到null
-			_ = exec.Command("cat", pinCtx.fifoPath, ">", "/dev/null").Run()
 			break outerLoop
 		default:
 		}
@@ -102,6 +112,7 @@ outerLoop:
 		if data == 0 {
 			// 上一次结束
 			wg.Wait()
+			pinCtx.samples.addSamples(currTid, uint64(len(addrList)))
 			wg.Add(1)
 			go func(tid int, list []uint64) {
 				pinCtx.consumer.Consume(tid, list)
@@ -114,20 +125,11 @@ outerLoop:
 		if currTid == 0 {
 			currTid = int(data)
 		} else {
-			addr := data & 0xFFFFFFFFFFFF
-			addrLine := addr & 0xFFFFFFFFFFC0
-			length := data >> 48
+			addr, length := utils.DecodePinEntry(data)
 			if length == 0 {
 				m.logger.Printf("长度出现了为0的条目")
-				length = 1
-			}
-			addrEnd := addr + length - 1
-			addrEndLine := addrEnd & 0xFFFFFFFFFFC0
-			lineCount := int((addrEndLine-addrLine)>>6 + 1)
-			for i := 0; i < lineCount; i++ {
-				addrList = append(addrList, addrLine)
-				addrLine += 0x40
 			}
+			addrList = utils.AppendCacheLines(addrList, addr, length)
 		}
 	}
 	wg.Wait() // 读取完毕后可能还没有计算完毕，需要等待
@@ -154,6 +156,9 @@ func (m *pinRecorder) pinTraceReader(ctx context.Context, pinCtx *pinContext, ca
 		}
 		_ = os.Remove(pinCtx.fifoPath)
 		_ = os.Remove(pinCtx.iCountPath)
+		if pinCtx.stageDir != "" {
+			_ = os.RemoveAll(pinCtx.stageDir)
+		}
 		close(pinCtx.resCh)
 	}()
 
@@ -177,32 +182,47 @@ func (m *pinRecorder) pinTraceReader(ctx context.Context, pinCtx *pinContext, ca
 		return
 	}
 	err = m.readFromPipe(ctx, fin, pinCtx)
+	if ctx.Err() != nil {
+		// 提前结束时Pin仍然附着在进程上并写入管道，需要继续读取并丢弃，直到Pin关闭管道，否则进程会阻塞在写管道上。
+		// 不能直接关闭读取端，否则写入端会收到SIGPIPE导致进程退出
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, fin)
+			_ = fin.Close()
+		}()
+	} else {
+		_ = fin.Close()
+	}
 	if err != nil {
 		pinCtx.resCh <- &Result{
 			Err: err,
 		}
+		return
 	}
-	_ = fin.Close()
 
-	// 读取指标数量文件用于加权平均
+	res := &Result{
+		ThreadSampleCount: pinCtx.samples.ThreadSampleCount,
+		TotalSamples:      pinCtx.samples.TotalSamples,
+	}
+	// 读取指标数量文件用于加权平均。提前结束时Pin可能还没有写入这个文件
 	counts, err := readInstructionCounts(pinCtx.iCountPath)
-	totalCount := uint64(0)
 	if err != nil {
-		m.logger.Printf("读取指令数量文件 %s 出错： %v", pinCtx.iCountPath, err)
+		m.logger.Printf("读取指令数量文件 %s 出错，使用采样数量代替： %v", pinCtx.iCountPath, err)
+		res.fillInstructionsWithSamples()
 	} else {
-		totalCount = counts[0]
+		res.ThreadInstructionCount = counts
+		res.TotalInstructions = counts[0]
+	}
+	if pinCtx.tidMap != nil {
+		res.ThreadSampleCount = pinCtx.tidMap.mapCounts(res.ThreadSampleCount)
+		res.ThreadInstructionCount = pinCtx.tidMap.mapCounts(res.ThreadInstructionCount)
 	}
 
 	m.logger.Printf("采集结束，总共采集 %d 条内存访问地址", pinCtx.readCnt)
-	_ = fin.Close()
-	pinCtx.resCh <- &Result{
-		ThreadInstructionCount: counts,
-		TotalInstructions:      totalCount,
-		Err:                    nil,
-	}
+	pinCtx.resCh <- res
 }
 
-func (m *pinRecorder) pinCmdRunner(pinCmd *exec.Cmd, errCh chan<- error) {
+func (m *pinRecorder) pinCmdRunner(pinCmd *exec.Cmd, exited chan<- struct{}, errCh chan<- error) {
+	defer close(exited)
 	l := &logWriter{
 		logger: m.logger,
 	}
@@ -259,7 +279,8 @@ outerLoop:
 	}
 
 	errCh := make(chan error)
-	go m.pinCmdRunner(pinCtx.pinCmd, errCh)
+	pinCtx.exited = make(chan struct{})
+	go m.pinCmdRunner(pinCtx.pinCmd, pinCtx.exited, errCh)
 
 	// 确保Pin成功运行，否则会引起OpenFile堵塞
 	select {
@@ -272,6 +293,9 @@ outerLoop:
 		close(errCh)
 		if err != nil {
 			_ = os.Remove(pinCtx.fifoPath)
+			if pinCtx.stageDir != "" {
+				_ = os.RemoveAll(pinCtx.stageDir)
+			}
 			pinCtx.resCh <- &Result{
 				Err: err,
 			}
@@ -287,10 +311,32 @@ outerLoop:
 	go m.reporter(childCtx, pinCtx, cancel)
 	go func() {
 		<-childCtx.Done()
+		m.stopPin(pinCtx)
 		<-m.controlChan
 	}()
 }
 
+// 追踪结束后确保Pin进程退出，使其脱离目标进程。正常结束时Pin关闭管道后会自行退出；达到限制或者被取消时Pin仍然附着在
+// 目标进程上，先发送SIGINT，超时后强制结束，并等待Pin进程退出
+func (m *pinRecorder) stopPin(pinCtx *pinContext) {
+	select {
+	case <-pinCtx.exited:
+		return
+	case <-time.After(pinExitGrace):
+	}
+	pid := pinCtx.pinCmd.Process.Pid
+	m.logger.Printf("%s: 正在结束Pin进程 %d", pinCtx.name, pid)
+	_ = pinCtx.pinCmd.Process.Signal(syscall.SIGINT)
+	select {
+	case <-pinCtx.exited:
+		return
+	case <-time.After(pinStopTimeout):
+	}
+	m.logger.Printf("%s: Pin进程 %d 没有响应SIGINT，强制结束", pinCtx.name, pid)
+	_ = pinCtx.pinCmd.Process.Kill()
+	<-pinCtx.exited
+}
+
 func (m *pinRecorder) recordPreparation(requestName string) (fifoPath, pinToolPath, iCountPath string, err error) {
 	fifoPath, err = mkTempFifo()
 	if err != nil {
@@ -325,15 +371,47 @@ func (m *pinRecorder) RecordCommand(ctx context.Context, request *RunRequest) (<
 		fifoPath:   fifoPath,
 		iCountPath: iCountPath,
 		consumer:   request.Consumer,
+		samples:    &Result{},
 	}
 
 	m.startMemTrace(ctx, pinCtx)
 	return resCh, nil
 }
 
+// 准备附着到进程时使用的文件。进程在容器中时（或者请求指定了RootDir）在容器的根目录中准备，否则与RecordCommand相同
+func (m *pinRecorder) attachPreparation(request *AttachRequest) (*pinStage, error) {
+	root, inContainer, err := resolveContainerRoot(request.Pid, request.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	if inContainer {
+		toolPath, _ := filepath.Abs(m.toolPath)
+		stage, err := stagePinFiles(root, toolPath, request.Name)
+		if err != nil {
+			return nil, err
+		}
+		m.logger.Printf("%s: 进程 %d 位于容器中，Pin文件放在 %s", request.Name, request.Pid, stage.dir)
+		return stage, nil
+	}
+	fifoPath, pinToolPath, iCountPath, err := m.recordPreparation(request.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &pinStage{
+		toolPath:       pinToolPath,
+		fifoPath:       fifoPath,
+		iCountPath:     iCountPath,
+		localToolPath:  pinToolPath,
+		localFifoPath:  fifoPath,
+		localCountPath: iCountPath,
+	}, nil
+}
+
+// 附着到进程进行追踪。进程在容器中时（或者请求指定了RootDir），Pin工具、管道与指令数量文件放在容器的根目录中，
+// 线程号映射为本名称空间的线程号
 func (m *pinRecorder) RecordProcess(ctx context.Context, request *AttachRequest) (<-chan *Result, error) {
 	resCh := make(chan *Result, 1)
-	fifoPath, pinToolPath, iCountPath, err := m.recordPreparation(request.Name)
+	stage, err := m.attachPreparation(request)
 	if err != nil {
 		resCh <- &Result{
 			Err: err,
@@ -341,18 +419,25 @@ func (m *pinRecorder) RecordProcess(ctx context.Context, request *AttachRequest)
 		close(resCh)
 		return resCh, nil
 	}
+	// 传给Pin的是目标进程看到的路径
 	pinCmd := exec.Command(core.RootConfig.MemTrace.PinConfig.PinPath, "-pid", fmt.Sprintf("%d", request.Pid), "-t",
-		pinToolPath, "-binary", "-fifo", fifoPath, "-buffersize", fmt.Sprintf("%d", m.bufferSize),
-		"-stopat", fmt.Sprintf("%d", m.traceCount), "-icountcsv", iCountPath)
+		stage.localToolPath, "-binary", "-fifo", stage.localFifoPath, "-buffersize", fmt.Sprintf("%d", m.bufferSize),
+		"-stopat", fmt.Sprintf("%d", m.traceCount), "-icountcsv", stage.localCountPath)
 
 	pinCtx := &pinContext{
 		name:       request.Name,
 		pinCmd:     pinCmd,
 		kill:       request.Kill,
 		resCh:      resCh,
-		fifoPath:   fifoPath,
-		iCountPath: iCountPath,
+		fifoPath:   stage.fifoPath,
+		iCountPath: stage.iCountPath,
 		consumer:   request.Consumer,
+		samples:    &Result{},
+	}
+	if stage.dir != "" {
+		pinCtx.stageDir = stage.dir
+		pinCtx.tidMap = newTidMappingConsumer(request.Consumer, request.Pid)
+		pinCtx.consumer = pinCtx.tidMap
 	}
 
 	m.startMemTrace(ctx, pinCtx)
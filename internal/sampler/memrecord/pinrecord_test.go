@@ -6,6 +6,12 @@ import (
 	"github.com/packagewjx/resourcemanager/internal/core"
 	"github.com/packagewjx/resourcemanager/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -56,3 +62,79 @@ func TestRecord(t *testing.T) {
 
 	_, _ = syscall.Wait4(pid, nil, 0, nil)
 }
+
+// 模拟附着后一直不写入数据的Pin，忽略SIGINT，只能被强制结束
+const fakePinScript = `#!/bin/sh
+trap '' INT
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-fifo" ]; then fifo=$2; fi
+	shift
+done
+echo $$ > "%s"
+exec 3>"$fifo"
+while true; do sleep 1; done
+`
+
+func TestPinStopOnCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakepin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	pidFile := filepath.Join(dir, "pid")
+	pinPath := filepath.Join(dir, "pin")
+	if !assert.NoError(t, ioutil.WriteFile(pinPath, []byte(strings.Replace(fakePinScript, "%s", pidFile, 1)), 0755)) {
+		return
+	}
+	oldPinPath := core.RootConfig.MemTrace.PinConfig.PinPath
+	defer func() {
+		core.RootConfig.MemTrace.PinConfig.PinPath = oldPinPath
+	}()
+	core.RootConfig.MemTrace.PinConfig.PinPath = pinPath
+
+	recorder := &pinRecorder{
+		traceCount:  100000,
+		toolPath:    filepath.Join(dir, "tool.so"),
+		bufferSize:  1000,
+		logger:      log.New(ioutil.Discard, "", 0),
+		controlChan: make(chan struct{}, 1),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resCh, err := recorder.RecordProcess(ctx, &AttachRequest{
+		BaseRequest: BaseRequest{
+			Name: filepath.Join(dir, "test"),
+			Consumer: NewRTHCalculatorConsumer(func(tid int) algorithm.RTHCalculator {
+				return algorithm.ReservoirCalculator(100)
+			}),
+		},
+		Pid: os.Getpid(),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	<-time.After(time.Second)
+	content, err := ioutil.ReadFile(pidFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	pinPid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+	assert.NoError(t, syscall.Kill(pinPid, 0))
+
+	// 取消后Pin被结束，管道关闭，追踪返回结果
+	cancel()
+	select {
+	case <-resCh:
+	case <-time.After(pinExitGrace + pinStopTimeout + 5*time.Second):
+		t.Fatal("取消后追踪没有结束")
+	}
+	// Pin进程退出并被回收后才释放Pin实例
+	select {
+	case recorder.controlChan <- struct{}{}:
+	case <-time.After(pinExitGrace + pinStopTimeout + 5*time.Second):
+		t.Fatal("Pin实例没有释放")
+	}
+	assert.Equal(t, syscall.ESRCH, syscall.Kill(pinPid, 0))
+}
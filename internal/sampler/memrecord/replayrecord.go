@@ -0,0 +1,214 @@
+package memrecord
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/utils"
+	"github.com/pkg/errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+// 回放的追踪文件格式
+type TraceFormat string
+
+var (
+	TraceFormatAuto TraceFormat = ""     // 根据文件内容自动判断
+	TraceFormatPin  TraceFormat = "pin"  // Pin工具输出的二进制格式
+	TraceFormatCTF  TraceFormat = "ctf"  // CTF格式目录，需要babeltrace
+	TraceFormatPerf TraceFormat = "perf" // perf record输出的perf.data
+	TraceFormatRM   TraceFormat = "rm"   // TraceFileWriter输出的压缩追踪文件
+)
+
+// 回放已经记录的追踪文件，将地址交给请求中的Consumer。请求中的命令与Pid会被忽略。
+// Pin格式的文件若存在同名的.icount.csv文件，则使用其中的指令数量，否则与其他格式一样使用每个线程的地址数量。
+func NewReplayRecorder(file string, format TraceFormat) (MemRecorder, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取追踪文件出错")
+	}
+	if format == TraceFormatAuto {
+		format, err = detectTraceFormat(file, info)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch format {
+	case TraceFormatPin, TraceFormatCTF, TraceFormatPerf, TraceFormatRM:
+	default:
+		return nil, fmt.Errorf("不支持的追踪文件格式 %s", format)
+	}
+	return &replayRecorder{
+		file:   file,
+		format: format,
+		logger: log.New(os.Stdout, "ReplayRecorder: ", log.LstdFlags|log.Lshortfile|log.Lmsgprefix),
+	}, nil
+}
+
+// 64位系统上线程号的最大值
+const pidMaxLimit = 1 << 22
+
+func detectTraceFormat(file string, info os.FileInfo) (TraceFormat, error) {
+	if info.IsDir() {
+		return TraceFormatCTF, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errors.Wrap(err, "打开追踪文件出错")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	head := make([]byte, 8)
+	if _, err = io.ReadFull(f, head); err != nil {
+		return "", errors.Wrap(err, "读取追踪文件出错")
+	}
+	if bytes.Equal(head, []byte("PERFILE2")) {
+		return TraceFormatPerf, nil
+	} else if string(head) == traceFileMagic {
+		return TraceFormatRM, nil
+	}
+	// Pin格式没有文件头，由8字节的条目组成，每段记录以线程号开始
+	if tid := binary.LittleEndian.Uint64(head); info.Size()%8 == 0 && tid != 0 && tid <= pidMaxLimit {
+		return TraceFormatPin, nil
+	}
+	return "", fmt.Errorf("无法识别追踪文件 %s 的格式，请指定格式", file)
+}
+
+type replayRecorder struct {
+	file   string
+	format TraceFormat
+	logger *log.Logger
+}
+
+var _ MemRecorder = &replayRecorder{}
+
+func (r *replayRecorder) replay(ctx context.Context, name string, consumer CacheLineAddressConsumer) *Result {
+	res := &Result{
+		ThreadSampleCount: map[int]uint64{},
+	}
+	// 没有记录指令数量的格式使用采样数量代替
+	defer func() {
+		if res.ThreadInstructionCount == nil {
+			res.fillInstructionsWithSamples()
+		}
+	}()
+	count := func(tid int, n int) {
+		res.addSamples(tid, uint64(n))
+	}
+	r.logger.Printf("%s: 开始回放 %s 格式的追踪文件 %s", name, r.format, r.file)
+
+	switch r.format {
+	case TraceFormatPin:
+		reader, err := utils.NewPinBinaryReader(r.file)
+		if err != nil {
+			res.Err = errors.Wrap(err, "打开追踪文件出错")
+			return res
+		}
+		ch := reader.AsyncRead()
+		var lines []uint64
+		for record := range ch {
+			select {
+			case <-ctx.Done():
+				// 读取剩余内容，使读取协程能够结束
+				for range ch {
+				}
+				r.logger.Printf("%s: 回放中途结束", name)
+				return res
+			default:
+			}
+			lines = lines[:0]
+			for _, entry := range record.List {
+				addr, size := utils.DecodePinEntry(entry)
+				lines = utils.AppendCacheLines(lines, addr, size)
+			}
+			if len(lines) == 0 {
+				continue
+			}
+			consumer.Consume(record.Tid, append([]uint64(nil), lines...))
+			count(record.Tid, len(lines))
+		}
+		// 与Pin采集一致，有指令数量文件时使用其中的数量进行加权
+		if counts, err := readInstructionCounts(r.file + ".icount.csv"); err == nil {
+			res.ThreadInstructionCount = counts
+			res.TotalInstructions = counts[0]
+		}
+	case TraceFormatCTF:
+		trace, err := utils.ParseCTFTrace(r.file)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		// 按线程号顺序回放，使每次回放的顺序相同
+		tids := make([]int, 0, len(trace))
+		for tid := range trace {
+			tids = append(tids, tid)
+		}
+		sort.Ints(tids)
+		for _, tid := range tids {
+			lines := trace[tid]
+			for start := 0; start < len(lines); start += addrBatchSize {
+				if ctx.Err() != nil {
+					r.logger.Printf("%s: 回放中途结束", name)
+					return res
+				}
+				end := start + addrBatchSize
+				if end > len(lines) {
+					end = len(lines)
+				}
+				consumer.Consume(tid, lines[start:end])
+				count(tid, end-start)
+			}
+		}
+	case TraceFormatPerf:
+		stat, err := consumePerfData(r.file, consumer)
+		if err != nil {
+			res.Err = err
+		}
+		if stat != nil {
+			res.ThreadSampleCount = stat.ThreadSampleCount
+			res.TotalSamples = stat.Samples
+		}
+	case TraceFormatRM:
+		reader, err := OpenTraceFile(r.file)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		defer func() {
+			_ = reader.Close()
+		}()
+		err = reader.Replay(ctx, consumer)
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			r.logger.Printf("%s: 回放中途结束", name)
+		} else if err != nil {
+			res.Err = err
+		}
+		// 使用记录时保存的指令数量
+		res.ThreadSampleCount, res.TotalSamples = reader.SampleCount()
+		res.ThreadInstructionCount, res.TotalInstructions = reader.InstructionCount()
+	}
+	r.logger.Printf("%s: 回放结束，共 %d 个线程，%d 条地址", name, len(res.ThreadSampleCount), res.TotalSamples)
+	return res
+}
+
+func (r *replayRecorder) record(ctx context.Context, name string, consumer CacheLineAddressConsumer) <-chan *Result {
+	resCh := make(chan *Result, 1)
+	go func() {
+		resCh <- r.replay(ctx, name, consumer)
+		close(resCh)
+	}()
+	return resCh
+}
+
+func (r *replayRecorder) RecordCommand(ctx context.Context, request *RunRequest) (<-chan *Result, error) {
+	return r.record(ctx, request.Name, request.Consumer), nil
+}
+
+func (r *replayRecorder) RecordProcess(ctx context.Context, request *AttachRequest) (<-chan *Result, error) {
+	return r.record(ctx, request.Name, request.Consumer), nil
+}
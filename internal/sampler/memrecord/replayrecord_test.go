@@ -0,0 +1,94 @@
+package memrecord
+
+import (
+	"context"
+	"github.com/packagewjx/resourcemanager/internal/algorithm"
+	"github.com/packagewjx/resourcemanager/test"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayRecorder(t *testing.T) {
+	for _, tc := range []struct {
+		file   string
+		format TraceFormat
+	}{
+		{file: "ls.dat", format: TraceFormatPin},
+		{file: "perf.perfaddr.data", format: TraceFormatPerf},
+	} {
+		recorder, err := NewReplayRecorder(filepath.Join(test.GetTestDataDir(), tc.file), TraceFormatAuto)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.format, recorder.(*replayRecorder).format)
+
+		consumer := &collectConsumer{addr: map[int][]uint64{}}
+		ch, err := recorder.RecordCommand(context.Background(), &RunRequest{
+			BaseRequest: BaseRequest{Name: "test", Consumer: consumer},
+		})
+		assert.NoError(t, err)
+		res := <-ch
+		assert.NoError(t, res.Err)
+		assert.NotZero(t, res.TotalInstructions)
+		assert.NotEmpty(t, res.ThreadInstructionCount)
+		total := uint64(0)
+		for tid, addr := range consumer.addr {
+			assert.NotZero(t, tid)
+			assert.Equal(t, uint64(len(addr)), res.ThreadSampleCount[tid])
+			total += uint64(len(addr))
+			for _, a := range addr {
+				assert.NotZero(t, a)
+				assert.Zero(t, a&0x3F)
+			}
+		}
+		assert.Equal(t, total, res.TotalSamples)
+	}
+
+	_, err := NewReplayRecorder(filepath.Join(test.GetTestDataDir(), "ls.dat"), "unknown")
+	assert.Error(t, err)
+	_, err = NewReplayRecorder(filepath.Join(test.GetTestDataDir(), "not-exist.dat"), TraceFormatAuto)
+	assert.Error(t, err)
+
+	// 无法识别的文件不当作Pin格式
+	garbage, err := ioutil.TempFile(os.TempDir(), "tmp.replay.*")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.Remove(garbage.Name())
+	}()
+	_, _ = garbage.WriteString("this is not a trace file")
+	_ = garbage.Close()
+	_, err = NewReplayRecorder(garbage.Name(), TraceFormatAuto)
+	assert.Error(t, err)
+}
+
+func TestReplayRecorderCancel(t *testing.T) {
+	recorder, err := NewReplayRecorder(filepath.Join(test.GetTestDataDir(), "ls.dat"), TraceFormatPin)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ch, _ := recorder.RecordProcess(ctx, &AttachRequest{
+		BaseRequest: BaseRequest{Name: "test", Consumer: &collectConsumer{addr: map[int][]uint64{}}},
+	})
+	res := <-ch
+	assert.NoError(t, res.Err)
+	assert.Zero(t, res.TotalInstructions)
+}
+
+func BenchmarkReplayPin(b *testing.B) {
+	recorder, err := NewReplayRecorder(filepath.Join(test.GetTestDataDir(), "ls.dat"), TraceFormatPin)
+	if err != nil {
+		b.Fatal(err)
+	}
+	recorder.(*replayRecorder).logger.SetOutput(ioutil.Discard)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		consumer := NewRTHCalculatorConsumer(func(tid int) algorithm.RTHCalculator {
+			return algorithm.ReservoirCalculator(100000)
+		})
+		ch, _ := recorder.RecordCommand(context.Background(), &RunRequest{
+			BaseRequest: BaseRequest{Name: "bench", Consumer: consumer},
+		})
+		<-ch
+	}
+}
@@ -0,0 +1,444 @@
+package memrecord
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+/*
+压缩的地址追踪文件格式：
+	文件头：8字节魔数
+	数据块：线程号(u32) 地址数量(u32) 压缩后长度(u32) 压缩数据
+	        压缩数据为flate压缩后的片段表与地址序列。片段表为片段数量与每个片段的写入序号、地址数量，均为uvarint编码，
+	        写入序号是地址被Consume时的全局顺序，用于回放时恢复线程之间的交织顺序。
+	        每个地址为与前一个地址之差的zigzag varint编码
+	索引：JSON格式，记录每个线程的数据块位置与指令数量
+	文件尾：索引偏移(u64) 8字节魔数
+所有整数均为小端序。
+*/
+
+const (
+	traceFileMagic       = "RMTRACE2"
+	traceChunkHeaderSize = 12
+	traceFileTrailerSize = 16
+	// 每个线程攒够这么多条地址后压缩写入一个数据块
+	traceChunkSize = 1 << 16
+)
+
+type traceChunk struct {
+	Offset int64
+	Count  int
+}
+
+// 数据块中来自同一次Consume的一段地址
+type traceSpan struct {
+	seq   uint64
+	count int
+}
+
+// 将spans在第n个地址处分开，跨越分界的片段分为序号相同的两段
+func splitSpans(spans []traceSpan, n int) (head, tail []traceSpan) {
+	for i, span := range spans {
+		if n == 0 {
+			return head, spans[i:]
+		}
+		if span.count <= n {
+			head = append(head, span)
+			n -= span.count
+			continue
+		}
+		head = append(head, traceSpan{seq: span.seq, count: n})
+		tail = append([]traceSpan{{seq: span.seq, count: span.count - n}}, spans[i+1:]...)
+		return head, tail
+	}
+	return head, nil
+}
+
+type traceFileIndex struct {
+	Threads                map[int][]traceChunk
+	ThreadInstructionCount map[int]uint64
+	TotalInstructions      uint64
+}
+
+// 将地址流写入压缩追踪文件的Consumer，实现了io.Closer，可以由CloseConsumer与包装它的Consumer关闭。
+// 写入完毕后必须调用Close写入索引，需要保存指令数量时在Close之前调用SetResult
+type TraceFileWriter struct {
+	lock    sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	offset  int64
+	pending map[int][]uint64
+	spans   map[int][]traceSpan // pending中地址所属的片段
+	seq     uint64
+	index   *traceFileIndex
+	buf     bytes.Buffer
+	flate   *flate.Writer
+	err     error
+	closed  bool
+}
+
+var _ CacheLineAddressConsumer = &TraceFileWriter{}
+var _ io.Closer = &TraceFileWriter{}
+
+func CreateTraceFile(path string) (*TraceFileWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建追踪文件出错")
+	}
+	w := &TraceFileWriter{
+		f:       f,
+		w:       bufio.NewWriterSize(f, 1<<20),
+		pending: map[int][]uint64{},
+		spans:   map[int][]traceSpan{},
+		index: &traceFileIndex{
+			Threads: map[int][]traceChunk{},
+		},
+	}
+	w.flate, _ = flate.NewWriter(&w.buf, flate.DefaultCompression)
+	if _, err = w.w.WriteString(traceFileMagic); err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "写入追踪文件出错")
+	}
+	w.offset = int64(len(traceFileMagic))
+	return w, nil
+}
+
+func (w *TraceFileWriter) Consume(tid int, addr []uint64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.err != nil || len(addr) == 0 {
+		return
+	}
+	w.seq++
+	pending := append(w.pending[tid], addr...)
+	spans := append(w.spans[tid], traceSpan{seq: w.seq, count: len(addr)})
+	for len(pending) >= traceChunkSize {
+		var head []traceSpan
+		head, spans = splitSpans(spans, traceChunkSize)
+		if w.err = w.writeChunk(tid, pending[:traceChunkSize], head); w.err != nil {
+			return
+		}
+		pending = pending[traceChunkSize:]
+	}
+	// 重新分配，避免一直持有Consumer传入的大数组
+	w.pending[tid] = append(make([]uint64, 0, len(pending)), pending...)
+	w.spans[tid] = spans
+}
+
+func (w *TraceFileWriter) writeChunk(tid int, addr []uint64, spans []traceSpan) error {
+	w.buf.Reset()
+	w.flate.Reset(&w.buf)
+	varint := make([]byte, binary.MaxVarintLen64)
+	spanTable := binary.AppendUvarint(nil, uint64(len(spans)))
+	for _, span := range spans {
+		spanTable = binary.AppendUvarint(spanTable, span.seq)
+		spanTable = binary.AppendUvarint(spanTable, uint64(span.count))
+	}
+	if _, err := w.flate.Write(spanTable); err != nil {
+		return errors.Wrap(err, "压缩数据块出错")
+	}
+	last := uint64(0)
+	for _, a := range addr {
+		n := binary.PutVarint(varint, int64(a-last))
+		last = a
+		if _, err := w.flate.Write(varint[:n]); err != nil {
+			return errors.Wrap(err, "压缩数据块出错")
+		}
+	}
+	if err := w.flate.Close(); err != nil {
+		return errors.Wrap(err, "压缩数据块出错")
+	}
+
+	header := make([]byte, traceChunkHeaderSize)
+	binary.LittleEndian.PutUint32(header, uint32(tid))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(addr)))
+	binary.LittleEndian.PutUint32(header[8:], uint32(w.buf.Len()))
+	if _, err := w.w.Write(header); err != nil {
+		return errors.Wrap(err, "写入追踪文件出错")
+	}
+	if _, err := w.w.Write(w.buf.Bytes()); err != nil {
+		return errors.Wrap(err, "写入追踪文件出错")
+	}
+	w.index.Threads[tid] = append(w.index.Threads[tid], traceChunk{Offset: w.offset, Count: len(addr)})
+	w.offset += int64(traceChunkHeaderSize + w.buf.Len())
+	return nil
+}
+
+// 记录结束后传入记录的结果，Close时将其中的指令数量一并保存，用于之后加权计算MRC
+func (w *TraceFileWriter) SetResult(res *Result) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if res != nil {
+		w.index.ThreadInstructionCount = res.ThreadInstructionCount
+		w.index.TotalInstructions = res.TotalInstructions
+	}
+}
+
+// 写入剩余的数据与索引并关闭文件。重复调用不做任何事
+func (w *TraceFileWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer func() {
+		_ = w.f.Close()
+	}()
+	if w.err != nil {
+		return w.err
+	}
+	tids := make([]int, 0, len(w.pending))
+	for tid := range w.pending {
+		tids = append(tids, tid)
+	}
+	sort.Ints(tids)
+	for _, tid := range tids {
+		if len(w.pending[tid]) == 0 {
+			continue
+		}
+		if err := w.writeChunk(tid, w.pending[tid], w.spans[tid]); err != nil {
+			return err
+		}
+	}
+	w.pending = map[int][]uint64{}
+	w.spans = map[int][]traceSpan{}
+
+	content, err := json.Marshal(w.index)
+	if err != nil {
+		return errors.Wrap(err, "序列化追踪文件索引出错")
+	}
+	trailer := make([]byte, traceFileTrailerSize)
+	binary.LittleEndian.PutUint64(trailer, uint64(w.offset))
+	copy(trailer[8:], traceFileMagic)
+	if _, err = w.w.Write(content); err != nil {
+		return errors.Wrap(err, "写入追踪文件索引出错")
+	}
+	if _, err = w.w.Write(trailer); err != nil {
+		return errors.Wrap(err, "写入追踪文件索引出错")
+	}
+	return errors.Wrap(w.w.Flush(), "写入追踪文件出错")
+}
+
+// 读取压缩追踪文件
+type TraceFileReader struct {
+	f     *os.File
+	index *traceFileIndex
+}
+
+func OpenTraceFile(path string) (*TraceFileReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "打开追踪文件出错")
+	}
+	r := &TraceFileReader{f: f}
+	if err = r.readIndex(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *TraceFileReader) readIndex() error {
+	info, err := r.f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "读取追踪文件信息出错")
+	}
+	size := info.Size()
+	if size < int64(len(traceFileMagic)+traceFileTrailerSize) {
+		return fmt.Errorf("追踪文件长度过短")
+	}
+	head := make([]byte, len(traceFileMagic))
+	trailer := make([]byte, traceFileTrailerSize)
+	if _, err = r.f.ReadAt(head, 0); err != nil {
+		return errors.Wrap(err, "读取追踪文件出错")
+	}
+	if _, err = r.f.ReadAt(trailer, size-traceFileTrailerSize); err != nil {
+		return errors.Wrap(err, "读取追踪文件出错")
+	}
+	if string(head) != traceFileMagic || string(trailer[8:]) != traceFileMagic {
+		return fmt.Errorf("不是追踪文件，或者文件没有正常关闭")
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer))
+	if indexOffset > size-traceFileTrailerSize {
+		return fmt.Errorf("追踪文件索引位置错误")
+	}
+	content := make([]byte, size-traceFileTrailerSize-indexOffset)
+	if _, err = r.f.ReadAt(content, indexOffset); err != nil {
+		return errors.Wrap(err, "读取追踪文件索引出错")
+	}
+	r.index = &traceFileIndex{}
+	return errors.Wrap(json.Unmarshal(content, r.index), "解析追踪文件索引出错")
+}
+
+// 文件中所有线程的线程号
+func (r *TraceFileReader) Threads() []int {
+	tids := make([]int, 0, len(r.index.Threads))
+	for tid := range r.index.Threads {
+		tids = append(tids, tid)
+	}
+	sort.Ints(tids)
+	return tids
+}
+
+// 线程的地址数量
+func (r *TraceFileReader) ThreadCount(tid int) uint64 {
+	cnt := uint64(0)
+	for _, chunk := range r.index.Threads[tid] {
+		cnt += uint64(chunk.Count)
+	}
+	return cnt
+}
+
+// 每个线程的地址数量与总数
+func (r *TraceFileReader) SampleCount() (threadCount map[int]uint64, total uint64) {
+	threadCount = map[int]uint64{}
+	for tid := range r.index.Threads {
+		threadCount[tid] = r.ThreadCount(tid)
+		total += threadCount[tid]
+	}
+	return
+}
+
+// 记录时保存的指令数量。记录时没有保存则返回每个线程的地址数量
+func (r *TraceFileReader) InstructionCount() (threadCount map[int]uint64, total uint64) {
+	if len(r.index.ThreadInstructionCount) != 0 {
+		return r.index.ThreadInstructionCount, r.index.TotalInstructions
+	}
+	return r.SampleCount()
+}
+
+func (r *TraceFileReader) readChunk(chunk traceChunk) (int, []uint64, []traceSpan, error) {
+	header := make([]byte, traceChunkHeaderSize)
+	if _, err := r.f.ReadAt(header, chunk.Offset); err != nil {
+		return 0, nil, nil, errors.Wrap(err, "读取数据块出错")
+	}
+	tid := int(binary.LittleEndian.Uint32(header))
+	count := int(binary.LittleEndian.Uint32(header[4:]))
+	size := int64(binary.LittleEndian.Uint32(header[8:]))
+	reader := bufio.NewReader(flate.NewReader(io.NewSectionReader(r.f, chunk.Offset+traceChunkHeaderSize, size)))
+	numSpans, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return 0, nil, nil, errors.Wrap(err, "解压数据块出错")
+	}
+	spans := make([]traceSpan, numSpans)
+	spanTotal := 0
+	for i := range spans {
+		seq, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return 0, nil, nil, errors.Wrap(err, "解压数据块出错")
+		}
+		cnt, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return 0, nil, nil, errors.Wrap(err, "解压数据块出错")
+		}
+		spans[i] = traceSpan{seq: seq, count: int(cnt)}
+		spanTotal += int(cnt)
+	}
+	if spanTotal != count {
+		return 0, nil, nil, fmt.Errorf("数据块片段的地址数量 %d 与地址数量 %d 不符", spanTotal, count)
+	}
+	addr := make([]uint64, count)
+	last := uint64(0)
+	for i := 0; i < count; i++ {
+		delta, err := binary.ReadVarint(reader)
+		if err != nil {
+			return 0, nil, nil, errors.Wrap(err, "解压数据块出错")
+		}
+		last += uint64(delta)
+		addr[i] = last
+	}
+	return tid, addr, spans, nil
+}
+
+// 读取一个线程的所有地址
+func (r *TraceFileReader) ReadThread(tid int) ([]uint64, error) {
+	var res []uint64
+	for _, chunk := range r.index.Threads[tid] {
+		_, addr, _, err := r.readChunk(chunk)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, addr...)
+	}
+	return res, nil
+}
+
+// 回放时一个线程读取到的位置
+type replayCursor struct {
+	tid    int
+	chunks []traceChunk
+	addr   []uint64
+	spans  []traceSpan
+}
+
+// 当前数据块读完时读取下一个数据块，线程没有更多地址时返回false
+func (c *replayCursor) next(r *TraceFileReader) (bool, error) {
+	for len(c.spans) == 0 {
+		if len(c.chunks) == 0 {
+			return false, nil
+		}
+		_, addr, spans, err := r.readChunk(c.chunks[0])
+		if err != nil {
+			return false, err
+		}
+		c.chunks = c.chunks[1:]
+		c.addr, c.spans = addr, spans
+	}
+	return true, nil
+}
+
+// 按Consume的顺序将地址交给consumer，每次交给consumer的地址与写入时一次Consume的相同，因此保持线程之间的交织顺序。
+// 每个线程同时只解压一个数据块
+func (r *TraceFileReader) Replay(ctx context.Context, consumer CacheLineAddressConsumer) error {
+	var cursors []*replayCursor
+	for _, tid := range r.Threads() {
+		cursor := &replayCursor{tid: tid, chunks: r.index.Threads[tid]}
+		ok, err := cursor.next(r)
+		if err != nil {
+			return err
+		}
+		if ok {
+			cursors = append(cursors, cursor)
+		}
+	}
+	for len(cursors) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// 线程数量不多，直接找写入序号最小的片段
+		min := 0
+		for i, cursor := range cursors {
+			if cursor.spans[0].seq < cursors[min].spans[0].seq {
+				min = i
+			}
+		}
+		cursor := cursors[min]
+		span := cursor.spans[0]
+		consumer.Consume(cursor.tid, cursor.addr[:span.count])
+		cursor.addr = cursor.addr[span.count:]
+		cursor.spans = cursor.spans[1:]
+		ok, err := cursor.next(r)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			cursors = append(cursors[:min], cursors[min+1:]...)
+		}
+	}
+	return nil
+}
+
+func (r *TraceFileReader) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,141 @@
+package memrecord
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTraceFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "tmp.tracefile.*")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "test.rmtrace")
+
+	w, err := CreateTraceFile(path)
+	assert.NoError(t, err)
+	// 两个线程交替写入，线程1的地址数量超过一个数据块
+	r := rand.New(rand.NewSource(1))
+	expect := map[int][]uint64{}
+	for round := 0; round < 20; round++ {
+		for _, tid := range []int{1, 2} {
+			n := 1000
+			if tid == 1 {
+				n = 5000
+			}
+			addr := make([]uint64, n)
+			for i := range addr {
+				addr[i] = uint64(tid)<<40 | (r.Uint64()&0xFFFFF)<<6
+			}
+			w.Consume(tid, addr)
+			expect[tid] = append(expect[tid], addr...)
+		}
+	}
+	res := &Result{ThreadInstructionCount: map[int]uint64{0: 300, 1: 200, 2: 100}, TotalInstructions: 300}
+	w.SetResult(res)
+	// 作为被包装的Consumer关闭
+	assert.NoError(t, CloseConsumer(NewMultiplexConsumer(w)))
+	assert.NoError(t, w.Close())
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Less(t, info.Size(), int64(8*(len(expect[1])+len(expect[2]))))
+
+	reader, err := OpenTraceFile(path)
+	assert.NoError(t, err)
+	defer func() {
+		_ = reader.Close()
+	}()
+	assert.Equal(t, []int{1, 2}, reader.Threads())
+	assert.Equal(t, uint64(len(expect[1])), reader.ThreadCount(1))
+	counts, total := reader.InstructionCount()
+	assert.Equal(t, res.ThreadInstructionCount, counts)
+	assert.Equal(t, res.TotalInstructions, total)
+	for tid, addr := range expect {
+		got, err := reader.ReadThread(tid)
+		assert.NoError(t, err)
+		assert.Equal(t, addr, got)
+	}
+
+	consumer := &collectConsumer{addr: map[int][]uint64{}}
+	assert.NoError(t, reader.Replay(context.Background(), consumer))
+	assert.Equal(t, expect, consumer.addr)
+
+	// 通过回放Recorder读取
+	recorder, err := NewReplayRecorder(path, TraceFormatAuto)
+	assert.NoError(t, err)
+	consumer = &collectConsumer{addr: map[int][]uint64{}}
+	ch, _ := recorder.RecordCommand(context.Background(), &RunRequest{
+		BaseRequest: BaseRequest{Name: "test", Consumer: consumer},
+	})
+	replayRes := <-ch
+	assert.NoError(t, replayRes.Err)
+	assert.Equal(t, res.ThreadInstructionCount, replayRes.ThreadInstructionCount)
+	assert.Equal(t, expect, consumer.addr)
+}
+
+func TestTraceFileNotClosed(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "tmp.tracefile.*")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "test.rmtrace")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(traceFileMagic+"0123456789abcdefgh"), 0644))
+	_, err = OpenTraceFile(path)
+	assert.Error(t, err)
+}
+
+// 按顺序记录每个地址所属的线程
+type orderConsumer struct {
+	tids []int
+	addr []uint64
+}
+
+func (c *orderConsumer) Consume(tid int, addr []uint64) {
+	for _, a := range addr {
+		c.tids = append(c.tids, tid)
+		c.addr = append(c.addr, a)
+	}
+}
+
+func TestTraceFileReplayInterleaved(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "tmp.tracefile.*")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "test.rmtrace")
+
+	w, err := CreateTraceFile(path)
+	assert.NoError(t, err)
+	// 三个线程以不同的批大小随机交替写入，有的批跨越数据块的边界
+	r := rand.New(rand.NewSource(2))
+	expect := &orderConsumer{}
+	for i := 0; i < 300; i++ {
+		tid := r.Intn(3) + 1
+		addr := make([]uint64, r.Intn(3000)+1)
+		for j := range addr {
+			addr[j] = uint64(tid)<<40 | (r.Uint64()&0xFFFFF)<<6
+		}
+		w.Consume(tid, addr)
+		expect.Consume(tid, addr)
+	}
+	assert.NoError(t, w.Close())
+
+	reader, err := OpenTraceFile(path)
+	assert.NoError(t, err)
+	defer func() {
+		_ = reader.Close()
+	}()
+	consumer := &orderConsumer{}
+	assert.NoError(t, reader.Replay(context.Background(), consumer))
+	assert.Equal(t, expect.tids, consumer.tids)
+	assert.Equal(t, expect.addr, consumer.addr)
+}
@@ -2,9 +2,12 @@ package memrecord
 
 import (
 	"context"
+	"fmt"
 	"github.com/packagewjx/resourcemanager/internal/algorithm"
 	"github.com/packagewjx/resourcemanager/internal/core"
 	"log"
+	"sync"
+	"time"
 )
 
 type MemRecorder interface {
@@ -16,7 +19,7 @@ type MemRecorder interface {
 type BaseRequest struct {
 	Name     string // 用于日志显示
 	Kill     bool
-	RootDir  string // 预留，用于容器使用
+	RootDir  string // 目标进程的根目录在本进程中的路径，为空时根据进程的挂载名称空间自动判断。只用于AttachRequest
 	Consumer CacheLineAddressConsumer
 }
 
@@ -28,15 +31,62 @@ type RunRequest struct {
 
 type AttachRequest struct {
 	BaseRequest
-	Pid int
+	Pid    int
+	Limits TraceLimits // 只有经过NewLimitedRecorder包装的MemRecorder会处理
 }
 
+// 追踪的限制，达到任意一个限制时停止追踪，结果标记为部分结果。为0的项不限制
+type TraceLimits struct {
+	MaxDuration  time.Duration
+	MaxSlowdown  float64 // 追踪期间进程IPC相对追踪前下降的最大倍数
+	MaxAddresses uint64
+}
+
+func (l TraceLimits) enabled() bool {
+	return l.MaxDuration > 0 || l.MaxSlowdown > 0 || l.MaxAddresses > 0
+}
+
+// 追踪提前结束的原因
+type StopReason string
+
+var (
+	StopReasonNone      StopReason = ""          // 进程结束或者达到记录器自身的采集数量
+	StopReasonDuration  StopReason = "duration"  // 达到最长追踪时间
+	StopReasonSlowdown  StopReason = "slowdown"  // 进程减速超过限制
+	StopReasonAddresses StopReason = "addresses" // 达到地址数量上限
+	StopReasonCanceled  StopReason = "canceled"  // 调用者取消
+)
+
+// 采集结果。指令数量用于加权平均各个线程的RTH，采样数量为交给Consumer的缓存行地址数量。
+// 无法获取真实指令数量时（如没有可用的硬件计数器），指令数量与采样数量相同
 type Result struct {
-	ThreadInstructionCount map[int]uint64
+	ThreadInstructionCount map[int]uint64 // 每个线程执行的用户态指令数量，Pin的结果中键为0的项为所有线程的总数
 	TotalInstructions      uint64
+	ThreadSampleCount      map[int]uint64 // 每个线程采集到的缓存行地址数量
+	TotalSamples           uint64
+	Partial                bool       // 追踪因为限制或者取消提前结束，结果只包含部分地址
+	StopReason             StopReason // Partial为true时提前结束的原因
 	Err                    error
 }
 
+// 累加一个线程的采样数量
+func (r *Result) addSamples(tid int, n uint64) {
+	if r.ThreadSampleCount == nil {
+		r.ThreadSampleCount = map[int]uint64{}
+	}
+	r.ThreadSampleCount[tid] += n
+	r.TotalSamples += n
+}
+
+// 没有获取到指令数量时，使用采样数量代替
+func (r *Result) fillInstructionsWithSamples() {
+	r.ThreadInstructionCount = make(map[int]uint64, len(r.ThreadSampleCount))
+	for tid, cnt := range r.ThreadSampleCount {
+		r.ThreadInstructionCount[tid] = cnt
+	}
+	r.TotalInstructions = r.TotalSamples
+}
+
 type RTHCalculatorFactory func(tid int) algorithm.RTHCalculator
 
 var (
@@ -69,31 +119,107 @@ type CacheLineAddressConsumer interface {
 type RTHCalculatorConsumer interface {
 	CacheLineAddressConsumer
 	GetCalculatorMap() map[int]algorithm.RTHCalculator
+	// 返回所有线程交织在一起的进程级RTHCalculator，没有启用时返回nil
+	GetProcessCalculator() algorithm.RTHCalculator
 }
 
+// 记录器可能在多个协程中调用Consume，因此cMap由lock保护，每个线程的Calculator由各自的锁保护，
+// 不同线程的更新可以并行进行。计算进程级RTH时所有更新都在processLock下进行，使线程级与进程级看到相同的到达顺序
 type rthCalculatorConsumer struct {
-	factory RTHCalculatorFactory
-	cMap    map[int]algorithm.RTHCalculator
+	factory     RTHCalculatorFactory
+	lock        sync.Mutex
+	cMap        map[int]algorithm.RTHCalculator
+	threadLocks map[int]*sync.Mutex
+	processLock sync.Mutex
+	process     algorithm.RTHCalculator
 }
 
 func (r *rthCalculatorConsumer) GetCalculatorMap() map[int]algorithm.RTHCalculator {
 	return r.cMap
 }
 
+func (r *rthCalculatorConsumer) GetProcessCalculator() algorithm.RTHCalculator {
+	return r.process
+}
+
 func NewRTHCalculatorConsumer(factory RTHCalculatorFactory) RTHCalculatorConsumer {
 	return &rthCalculatorConsumer{
-		factory: factory,
-		cMap:    make(map[int]algorithm.RTHCalculator),
+		factory:     factory,
+		cMap:        make(map[int]algorithm.RTHCalculator),
+		threadLocks: make(map[int]*sync.Mutex),
+	}
+}
+
+// 除了每个线程各自的RTH以外，还将所有线程的访问按到达顺序交织起来，计算一个进程整体的RTH。
+// 进程级的RTH能够反映线程之间共享数据的复用，以及线程之间对同一个缓存的竞争。
+// 交织的粒度是Consume传入的一批地址（最多数千条），批内不与其他线程交织，因此只是真实访问顺序的近似，
+// 批越大，跨线程复用距离的误差越大。
+func NewInterleavedRTHCalculatorConsumer(factory RTHCalculatorFactory) RTHCalculatorConsumer {
+	return &rthCalculatorConsumer{
+		factory:     factory,
+		cMap:        make(map[int]algorithm.RTHCalculator),
+		threadLocks: make(map[int]*sync.Mutex),
+		process:     factory(0),
+	}
+}
+
+// 根据配置的MRC合成方式创建Consumer
+func GetConsumerFromRootConfig() RTHCalculatorConsumer {
+	factory := GetCalculatorFromRootConfig()
+	if core.RootConfig.MemTrace.MRCComposition == core.MRCCompositionInterleaved {
+		return NewInterleavedRTHCalculatorConsumer(factory)
+	}
+	return NewRTHCalculatorConsumer(factory)
+}
+
+// 根据配置的Sampler创建MemRecorder
+func NewMemRecorderFromRootConfig() (MemRecorder, error) {
+	memTraceConfig := core.RootConfig.MemTrace
+	switch memTraceConfig.Sampler {
+	case core.MemTraceSamplerPerf:
+		return NewPerfRecorder(memTraceConfig.PerfRecordConfig.OverflowCount,
+			memTraceConfig.PerfRecordConfig.SwitchOutput,
+			memTraceConfig.PerfRecordConfig.PerfExecPath)
+	case core.MemTraceSamplerPerfEvent:
+		return NewPerfEventRecorder(memTraceConfig.PerfEventConfig.SamplePeriod,
+			memTraceConfig.PerfEventConfig.Duration,
+			memTraceConfig.TraceCount,
+			memTraceConfig.PerfEventConfig.BufferPages)
+	case core.MemTraceSamplerPin:
+		return NewPinMemRecorder(&Config{
+			BufferSize:     memTraceConfig.PinConfig.BufferSize,
+			WriteThreshold: memTraceConfig.PinConfig.WriteThreshold,
+			PinToolPath:    memTraceConfig.PinConfig.PinToolPath,
+			ConcurrentMax:  memTraceConfig.ConcurrentMax,
+			TraceCount:     memTraceConfig.TraceCount,
+		})
+	default:
+		return nil, fmt.Errorf("不支持的Sampler类型 %s", memTraceConfig.Sampler)
 	}
 }
 
 func (r *rthCalculatorConsumer) Consume(tid int, addr []uint64) {
+	r.lock.Lock()
 	c, ok := r.cMap[tid]
 	if !ok {
 		c = r.factory(tid)
 		r.cMap[tid] = c
+		r.threadLocks[tid] = &sync.Mutex{}
 	}
+	threadLock := r.threadLocks[tid]
+	r.lock.Unlock()
+
+	if r.process != nil {
+		// 在同一把锁下更新线程与进程的Calculator，进程级的顺序即为各批地址到达的顺序
+		r.processLock.Lock()
+		defer r.processLock.Unlock()
+	}
+	threadLock.Lock()
 	c.Update(addr)
+	threadLock.Unlock()
+	if r.process != nil {
+		r.process.Update(addr)
+	}
 }
 
 type DummyConsumer struct {
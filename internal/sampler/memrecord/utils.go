@@ -2,9 +2,12 @@ package memrecord
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
 )
 
@@ -33,3 +36,75 @@ func (l *logWriter) Write(p []byte) (n int, err error) {
 	l.logger.Printf("%s %s", l.prefix, string(p))
 	return len(p), nil
 }
+
+// 按线程攒批的地址缓冲，每个线程攒够batchSize条地址后交给Consumer
+type addrBatcher struct {
+	consumer  CacheLineAddressConsumer
+	batchSize int
+	batches   map[int][]uint64
+}
+
+func newAddrBatcher(consumer CacheLineAddressConsumer, batchSize int) *addrBatcher {
+	return &addrBatcher{
+		consumer:  consumer,
+		batchSize: batchSize,
+		batches:   map[int][]uint64{},
+	}
+}
+
+func (b *addrBatcher) add(tid int, addr uint64) {
+	batch := append(b.batches[tid], addr)
+	if len(batch) >= b.batchSize {
+		b.consumer.Consume(tid, batch)
+		// Consumer可能持有batch，不能复用
+		batch = make([]uint64, 0, b.batchSize)
+	}
+	b.batches[tid] = batch
+}
+
+// 将所有线程剩余的地址交给Consumer
+func (b *addrBatcher) flush() {
+	for tid, batch := range b.batches {
+		if len(batch) > 0 {
+			b.consumer.Consume(tid, batch)
+		}
+		delete(b.batches, tid)
+	}
+}
+
+// 查找父进程为ppid的第一个进程，没有找到时返回0
+func findChildPid(ppid int) int {
+	infos, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+	for _, info := range infos {
+		pid, err := strconv.Atoi(info.Name())
+		if err != nil {
+			continue
+		}
+		content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			continue
+		}
+		if parseStatPpid(string(content)) == ppid {
+			return pid
+		}
+	}
+	return 0
+}
+
+// 从/proc/<pid>/stat的内容中解析父进程号。进程名可能包含空格与括号，因此从最后一个右括号之后开始解析
+func parseStatPpid(stat string) int {
+	idx := strings.LastIndexByte(stat, ')')
+	if idx < 0 {
+		return 0
+	}
+	// 右括号之后依次为状态与父进程号
+	fields := strings.Fields(stat[idx+1:])
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
@@ -0,0 +1,105 @@
+package perf
+
+import (
+	"context"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/utils"
+	"github.com/packagewjx/resourcemanager/internal/utils/perfevent"
+	"github.com/pkg/errors"
+	"log"
+	"os"
+	"time"
+)
+
+// 创建对进程组的cgroup整体计数的StatRunner。cgroup中的所有进程，包括计数期间创建又退出的子进程都会被计入，
+// 每个CPU上只需要一组事件，与进程数量无关。组内每个进程的结果都是整个cgroup的计数，Pid为各自的进程号
+func NewCgroupStatRunner(group *core.ProcessGroup) StatRunner {
+	return newCgroupStatRunner(group)
+}
+
+func newCgroupStatRunner(group *core.ProcessGroup) *cgroupStatRunner {
+	return &cgroupStatRunner{
+		group:      group,
+		groups:     getEventGroups(),
+		sampleTime: core.RootConfig.PerfStat.SampleTime,
+		logger:     log.New(os.Stdout, fmt.Sprintf("cgroup-stat-%s: ", group.Id), log.Lshortfile|log.Lmsgprefix|log.LstdFlags),
+	}
+}
+
+type cgroupStatRunner struct {
+	group      *core.ProcessGroup
+	groups     [][]eventDef
+	sampleTime time.Duration
+	logger     *log.Logger
+}
+
+// 在每个在线CPU上打开并启用对cgroup的事件组
+func (c *cgroupStatRunner) open() (*processCounters, error) {
+	cpus, err := utils.OnlineCPUs()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.Open(c.group.CgroupPath)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("打开cgroup %s 出错", c.group.CgroupPath))
+	}
+	// 事件打开后内核持有cgroup的引用，目录可以关闭
+	defer func() {
+		_ = dir.Close()
+	}()
+
+	counters := &processCounters{events: flattenEventGroups(c.groups)}
+	for _, cpu := range cpus {
+		fds, err := openEventGroups(c.groups, int(dir.Fd()), cpu, perfevent.FlagPidCgroup, 0)
+		if err != nil {
+			counters.close()
+			return nil, errors.Wrap(err, fmt.Sprintf("在CPU %d 上监控cgroup %s 出错", cpu, c.group.CgroupPath))
+		}
+		counters.fds = append(counters.fds, fds)
+	}
+	for _, fds := range counters.fds {
+		if err := enableEventGroups(c.groups, fds); err != nil {
+			counters.close()
+			return nil, errors.Wrap(err, fmt.Sprintf("启用cgroup %s 的事件出错", c.group.CgroupPath))
+		}
+	}
+	return counters, nil
+}
+
+func (c *cgroupStatRunner) Start(ctx context.Context) <-chan map[int]*StatResult {
+	resultCh := make(chan map[int]*StatResult, 1)
+	counters, err := c.open()
+	if err != nil {
+		c.logger.Printf("无法监控进程组 %s：%v", c.group.Id, err)
+		resultCh <- c.distribute(&StatResult{Error: err})
+		close(resultCh)
+		return resultCh
+	}
+	c.logger.Printf("启动对cgroup %s 的监控，共 %d 个CPU", c.group.CgroupPath, len(counters.fds))
+
+	go func() {
+		select {
+		case <-time.After(c.sampleTime):
+			c.logger.Printf("对进程组 %s 的监控结束", c.group.Id)
+		case <-ctx.Done():
+			c.logger.Printf("对进程组 %s 的监控中途被结束", c.group.Id)
+		}
+		result := counters.read()
+		counters.close()
+		resultCh <- c.distribute(result)
+		close(resultCh)
+	}()
+	return resultCh
+}
+
+// 将整个cgroup的结果复制给组内的每个进程
+func (c *cgroupStatRunner) distribute(result *StatResult) map[int]*StatResult {
+	resultMap := make(map[int]*StatResult, len(c.group.Pid))
+	for _, pid := range c.group.Pid {
+		r := result.Clone().(*StatResult)
+		r.Pid = pid
+		resultMap[pid] = r
+	}
+	return resultMap
+}
@@ -0,0 +1,65 @@
+package perf
+
+import (
+	"context"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/utils"
+	"github.com/packagewjx/resourcemanager/internal/utils/perfevent"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestCgroupRunner(cgroupPath string) *cgroupStatRunner {
+	return &cgroupStatRunner{
+		group: &core.ProcessGroup{
+			Id:         "test",
+			Pid:        []int{os.Getpid(), 1},
+			CgroupPath: cgroupPath,
+		},
+		groups: [][]eventDef{{
+			{name: "task-clock", typ: perfevent.TypeSoftware, config: perfevent.CountSWTaskClock, setter: setCycles},
+		}},
+		sampleTime: 300 * time.Millisecond,
+		logger:     log.New(ioutil.Discard, "", 0),
+	}
+}
+
+func TestCgroupStatRunner(t *testing.T) {
+	dir, err := utils.PerfEventCgroupDir([]int{os.Getpid()})
+	if err != nil {
+		t.Skipf("没有可用的cgroup：%v", err)
+	}
+	runner := newTestCgroupRunner(dir)
+	counters, err := runner.open()
+	if err != nil {
+		t.Skipf("无法按cgroup计数：%v", err)
+	}
+	counters.close()
+
+	ch := runner.Start(context.Background())
+	done := time.Now().Add(100 * time.Millisecond)
+	for time.Now().Before(done) {
+	}
+	results := <-ch
+	assert.Len(t, results, 2)
+	for pid, result := range results {
+		assert.Equal(t, pid, result.Pid)
+		assert.NoError(t, result.Error)
+		assert.NotZero(t, result.Cycles)
+	}
+	assert.Equal(t, results[1].Cycles, results[os.Getpid()].Cycles)
+}
+
+func TestCgroupStatRunnerError(t *testing.T) {
+	runner := newTestCgroupRunner("/not/exist/cgroup")
+	results := <-runner.Start(context.Background())
+	assert.Len(t, results, 2)
+	for pid, result := range results {
+		assert.Equal(t, pid, result.Pid)
+		assert.Error(t, result.Error)
+	}
+}
@@ -0,0 +1,255 @@
+package perf
+
+import (
+	"context"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/utils/perfevent"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// 创建在本进程中使用perf_event_open计数的StatRunner。对进程组中的每个进程，在已有的每个线程上打开事件组，
+// 并继承到之后创建的线程中，计数按事件实际运行的时间比例放大
+func NewPerfEventStatRunner(group *core.ProcessGroup) StatRunner {
+	return newPerfEventStatRunner(group)
+}
+
+func newPerfEventStatRunner(group *core.ProcessGroup) *perfEventStatRunner {
+	return &perfEventStatRunner{
+		group:      group,
+		groups:     getEventGroups(),
+		sampleTime: core.RootConfig.PerfStat.SampleTime,
+		logger:     log.New(os.Stdout, fmt.Sprintf("perfevent-stat-%s: ", group.Id), log.Lshortfile|log.Lmsgprefix|log.LstdFlags),
+	}
+}
+
+// 根据配置的Scope与Backend创建StatRunner。按cgroup计数时总是使用perf_event_open
+func NewStatRunnerFromRootConfig(group *core.ProcessGroup) StatRunner {
+	if core.RootConfig.PerfStat.Scope == core.PerfStatScopeCgroup {
+		if group.CgroupPath != "" {
+			return NewCgroupStatRunner(group)
+		}
+		log.Printf("进程组 %s 没有cgroup，按进程计数", group.Id)
+	}
+	switch core.RootConfig.PerfStat.Backend {
+	case core.PerfStatBackendPerf:
+		return NewPerfStatRunner(group)
+	case core.PerfStatBackendPerfEvent:
+		return NewPerfEventStatRunner(group)
+	default:
+		log.Printf("未知的PerfStat后端 %s，使用%s", core.RootConfig.PerfStat.Backend, core.PerfStatBackendPerfEvent)
+		return NewPerfEventStatRunner(group)
+	}
+}
+
+type perfEventStatRunner struct {
+	group      *core.ProcessGroup
+	groups     [][]eventDef
+	sampleTime time.Duration
+	logger     *log.Logger
+}
+
+// 一个进程所有线程或者一个cgroup所有CPU上打开的事件，fds[i][j]为第i个线程或CPU上第j个事件
+type processCounters struct {
+	pid    int
+	events []eventDef
+	fds    [][]int
+	last   [][]perfevent.Count // 上次readDelta读取的计数
+}
+
+func (c *processCounters) close() {
+	for _, threadFds := range c.fds {
+		for _, fd := range threadFds {
+			_ = syscall.Close(fd)
+		}
+	}
+	c.fds = nil
+}
+
+// 打开所有事件组，组长在启用之前保持停用。pid、cpu与flags的含义与perf_event_open相同
+func openEventGroups(groups [][]eventDef, pid, cpu int, flags uintptr, bits uint64) ([]int, error) {
+	fds := make([]int, 0, len(groups)*4)
+	for _, group := range groups {
+		leader := -1
+		for i, event := range group {
+			attr := &perfevent.Attr{
+				Type:       event.typ,
+				Config:     event.config,
+				Config1:    event.config1,
+				ReadFormat: perfevent.FormatTotalTimeEnabled | perfevent.FormatTotalTimeRunning,
+				Bits:       bits,
+			}
+			if i == 0 {
+				attr.Bits |= perfevent.BitDisabled
+			}
+			fd, err := perfevent.Open(attr, pid, cpu, leader, flags)
+			if err != nil {
+				for _, fd := range fds {
+					_ = syscall.Close(fd)
+				}
+				return nil, errors.Wrap(err, fmt.Sprintf("打开事件 %s 出错", event.name))
+			}
+			if i == 0 {
+				leader = fd
+			}
+			fds = append(fds, fd)
+		}
+	}
+	return fds, nil
+}
+
+// 启用openEventGroups打开的所有事件组
+func enableEventGroups(groups [][]eventDef, fds []int) error {
+	offset := 0
+	for _, group := range groups {
+		if err := perfevent.Enable(fds[offset], true); err != nil {
+			return err
+		}
+		offset += len(group)
+	}
+	return nil
+}
+
+// 在线程上打开所有事件组，并继承到线程之后创建的线程中
+func (p *perfEventStatRunner) openThread(tid int) ([]int, error) {
+	return openEventGroups(p.groups, tid, -1, 0, perfevent.BitInherit)
+}
+
+// 在进程已有的所有线程上打开并启用事件。打开前已经退出的线程会被忽略
+func (p *perfEventStatRunner) openProcess(pid int) (*processCounters, error) {
+	infos, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, fmt.Errorf("进程 %d 已经退出", pid)
+	}
+	counters := &processCounters{pid: pid}
+	counters.events = flattenEventGroups(p.groups)
+	for _, info := range infos {
+		tid, err := strconv.Atoi(info.Name())
+		if err != nil {
+			continue
+		}
+		fds, err := p.openThread(tid)
+		if err != nil {
+			if _, statErr := os.Stat(fmt.Sprintf("/proc/%d/task/%d", pid, tid)); os.IsNotExist(statErr) {
+				continue
+			}
+			counters.close()
+			return nil, err
+		}
+		counters.fds = append(counters.fds, fds)
+	}
+	if len(counters.fds) == 0 {
+		return nil, fmt.Errorf("进程 %d 没有可以监控的线程", pid)
+	}
+	for _, threadFds := range counters.fds {
+		if err := enableEventGroups(p.groups, threadFds); err != nil {
+			counters.close()
+			return nil, errors.Wrap(err, fmt.Sprintf("启用进程 %d 的事件出错", pid))
+		}
+	}
+	return counters, nil
+}
+
+func flattenEventGroups(groups [][]eventDef) []eventDef {
+	var events []eventDef
+	for _, group := range groups {
+		events = append(events, group...)
+	}
+	return events
+}
+
+// 读取所有线程或CPU的计数，每个计数按运行时间比例放大后相加
+func (c *processCounters) read() *StatResult {
+	counts, err := c.readCounts()
+	if err != nil {
+		return &StatResult{Pid: c.pid, Error: err}
+	}
+	return c.aggregate(counts, nil)
+}
+
+// 读取上次调用以来的计数，第一次调用时为打开以来的计数
+func (c *processCounters) readDelta() *StatResult {
+	counts, err := c.readCounts()
+	if err != nil {
+		return &StatResult{Pid: c.pid, Error: err}
+	}
+	res := c.aggregate(counts, c.last)
+	c.last = counts
+	return res
+}
+
+func (c *processCounters) readCounts() ([][]perfevent.Count, error) {
+	counts := make([][]perfevent.Count, len(c.fds))
+	for t, threadFds := range c.fds {
+		counts[t] = make([]perfevent.Count, len(threadFds))
+		for i, fd := range threadFds {
+			count, err := perfevent.ReadCount(fd)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("读取进程 %d 的事件 %s 出错", c.pid, c.events[i].name))
+			}
+			counts[t][i] = count
+		}
+	}
+	return counts, nil
+}
+
+// 将counts与prev之差按运行时间比例放大后相加，prev为nil时直接使用counts
+func (c *processCounters) aggregate(counts, prev [][]perfevent.Count) *StatResult {
+	res := &StatResult{Pid: c.pid}
+	sums := make([]uint64, len(c.events))
+	for t, threadCounts := range counts {
+		for i, count := range threadCounts {
+			if prev != nil {
+				last := prev[t][i]
+				count = perfevent.Count{
+					Value:   count.Value - last.Value,
+					Enabled: count.Enabled - last.Enabled,
+					Running: count.Running - last.Running,
+				}
+			}
+			sums[i] += count.Scaled()
+		}
+	}
+	for i, event := range c.events {
+		event.setter(res, sums[i])
+	}
+	return res
+}
+
+func (p *perfEventStatRunner) Start(ctx context.Context) <-chan map[int]*StatResult {
+	resultCh := make(chan map[int]*StatResult, 1)
+	counters := make(map[int]*processCounters, len(p.group.Pid))
+	resultMap := make(map[int]*StatResult, len(p.group.Pid))
+	for _, pid := range p.group.Pid {
+		c, err := p.openProcess(pid)
+		if err != nil {
+			p.logger.Printf("无法监控进程 %d：%v", pid, err)
+			resultMap[pid] = &StatResult{Pid: pid, Error: err}
+			continue
+		}
+		p.logger.Printf("启动对进程 %d 的监控，共 %d 个线程", pid, len(c.fds))
+		counters[pid] = c
+	}
+
+	go func() {
+		select {
+		case <-time.After(p.sampleTime):
+			p.logger.Printf("对进程组 %s 的监控结束", p.group.Id)
+		case <-ctx.Done():
+			p.logger.Printf("对进程组 %s 的监控中途被结束", p.group.Id)
+		}
+		for pid, c := range counters {
+			resultMap[pid] = c.read()
+			c.close()
+		}
+		resultCh <- resultMap
+		close(resultCh)
+	}()
+	return resultCh
+}
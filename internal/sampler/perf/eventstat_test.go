@@ -0,0 +1,80 @@
+package perf
+
+import (
+	"context"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/utils/perfevent"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPerfEventStatRunner(t *testing.T) {
+	// 测试环境可能没有PMU，使用软件事件代替
+	swEvent := func(name string, config uint64, setter eventSetter) eventDef {
+		return eventDef{name: name, typ: perfevent.TypeSoftware, config: config, setter: setter}
+	}
+	runner := &perfEventStatRunner{
+		group: &core.ProcessGroup{
+			Id:  "test",
+			Pid: []int{os.Getpid(), 1 << 30},
+		},
+		groups: [][]eventDef{{
+			swEvent("task-clock", perfevent.CountSWTaskClock, setCycles),
+			swEvent("page-faults", perfevent.CountSWPageFaults, setAllLoads),
+		}},
+		sampleTime: 500 * time.Millisecond,
+		logger:     log.New(ioutil.Discard, "", 0),
+	}
+	fds, err := runner.openThread(os.Getpid())
+	if err != nil {
+		t.Skipf("无法打开软件事件：%v", err)
+	}
+	for _, fd := range fds {
+		_ = syscall.Close(fd)
+	}
+
+	ch := runner.Start(context.Background())
+	done := time.Now().Add(200 * time.Millisecond)
+	buf := make([][]byte, 0)
+	for time.Now().Before(done) {
+		buf = append(buf, make([]byte, 4096))
+	}
+	assert.NotEmpty(t, buf)
+	results := <-ch
+
+	assert.Len(t, results, 2)
+	self := results[os.Getpid()]
+	if assert.NotNil(t, self) {
+		assert.NoError(t, self.Error)
+		assert.Equal(t, os.Getpid(), self.Pid)
+		assert.NotZero(t, self.Cycles)
+	}
+	missing := results[1<<30]
+	if assert.NotNil(t, missing) {
+		assert.Error(t, missing.Error)
+		assert.Equal(t, 1<<30, missing.Pid)
+	}
+}
+
+func TestPerfEventStatRunnerCancel(t *testing.T) {
+	runner := &perfEventStatRunner{
+		group:      &core.ProcessGroup{Id: "test", Pid: []int{os.Getpid()}},
+		groups:     [][]eventDef{{{name: "task-clock", typ: perfevent.TypeSoftware, config: perfevent.CountSWTaskClock, setter: setCycles}}},
+		sampleTime: time.Hour,
+		logger:     log.New(ioutil.Discard, "", 0),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := runner.Start(ctx)
+	cancel()
+	select {
+	case results := <-ch:
+		assert.Contains(t, results, os.Getpid())
+	case <-time.After(5 * time.Second):
+		t.Fatal("取消后没有返回结果")
+	}
+}
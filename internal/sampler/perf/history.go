@@ -0,0 +1,89 @@
+package perf
+
+import (
+	"sync"
+	"time"
+)
+
+// 一个时间间隔内一个进程的计数
+type StatSample struct {
+	Start time.Time
+	End   time.Time
+	Stat  *StatResult
+}
+
+// 保存一个进程最近的间隔计数的环形缓冲区，超过容量时覆盖最旧的记录。可以被多个协程同时使用
+type StatHistory struct {
+	lock    sync.RWMutex
+	samples []StatSample
+	next    int // 下一个写入的位置
+	full    bool
+}
+
+func NewStatHistory(capacity int) *StatHistory {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &StatHistory{samples: make([]StatSample, capacity)}
+}
+
+func (h *StatHistory) Add(sample StatSample) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.samples[h.next] = sample
+	h.next++
+	if h.next == len(h.samples) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+func (h *StatHistory) Len() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if h.full {
+		return len(h.samples)
+	}
+	return h.next
+}
+
+// 按时间从旧到新返回所有记录
+func (h *StatHistory) Samples() []StatSample {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if !h.full {
+		res := make([]StatSample, h.next)
+		copy(res, h.samples[:h.next])
+		return res
+	}
+	res := make([]StatSample, 0, len(h.samples))
+	res = append(res, h.samples[h.next:]...)
+	return append(res, h.samples[:h.next]...)
+}
+
+// 返回最新的记录，没有记录时返回false
+func (h *StatHistory) Latest() (StatSample, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if !h.full && h.next == 0 {
+		return StatSample{}, false
+	}
+	last := h.next - 1
+	if last < 0 {
+		last = len(h.samples) - 1
+	}
+	return h.samples[last], true
+}
+
+// 按时间从旧到新计算每个记录的指标，如(*StatResult).InstructionPerCycle。跳过出错或者没有执行指令的记录
+func (h *StatHistory) Series(metric func(*StatResult) float64) []float64 {
+	samples := h.Samples()
+	res := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		if sample.Stat == nil || sample.Stat.Error != nil || sample.Stat.Instructions == 0 || sample.Stat.Cycles == 0 {
+			continue
+		}
+		res = append(res, metric(sample.Stat))
+	}
+	return res
+}
@@ -0,0 +1,43 @@
+package perf
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestStatHistory(t *testing.T) {
+	h := NewStatHistory(3)
+	_, ok := h.Latest()
+	assert.False(t, ok)
+	assert.Empty(t, h.Samples())
+
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		h.Add(StatSample{
+			Start: start.Add(time.Duration(i-1) * time.Second),
+			End:   start.Add(time.Duration(i) * time.Second),
+			Stat:  &StatResult{Instructions: uint64(i * 100), Cycles: 100},
+		})
+		assert.Equal(t, minInt(i, 3), h.Len())
+	}
+	samples := h.Samples()
+	assert.Len(t, samples, 3)
+	for i, sample := range samples {
+		assert.Equal(t, uint64((i+3)*100), sample.Stat.Instructions)
+	}
+	latest, ok := h.Latest()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(500), latest.Stat.Instructions)
+
+	h.Add(StatSample{Stat: &StatResult{Error: fmt.Errorf("进程已经退出")}})
+	assert.Equal(t, []float64{4, 5}, h.Series((*StatResult).InstructionPerCycle))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,130 @@
+package perf
+
+import (
+	"context"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"log"
+	"syscall"
+	"time"
+)
+
+// 一个时间间隔内进程组中各进程的计数
+type IntervalResult struct {
+	Start   time.Time
+	End     time.Time
+	Results map[int]*StatResult
+}
+
+// 按固定时间间隔输出计数的StatRunner，与perf stat -I相同
+type IntervalStatRunner interface {
+	// 每隔interval输出一次这段时间内的计数，直到ctx结束或者所有进程都退出后关闭通道。
+	// 无法监控或者已经退出的进程在下一个结果中带有Error，之后不再出现
+	StartInterval(ctx context.Context, interval time.Duration) <-chan *IntervalResult
+}
+
+// 根据配置的Scope创建IntervalStatRunner。perf可执行文件不支持流式输出，因此总是使用perf_event_open
+func NewIntervalStatRunnerFromRootConfig(group *core.ProcessGroup) IntervalStatRunner {
+	if core.RootConfig.PerfStat.Scope == core.PerfStatScopeCgroup {
+		if group.CgroupPath != "" {
+			return newCgroupStatRunner(group)
+		}
+		log.Printf("进程组 %s 没有cgroup，按进程计数", group.Id)
+	}
+	return newPerfEventStatRunner(group)
+}
+
+// 每隔interval调用一次read并输出结果，read返回false时在输出本次结果后结束。结束后调用cleanup
+func streamIntervals(ctx context.Context, interval time.Duration, read func() (map[int]*StatResult, bool),
+	cleanup func()) <-chan *IntervalResult {
+	ch := make(chan *IntervalResult, 1)
+	go func() {
+		defer func() {
+			cleanup()
+			close(ch)
+		}()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		start := time.Now()
+		for {
+			var end time.Time
+			select {
+			case <-ctx.Done():
+				return
+			case end = <-ticker.C:
+			}
+			results, more := read()
+			select {
+			case ch <- &IntervalResult{Start: start, End: end, Results: results}:
+			case <-ctx.Done():
+				return
+			}
+			if !more {
+				return
+			}
+			start = end
+		}
+	}()
+	return ch
+}
+
+func processExited(pid int) bool {
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
+
+var _ IntervalStatRunner = &perfEventStatRunner{}
+
+func (p *perfEventStatRunner) StartInterval(ctx context.Context, interval time.Duration) <-chan *IntervalResult {
+	counters := make(map[int]*processCounters, len(p.group.Pid))
+	pending := make(map[int]*StatResult)
+	for _, pid := range p.group.Pid {
+		c, err := p.openProcess(pid)
+		if err != nil {
+			p.logger.Printf("无法监控进程 %d：%v", pid, err)
+			pending[pid] = &StatResult{Pid: pid, Error: err}
+			continue
+		}
+		counters[pid] = c
+	}
+	p.logger.Printf("启动对进程组 %s 的间隔监控，间隔 %s", p.group.Id, interval)
+
+	return streamIntervals(ctx, interval, func() (map[int]*StatResult, bool) {
+		results := pending
+		pending = make(map[int]*StatResult)
+		for pid, c := range counters {
+			if processExited(pid) {
+				results[pid] = &StatResult{Pid: pid, Error: fmt.Errorf("进程 %d 已经退出", pid)}
+				c.close()
+				delete(counters, pid)
+				continue
+			}
+			results[pid] = c.readDelta()
+		}
+		return results, len(counters) != 0
+	}, func() {
+		for _, c := range counters {
+			c.close()
+		}
+		p.logger.Printf("对进程组 %s 的间隔监控结束", p.group.Id)
+	})
+}
+
+var _ IntervalStatRunner = &cgroupStatRunner{}
+
+func (c *cgroupStatRunner) StartInterval(ctx context.Context, interval time.Duration) <-chan *IntervalResult {
+	counters, err := c.open()
+	if err != nil {
+		c.logger.Printf("无法监控进程组 %s：%v", c.group.Id, err)
+		return streamIntervals(ctx, interval, func() (map[int]*StatResult, bool) {
+			return c.distribute(&StatResult{Error: err}), false
+		}, func() {})
+	}
+	c.logger.Printf("启动对cgroup %s 的间隔监控，间隔 %s", c.group.CgroupPath, interval)
+
+	return streamIntervals(ctx, interval, func() (map[int]*StatResult, bool) {
+		return c.distribute(counters.readDelta()), true
+	}, func() {
+		counters.close()
+		c.logger.Printf("对进程组 %s 的间隔监控结束", c.group.Id)
+	})
+}
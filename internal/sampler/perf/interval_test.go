@@ -0,0 +1,62 @@
+package perf
+
+import (
+	"context"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/utils/perfevent"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPerfEventStartInterval(t *testing.T) {
+	runner := &perfEventStatRunner{
+		group: &core.ProcessGroup{Id: "test", Pid: []int{os.Getpid(), 1 << 30}},
+		groups: [][]eventDef{{
+			{name: "task-clock", typ: perfevent.TypeSoftware, config: perfevent.CountSWTaskClock, setter: setCycles},
+		}},
+		logger: log.New(ioutil.Discard, "", 0),
+	}
+	counters, err := runner.openProcess(os.Getpid())
+	if err != nil {
+		t.Skipf("无法打开软件事件：%v", err)
+	}
+	counters.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := runner.StartInterval(ctx, 100*time.Millisecond)
+	var results []*IntervalResult
+	for res := range ch {
+		done := time.Now().Add(50 * time.Millisecond)
+		for time.Now().Before(done) {
+		}
+		results = append(results, res)
+		if len(results) == 3 {
+			cancel()
+			break
+		}
+	}
+	for range ch {
+	}
+	assert.Len(t, results, 3)
+
+	assert.Error(t, results[0].Results[1<<30].Error)
+	for i, res := range results {
+		assert.True(t, res.End.After(res.Start))
+		if i > 0 {
+			assert.Equal(t, results[i-1].End, res.Start)
+			assert.NotContains(t, res.Results, 1<<30)
+		}
+		self := res.Results[os.Getpid()]
+		if assert.NotNil(t, self) {
+			assert.NoError(t, self.Error)
+			assert.NotZero(t, self.Cycles)
+			// 每个间隔只包含这段时间内的计数
+			assert.Less(t, self.Cycles, uint64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package perf
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/packagewjx/resourcemanager/internal/utils/perfevent"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+按微处理器架构组织的事件表。每个架构的事件同时给出perf stat使用的事件名与perf_event_open使用的原始编码，
+以及事件计数写入StatResult的哪个字段。编码参考Intel与AMD公开的性能事件列表（perf的pmu-events）。
+*/
+
+// 一个计数事件
+type eventDef struct {
+	name    string // perf stat输出中的事件名
+	perf    string // 传给perf stat -e的事件，为空时与name相同
+	typ     uint32
+	config  uint64
+	config1 uint64
+	setter  eventSetter
+}
+
+func (e eventDef) perfEvent() string {
+	if e.perf != "" {
+		return e.perf
+	}
+	return e.name
+}
+
+// 原始事件的Config，格式与perf的cpu/event=,umask=,cmask=/相同
+func rawConfig(event, umask, cmask uint64) uint64 {
+	return event | umask<<8 | cmask<<24
+}
+
+func rawEvent(name string, event, umask, cmask uint64, setter eventSetter) eventDef {
+	return eventDef{
+		name:   name,
+		typ:    perfevent.TypeRaw,
+		config: rawConfig(event, umask, cmask),
+		setter: setter,
+	}
+}
+
+// 以perf的原始事件语法指定的事件，perf stat输出中的事件名为name
+func namedRawEvent(name string, event, umask uint64, setter eventSetter) eventDef {
+	e := rawEvent(name, event, umask, 0, setter)
+	e.perf = fmt.Sprintf("cpu/event=0x%x,umask=0x%x,name=%s/", event, umask, name)
+	return e
+}
+
+// Intel的offcore_response事件，响应类型写在config1中，对应MSR_OFFCORE_RSP_0
+func offcoreEvent(name, perf string, rsp uint64, setter eventSetter) eventDef {
+	return eventDef{
+		name:    name,
+		perf:    perf,
+		typ:     perfevent.TypeRaw,
+		config:  rawConfig(0xb7, 0x01, 0),
+		config1: rsp,
+		setter:  setter,
+	}
+}
+
+// 事件写入StatResult的字段
+var (
+	setAllLoads eventSetter = func(r *StatResult, count uint64) {
+		r.AllLoads = count
+	}
+	setAllStores eventSetter = func(r *StatResult, count uint64) {
+		r.AllStores = count
+	}
+	setInstructions eventSetter = func(r *StatResult, count uint64) {
+		r.Instructions = count
+	}
+	setCycles eventSetter = func(r *StatResult, count uint64) {
+		r.Cycles = count
+	}
+	setMemAnyCycles eventSetter = func(r *StatResult, count uint64) {
+		r.MemAnyCycles = count
+	}
+	setLLCMissCycles eventSetter = func(r *StatResult, count uint64) {
+		r.LLCMissCycles = count
+	}
+	setLLCHit eventSetter = func(r *StatResult, count uint64) {
+		r.LLCHit = count
+	}
+	setLLCMiss eventSetter = func(r *StatResult, count uint64) {
+		r.LLCMiss = count
+	}
+)
+
+// Intel各代通用的事件
+var (
+	intelAllLoads      = rawEvent("mem_inst_retired.all_loads", 0xd0, 0x81, 0, setAllLoads)
+	intelAllStores     = rawEvent("mem_inst_retired.all_stores", 0xd0, 0x82, 0, setAllStores)
+	intelCycles        = rawEvent("cpu_clk_unhalted.thread", 0x3c, 0x00, 0, setCycles)
+	intelInstructions  = rawEvent("inst_retired.any", 0xc0, 0x00, 0, setInstructions)
+	intelL3MissCycles  = rawEvent("cycle_activity.cycles_l3_miss", 0xa3, 0x02, 0x02, setLLCMissCycles)
+	intelMemAnyCycles  = rawEvent("cycle_activity.cycles_mem_any", 0xa3, 0x10, 0x10, setMemAnyCycles)
+	intelL3HitRetired  = rawEvent("mem_load_retired.l3_hit", 0xd1, 0x04, 0, setLLCHit)
+	intelL3MissRetired = rawEvent("mem_load_retired.l3_miss", 0xd1, 0x20, 0, setLLCMiss)
+)
+
+// 一种微处理器架构的事件。同一组的事件同时调度到PMU上，组内的比值不受多路复用的影响。
+// 开启超线程时Intel每个逻辑核只有4个通用计数器，instructions与cycles使用固定计数器，因此每组最多4个其他事件
+type eventSet struct {
+	arch   core.MicroArchitectureName
+	vendor string
+	family int
+	models map[int][]int // 型号到步进的映射，步进为空时匹配所有步进，models为空时匹配所有型号
+	groups [][]eventDef
+}
+
+func (s *eventSet) match(cpu cpuID) bool {
+	if s.vendor != cpu.vendor || s.family != cpu.family {
+		return false
+	}
+	if s.models == nil {
+		return true
+	}
+	steppings, ok := s.models[cpu.model]
+	if !ok {
+		return false
+	}
+	if len(steppings) == 0 {
+		return true
+	}
+	for _, stepping := range steppings {
+		if stepping == cpu.stepping {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *eventSet) events() []eventDef {
+	return flattenEventGroups(s.groups)
+}
+
+// 传给perf stat -e的事件列表
+func (s *eventSet) perfEventList() string {
+	events := s.events()
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = event.perfEvent()
+	}
+	return strings.Join(names, ",")
+}
+
+const (
+	vendorIntel = "GenuineIntel"
+	vendorAMD   = "AuthenticAMD"
+)
+
+var eventSets = []*eventSet{
+	{
+		// Skylake-SP的步进为0到4，以及与其PMU相同的客户端Skylake与Kaby Lake
+		arch:   core.MicroArchitectureNameSkyLake,
+		vendor: vendorIntel,
+		family: 6,
+		models: map[int][]int{0x55: {0, 1, 2, 3, 4}, 0x4e: nil, 0x5e: nil, 0x8e: nil, 0x9e: nil},
+		groups: [][]eventDef{
+			{intelCycles, intelInstructions, intelAllLoads, intelAllStores},
+			{
+				offcoreEvent("L3Hit", "cpu/event=0xb7,umask=0x01,offcore_rsp=0x801C0003,name=L3Hit/", 0x801C0003, setLLCHit),
+				offcoreEvent("L3Miss", "cpu/event=0xb7,umask=0x01,offcore_rsp=0x84000003,name=L3Miss/", 0x84000003, setLLCMiss),
+				intelMemAnyCycles,
+				intelL3MissCycles,
+			},
+		},
+	},
+	{
+		// Cascade Lake与Cooper Lake与Skylake-SP的型号相同，以步进区分
+		arch:   core.MicroArchitectureNameCascadeLake,
+		vendor: vendorIntel,
+		family: 6,
+		models: map[int][]int{0x55: {5, 6, 7, 10, 11}},
+		groups: [][]eventDef{
+			{intelCycles, intelInstructions, intelAllLoads, intelAllStores},
+			{
+				offcoreEvent("offcore_response.all_data_rd.l3_hit.any_snoop", "", 0x3FC01C0491, setLLCHit),
+				offcoreEvent("offcore_response.all_data_rd.l3_miss.any_snoop", "", 0x3FBC000491, setLLCMiss),
+				intelMemAnyCycles,
+				intelL3MissCycles,
+			},
+		},
+	},
+	{
+		// Ice Lake没有cycle_activity.cycles_l3_miss，使用有L3缺失的需求读请求未完成的周期数代替
+		arch:   core.MicroArchitectureNameIceLakeSP,
+		vendor: vendorIntel,
+		family: 6,
+		models: map[int][]int{0x6a: nil, 0x6c: nil},
+		groups: [][]eventDef{
+			{intelCycles, intelInstructions, intelAllLoads, intelAllStores},
+			{
+				intelL3HitRetired,
+				intelL3MissRetired,
+				intelMemAnyCycles,
+				rawEvent("offcore_requests_outstanding.cycles_with_l3_miss_demand_data_rd", 0x60, 0x10, 0x01, setLLCMissCycles),
+			},
+		},
+	},
+	{
+		// Sapphire Rapids与Emerald Rapids，offcore_requests_outstanding的事件号变为0x20
+		arch:   core.MicroArchitectureNameSapphireRapids,
+		vendor: vendorIntel,
+		family: 6,
+		models: map[int][]int{0x8f: nil, 0xcf: nil},
+		groups: [][]eventDef{
+			{intelCycles, intelInstructions, intelAllLoads, intelAllStores},
+			{
+				intelL3HitRetired,
+				intelL3MissRetired,
+				intelMemAnyCycles,
+				rawEvent("offcore_requests_outstanding.cycles_with_l3_miss_demand_data_rd", 0x20, 0x10, 0x01, setLLCMissCycles),
+			},
+		},
+	},
+	{
+		// Zen与Zen2。L3的PMU只能按CPU计数，因此使用核心PMU上按数据来源统计的需求填充：
+		// 来自同一CCX内的缓存视为L3命中，来自其他CCX、本地与远端内存视为L3缺失。没有与访存周期对应的事件。
+		// Zen3（family 0x19）之后事件0x43的umask含义改变，没有对应的事件表，使用通用事件
+		arch:   core.MicroArchitectureNameZen,
+		vendor: vendorAMD,
+		family: 0x17,
+		groups: [][]eventDef{
+			{
+				rawEvent("ls_not_halted_cyc", 0x76, 0x00, 0, setCycles),
+				rawEvent("ex_ret_instr", 0xc0, 0x00, 0, setInstructions),
+				rawEvent("ls_dispatch.ld_dispatch", 0x29, 0x01, 0, setAllLoads),
+				rawEvent("ls_dispatch.store_dispatch", 0x29, 0x02, 0, setAllStores),
+				namedRawEvent("L3Hit", 0x43, 0x02, setLLCHit),
+				namedRawEvent("L3Miss", 0x43, 0x5c, setLLCMiss),
+			},
+		},
+	},
+}
+
+// 无法识别架构时使用的事件，只使用各代Intel都有的事件名
+var commonEventSet = &eventSet{
+	arch: "common",
+	groups: [][]eventDef{
+		{intelCycles, intelInstructions, intelAllLoads, intelAllStores},
+		{intelL3HitRetired, intelL3MissRetired, intelMemAnyCycles, intelL3MissCycles},
+	},
+}
+
+// perf stat输出中的事件名到StatResult字段的映射，包含所有架构的事件
+var eventSetterMap = buildEventSetterMap()
+
+func buildEventSetterMap() map[string]eventSetter {
+	m := map[string]eventSetter{}
+	for _, set := range append(eventSets, commonEventSet) {
+		for _, event := range set.events() {
+			m[event.name] = event.setter
+		}
+	}
+	return m
+}
+
+// 从/proc/cpuinfo中读取的处理器标识
+type cpuID struct {
+	vendor   string
+	family   int
+	model    int
+	stepping int
+}
+
+func (c cpuID) String() string {
+	return fmt.Sprintf("%s family 0x%x model 0x%x stepping %d", c.vendor, c.family, c.model, c.stepping)
+}
+
+// 解析/proc/cpuinfo中第一个处理器的标识
+func parseCPUInfo(r io.Reader) (cpuID, error) {
+	var id cpuID
+	found := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() && found < 4 {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])
+		var err error
+		switch key {
+		case "vendor_id":
+			id.vendor = value
+		case "cpu family":
+			id.family, err = strconv.Atoi(value)
+		case "model":
+			id.model, err = strconv.Atoi(value)
+		case "stepping":
+			id.stepping, err = strconv.Atoi(value)
+		default:
+			continue
+		}
+		if err != nil {
+			return id, fmt.Errorf("解析cpuinfo中的%s出错：%s", key, value)
+		}
+		found++
+	}
+	if found < 4 {
+		return id, fmt.Errorf("cpuinfo中没有完整的处理器标识")
+	}
+	return id, nil
+}
+
+func lookupEventSetByCPU(cpu cpuID) (*eventSet, bool) {
+	for _, set := range eventSets {
+		if set.match(cpu) {
+			return set, true
+		}
+	}
+	return nil, false
+}
+
+func lookupEventSetByArch(arch core.MicroArchitectureName) (*eventSet, bool) {
+	for _, set := range eventSets {
+		if strings.EqualFold(string(set.arch), string(arch)) {
+			return set, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	detectOnce     sync.Once
+	detectedEvents *eventSet
+)
+
+// 读取本机的处理器标识并选择事件，结果会被缓存
+func detectEventSet() *eventSet {
+	detectOnce.Do(func() {
+		detectedEvents = commonEventSet
+		f, err := os.Open("/proc/cpuinfo")
+		if err != nil {
+			log.Printf("读取cpuinfo出错，使用通用事件：%v", err)
+			return
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		cpu, err := parseCPUInfo(f)
+		if err != nil {
+			log.Printf("%v，使用通用事件", err)
+			return
+		}
+		if set, ok := lookupEventSetByCPU(cpu); ok {
+			log.Printf("检测到处理器 %s，使用%s的事件", cpu, set.arch)
+			detectedEvents = set
+		} else {
+			log.Printf("未知的处理器 %s，使用通用事件", cpu)
+		}
+	})
+	return detectedEvents
+}
+
+// 按配置的微处理器架构选择事件，为auto时根据/proc/cpuinfo检测
+func getEventSet() *eventSet {
+	arch := core.RootConfig.PerfStat.MicroArchitecture
+	if arch == core.MicroArchitectureNameAuto || arch == "" {
+		return detectEventSet()
+	}
+	if set, ok := lookupEventSetByArch(arch); ok {
+		return set
+	}
+	log.Printf("未知微处理器架构名 %s，使用通用事件", arch)
+	return commonEventSet
+}
+
+func getEventList() string {
+	return getEventSet().perfEventList()
+}
+
+func getEventGroups() [][]eventDef {
+	return getEventSet().groups
+}
@@ -0,0 +1,116 @@
+package perf
+
+import (
+	"github.com/packagewjx/resourcemanager/internal/core"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestRawConfig(t *testing.T) {
+	assert.Equal(t, uint64(0x81d0), intelAllLoads.config)
+	assert.Equal(t, uint64(0x003c), intelCycles.config)
+	assert.Equal(t, uint64(0x020002a3), intelL3MissCycles.config)
+	assert.Equal(t, uint64(0x100010a3), intelMemAnyCycles.config)
+	assert.Equal(t, "cpu/event=0x43,umask=0x2,name=L3Hit/", namedRawEvent("L3Hit", 0x43, 0x02, setLLCHit).perf)
+}
+
+func TestParseCPUInfo(t *testing.T) {
+	content := `processor	: 0
+vendor_id	: GenuineIntel
+cpu family	: 6
+model		: 85
+model name	: Intel(R) Xeon(R) Gold 6248 CPU @ 2.50GHz
+stepping	: 7
+microcode	: 0x5003006
+
+processor	: 1
+vendor_id	: GenuineIntel
+`
+	cpu, err := parseCPUInfo(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, cpuID{vendor: vendorIntel, family: 6, model: 0x55, stepping: 7}, cpu)
+
+	_, err = parseCPUInfo(strings.NewReader("processor	: 0\nvendor_id	: GenuineIntel\n"))
+	assert.Error(t, err)
+	_, err = parseCPUInfo(strings.NewReader("vendor_id	: GenuineIntel\ncpu family	: x\nmodel	: 1\nstepping	: 1\n"))
+	assert.Error(t, err)
+}
+
+func TestLookupEventSetByCPU(t *testing.T) {
+	tests := []struct {
+		cpu  cpuID
+		arch core.MicroArchitectureName
+	}{
+		{cpuID{vendorIntel, 6, 0x55, 4}, core.MicroArchitectureNameSkyLake},
+		{cpuID{vendorIntel, 6, 0x5e, 3}, core.MicroArchitectureNameSkyLake},
+		{cpuID{vendorIntel, 6, 0x55, 7}, core.MicroArchitectureNameCascadeLake},
+		{cpuID{vendorIntel, 6, 0x55, 11}, core.MicroArchitectureNameCascadeLake},
+		{cpuID{vendorIntel, 6, 0x6a, 6}, core.MicroArchitectureNameIceLakeSP},
+		{cpuID{vendorIntel, 6, 0x8f, 8}, core.MicroArchitectureNameSapphireRapids},
+		{cpuID{vendorIntel, 6, 0xcf, 2}, core.MicroArchitectureNameSapphireRapids},
+		{cpuID{vendorAMD, 0x17, 0x31, 0}, core.MicroArchitectureNameZen},
+		// Zen3之后的事件含义不同，使用通用事件
+		{cpuID{vendorAMD, 0x19, 0x11, 1}, ""},
+		{cpuID{vendorIntel, 6, 0x3f, 2}, ""},
+		{cpuID{vendorAMD, 0x15, 0x02, 0}, ""},
+	}
+	for _, test := range tests {
+		set, ok := lookupEventSetByCPU(test.cpu)
+		if test.arch == "" {
+			assert.False(t, ok, test.cpu.String())
+			continue
+		}
+		if assert.True(t, ok, test.cpu.String()) {
+			assert.Equal(t, test.arch, set.arch, test.cpu.String())
+		}
+	}
+}
+
+// 每个架构的事件都要写入不同的字段，并且能通过perf stat输出中的事件名找到
+func TestEventSets(t *testing.T) {
+	for _, set := range append(eventSets, commonEventSet) {
+		set := set
+		t.Run(string(set.arch)+"-"+set.vendor, func(t *testing.T) {
+			res := &StatResult{}
+			events := set.events()
+			for i, event := range events {
+				assert.NotZero(t, event.config|event.config1, event.name)
+				assert.NotEmpty(t, event.perfEvent())
+				assert.NoError(t, res.SetCount(event.name, uint64(i+1)))
+			}
+			fields := []uint64{res.AllLoads, res.AllStores, res.Instructions, res.Cycles, res.LLCHit, res.LLCMiss}
+			if set.vendor != vendorAMD {
+				fields = append(fields, res.MemAnyCycles, res.LLCMissCycles)
+			}
+			seen := map[uint64]bool{}
+			for _, field := range fields {
+				assert.NotZero(t, field)
+				assert.False(t, seen[field], "两个事件写入了同一个字段")
+				seen[field] = true
+			}
+			assert.Len(t, seen, len(events))
+			for _, group := range set.groups {
+				assert.LessOrEqual(t, len(group), 6)
+			}
+		})
+	}
+}
+
+func TestGetEventSetByConfig(t *testing.T) {
+	old := core.RootConfig.PerfStat.MicroArchitecture
+	defer func() {
+		core.RootConfig.PerfStat.MicroArchitecture = old
+	}()
+
+	core.RootConfig.PerfStat.MicroArchitecture = core.MicroArchitectureNameSkyLake
+	assert.Contains(t, getEventList(), "offcore_rsp=0x801C0003,name=L3Hit")
+	core.RootConfig.PerfStat.MicroArchitecture = core.MicroArchitectureNameCascadeLake
+	assert.Contains(t, getEventList(), "offcore_response.all_data_rd.l3_hit.any_snoop")
+	core.RootConfig.PerfStat.MicroArchitecture = "skylake"
+	assert.Equal(t, core.MicroArchitectureNameSkyLake, getEventSet().arch)
+	core.RootConfig.PerfStat.MicroArchitecture = "Unknown"
+	assert.Equal(t, commonEventSet, getEventSet())
+	core.RootConfig.PerfStat.MicroArchitecture = core.MicroArchitectureNameAuto
+	assert.NotNil(t, getEventSet())
+}
@@ -0,0 +1,27 @@
+package utils
+
+const (
+	CacheLineMask = 0xFFFFFFFFFFFFFFC0
+	CacheLineSize = 0x40
+
+	pinAddrMask   = 0xFFFFFFFFFFFF
+	pinLengthBits = 48
+)
+
+// 将从addr开始、长度为size字节的访问展开为其覆盖的所有缓存行地址，追加到list后返回。size为0时视为1
+func AppendCacheLines(list []uint64, addr, size uint64) []uint64 {
+	if size == 0 {
+		size = 1
+	}
+	line := addr & CacheLineMask
+	endLine := (addr + size - 1) & CacheLineMask
+	for ; line <= endLine; line += CacheLineSize {
+		list = append(list, line)
+	}
+	return list
+}
+
+// 解析Pin二进制格式的访存条目：低48位为地址，高16位为访问长度
+func DecodePinEntry(entry uint64) (addr, size uint64) {
+	return entry & pinAddrMask, entry >> pinLengthBits
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAppendCacheLines(t *testing.T) {
+	assert.Equal(t, []uint64{0x1000}, AppendCacheLines(nil, 0x1008, 8))
+	assert.Equal(t, []uint64{0x1000}, AppendCacheLines(nil, 0x1000, 0))
+	// 跨越缓存行边界
+	assert.Equal(t, []uint64{0x1000, 0x1040}, AppendCacheLines(nil, 0x103c, 8))
+	assert.Equal(t, []uint64{0x5, 0x1000, 0x1040, 0x1080}, AppendCacheLines([]uint64{0x5}, 0x1000, 0xc0))
+
+	addr, size := DecodePinEntry(0x0008_7fff_1234_5678)
+	assert.Equal(t, uint64(0x7fff12345678), addr)
+	assert.Equal(t, uint64(8), size)
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 返回进程在controller所在层级中的cgroup路径，controller为空时返回cgroup v2统一层级中的路径
+func ProcessCgroupPath(pid int, controller string) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("读取进程 %d 的cgroup出错", pid))
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	path, ok := parseCgroupFile(f, controller)
+	if !ok {
+		return "", fmt.Errorf("进程 %d 不在%s层级中", pid, controllerName(controller))
+	}
+	return path, nil
+}
+
+// 解析/proc/<pid>/cgroup，每行格式为 层级号:控制器列表:路径
+func parseCgroupFile(r io.Reader, controller string) (string, bool) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if controller == "" {
+			if fields[0] == "0" && fields[1] == "" {
+				return fields[2], true
+			}
+			continue
+		}
+		for _, c := range strings.Split(fields[1], ",") {
+			if c == controller {
+				return fields[2], true
+			}
+		}
+	}
+	return "", false
+}
+
+// 返回controller所在cgroup层级的挂载点，controller为空时返回cgroup v2统一层级的挂载点
+func CgroupMountPoint(controller string) (string, error) {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return "", errors.Wrap(err, "读取挂载点出错")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	mount, ok := parseCgroupMounts(f, controller)
+	if !ok {
+		return "", fmt.Errorf("没有挂载%s层级", controllerName(controller))
+	}
+	return mount, nil
+}
+
+// 解析/proc/mounts，每行格式为 设备 挂载点 文件系统类型 选项 ...
+func parseCgroupMounts(r io.Reader, controller string) (string, bool) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if controller == "" {
+			if fields[2] == "cgroup2" {
+				return fields[1], true
+			}
+			continue
+		}
+		if fields[2] != "cgroup" {
+			continue
+		}
+		for _, option := range strings.Split(fields[3], ",") {
+			if option == controller {
+				return fields[1], true
+			}
+		}
+	}
+	return "", false
+}
+
+func controllerName(controller string) string {
+	if controller == "" {
+		return "cgroup v2"
+	}
+	return controller
+}
+
+// 返回包含所有进程的最深的cgroup路径
+func commonCgroupPath(paths []string) string {
+	if len(paths) == 0 {
+		return "/"
+	}
+	common := strings.Split(filepath.Clean(paths[0]), "/")
+	for _, path := range paths[1:] {
+		parts := strings.Split(filepath.Clean(path), "/")
+		i := 0
+		for ; i < len(common) && i < len(parts) && common[i] == parts[i]; i++ {
+		}
+		common = common[:i]
+	}
+	res := strings.Join(common, "/")
+	if res == "" {
+		return "/"
+	}
+	return res
+}
+
+// 返回包含所有进程的perf_event cgroup目录，用于按cgroup计数。优先使用cgroup v1的perf_event层级，
+// 没有挂载时使用cgroup v2统一层级。对Pod中的多个容器，返回的是Pod的cgroup
+func PerfEventCgroupDir(pids []int) (string, error) {
+	if len(pids) == 0 {
+		return "", fmt.Errorf("没有进程")
+	}
+	controller := "perf_event"
+	mount, err := CgroupMountPoint(controller)
+	if err != nil {
+		controller = ""
+		if mount, err = CgroupMountPoint(controller); err != nil {
+			return "", errors.Wrap(err, "没有可以用于perf_event的cgroup层级")
+		}
+	}
+	paths := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		path, err := ProcessCgroupPath(pid, controller)
+		if err != nil {
+			return "", err
+		}
+		paths = append(paths, path)
+	}
+	return filepath.Join(mount, commonCgroupPath(paths)), nil
+}
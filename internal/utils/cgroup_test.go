@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseCgroupFile(t *testing.T) {
+	content := `11:perf_event:/kubepods/burstable/pod1/c1
+4:cpu,cpuacct:/kubepods/burstable/pod1/c1
+0::/system.slice/docker.service
+`
+	path, ok := parseCgroupFile(strings.NewReader(content), "perf_event")
+	assert.True(t, ok)
+	assert.Equal(t, "/kubepods/burstable/pod1/c1", path)
+	path, ok = parseCgroupFile(strings.NewReader(content), "cpuacct")
+	assert.True(t, ok)
+	assert.Equal(t, "/kubepods/burstable/pod1/c1", path)
+	path, ok = parseCgroupFile(strings.NewReader(content), "")
+	assert.True(t, ok)
+	assert.Equal(t, "/system.slice/docker.service", path)
+	_, ok = parseCgroupFile(strings.NewReader(content), "memory")
+	assert.False(t, ok)
+}
+
+func TestParseCgroupMounts(t *testing.T) {
+	content := `tmpfs /sys/fs/cgroup tmpfs rw,relatime,mode=755 0 0
+cgroup /sys/fs/cgroup/cpu,cpuacct cgroup rw,relatime,cpu,cpuacct 0 0
+cgroup /sys/fs/cgroup/perf_event cgroup rw,relatime,perf_event 0 0
+cgroup2 /sys/fs/cgroup/unified cgroup2 rw,relatime 0 0
+`
+	mount, ok := parseCgroupMounts(strings.NewReader(content), "perf_event")
+	assert.True(t, ok)
+	assert.Equal(t, "/sys/fs/cgroup/perf_event", mount)
+	mount, ok = parseCgroupMounts(strings.NewReader(content), "cpu")
+	assert.True(t, ok)
+	assert.Equal(t, "/sys/fs/cgroup/cpu,cpuacct", mount)
+	mount, ok = parseCgroupMounts(strings.NewReader(content), "")
+	assert.True(t, ok)
+	assert.Equal(t, "/sys/fs/cgroup/unified", mount)
+	_, ok = parseCgroupMounts(strings.NewReader(content), "memory")
+	assert.False(t, ok)
+}
+
+func TestCommonCgroupPath(t *testing.T) {
+	assert.Equal(t, "/kubepods/pod1", commonCgroupPath([]string{"/kubepods/pod1/c1", "/kubepods/pod1/c2"}))
+	assert.Equal(t, "/kubepods/pod1/c1", commonCgroupPath([]string{"/kubepods/pod1/c1"}))
+	assert.Equal(t, "/kubepods", commonCgroupPath([]string{"/kubepods/pod1/c1", "/kubepods/pod10/c1"}))
+	assert.Equal(t, "/", commonCgroupPath([]string{"/a/b", "/c"}))
+	assert.Equal(t, "/", commonCgroupPath(nil))
+}
+
+func TestProcessCgroupPath(t *testing.T) {
+	path, err := ProcessCgroupPath(os.Getpid(), "")
+	if err != nil {
+		t.Skipf("没有cgroup v2层级：%v", err)
+	}
+	assert.True(t, strings.HasPrefix(path, "/"))
+}
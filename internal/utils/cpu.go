@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// 返回所有在线CPU的编号
+func OnlineCPUs() ([]int, error) {
+	content, err := ioutil.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return nil, errors.Wrap(err, "读取在线CPU出错")
+	}
+	return ParseCPUList(strings.TrimSpace(string(content)))
+}
+
+// 解析形如0-3,5,7-8的CPU列表
+func ParseCPUList(list string) ([]int, error) {
+	var res []int
+	if list == "" {
+		return res, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("CPU列表 %s 格式错误", list)
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(bounds[1]); err != nil || end < start {
+				return nil, fmt.Errorf("CPU列表 %s 格式错误", list)
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			res = append(res, cpu)
+		}
+	}
+	return res, nil
+}
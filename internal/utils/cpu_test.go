@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := ParseCPUList("0-3,5,7-8")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 5, 7, 8}, cpus)
+	cpus, err = ParseCPUList("0")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, cpus)
+	_, err = ParseCPUList("3-1")
+	assert.Error(t, err)
+	_, err = ParseCPUList("a")
+	assert.Error(t, err)
+
+	online, err := OnlineCPUs()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, online)
+}
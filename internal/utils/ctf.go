@@ -66,17 +66,11 @@ outerLoop:
 				if err != nil {
 					return nil, errors.Wrap(err, "解析Size出错")
 				}
-				addrEnd := addr + size - 1
-				lineCount := int(addr&0xFFFFFFFFFFFFFFC0 - addrEnd&0xFFFFFFFFFFFFFFC0 + 1)
-				addrList := make([]uint64, 0)
-				for i := 0; i < lineCount; i++ {
-					addrList = append(addrList, addr&0xFFFFFFFFFFFFFFC0+uint64(i<<6))
-				}
 				tid, err := strconv.ParseUint(tidString, 10, 32)
 				if err != nil {
 					return nil, errors.Wrap(err, "解析Tid出错")
 				}
-				result[int(tid)] = append(result[int(tid)], addrList...)
+				result[int(tid)] = AppendCacheLines(result[int(tid)], addr, size)
 			}
 			// 必须从这里break，保证最后的数据读取完毕
 			if ended {
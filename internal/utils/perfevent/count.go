@@ -0,0 +1,69 @@
+package perfevent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"syscall"
+)
+
+// TypeHardware事件的Config
+const (
+	CountHWCPUCycles = iota
+	CountHWInstructions
+	CountHWCacheReferences
+	CountHWCacheMisses
+	CountHWBranchInstructions
+	CountHWBranchMisses
+	CountHWBusCycles
+	CountHWStalledCyclesFrontend
+	CountHWStalledCyclesBackend
+	CountHWRefCPUCycles
+)
+
+// TypeSoftware事件的Config
+const (
+	CountSWCPUClock = iota
+	CountSWTaskClock
+	CountSWPageFaults
+	CountSWContextSwitches
+)
+
+// 事件的计数值。事件因多路复用没有一直在PMU上运行时，Running小于Enabled
+type Count struct {
+	Value   uint64
+	Enabled uint64 // 事件启用的时间，单位纳秒
+	Running uint64 // 事件实际在PMU上计数的时间，单位纳秒
+}
+
+// 按运行时间比例放大后的计数值，是事件在整个启用时间内计数的估计。
+// 内核没有记录时间时（如根cgroup上的事件）返回原始计数值
+func (c Count) Scaled() uint64 {
+	if c.Enabled == 0 && c.Running == 0 {
+		return c.Value
+	}
+	if c.Running == 0 {
+		return 0
+	}
+	if c.Running >= c.Enabled {
+		return c.Value
+	}
+	return uint64(float64(c.Value) * float64(c.Enabled) / float64(c.Running))
+}
+
+// 读取单个事件的计数。事件的ReadFormat必须为FormatTotalTimeEnabled|FormatTotalTimeRunning
+func ReadCount(fd int) (Count, error) {
+	buf := make([]byte, 24)
+	n, err := syscall.Read(fd, buf)
+	if err != nil {
+		return Count{}, errors.Wrap(err, "读取事件计数出错")
+	}
+	if n != len(buf) {
+		return Count{}, fmt.Errorf("读取事件计数长度为%d，事件的ReadFormat可能不正确", n)
+	}
+	return Count{
+		Value:   binary.LittleEndian.Uint64(buf),
+		Enabled: binary.LittleEndian.Uint64(buf[8:]),
+		Running: binary.LittleEndian.Uint64(buf[16:]),
+	}, nil
+}
@@ -0,0 +1,39 @@
+package perfevent
+
+import (
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+)
+
+func TestCountScaled(t *testing.T) {
+	assert.Equal(t, uint64(100), Count{Value: 100, Enabled: 10, Running: 10}.Scaled())
+	assert.Equal(t, uint64(200), Count{Value: 100, Enabled: 10, Running: 5}.Scaled())
+	assert.Equal(t, uint64(0), Count{Value: 100, Enabled: 10}.Scaled())
+	assert.Equal(t, uint64(100), Count{Value: 100}.Scaled())
+}
+
+func TestReadCount(t *testing.T) {
+	attr := &Attr{
+		Type:       TypeSoftware,
+		Config:     CountSWTaskClock,
+		ReadFormat: FormatTotalTimeEnabled | FormatTotalTimeRunning,
+		Bits:       BitExcludeKernel | BitExcludeHv,
+	}
+	fd, err := Open(attr, 0, -1, -1, 0)
+	if err != nil {
+		t.Skip("无法打开软件事件：", err)
+	}
+	defer func() {
+		_ = syscall.Close(fd)
+	}()
+	sum := 0
+	for i := 0; i < 1000000; i++ {
+		sum += i
+	}
+	count, err := ReadCount(fd)
+	assert.NoError(t, err)
+	assert.NotZero(t, count.Value)
+	assert.NotZero(t, count.Enabled)
+	assert.Equal(t, count.Value, count.Scaled())
+}
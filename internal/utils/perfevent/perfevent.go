@@ -0,0 +1,161 @@
+package perfevent
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"syscall"
+	"unsafe"
+)
+
+/*
+perf_event_open系统调用的Go封装，不依赖perf可执行文件与libpfm。
+结构与常量参考include/uapi/linux/perf_event.h。
+*/
+
+// 事件类型
+const (
+	TypeHardware   = 0
+	TypeSoftware   = 1
+	TypeTracepoint = 2
+	TypeHWCache    = 3
+	TypeRaw        = 4
+)
+
+// 采样记录中包含的内容
+const (
+	SampleIP = 1 << iota
+	SampleTID
+	SampleTime
+	SampleAddr
+	SampleRead
+	SampleCallchain
+	SampleID
+	SampleCPU
+	SamplePeriod
+	SampleStreamID
+	SampleRaw
+)
+
+// 读取计数时返回的内容
+const (
+	FormatTotalTimeEnabled = 1 << iota
+	FormatTotalTimeRunning
+	FormatID
+	FormatGroup
+)
+
+// perf_event_attr中的标志位
+const (
+	BitDisabled      = 1 << 0
+	BitInherit       = 1 << 1
+	BitExcludeUser   = 1 << 4
+	BitExcludeKernel = 1 << 5
+	BitExcludeHv     = 1 << 6
+	BitEnableOnExec  = 1 << 12
+	BitWatermark     = 1 << 14
+	BitSampleIDAll   = 1 << 18
+
+	preciseIPShift = 15
+	preciseIPMask  = 3 << preciseIPShift
+)
+
+// perf_event_open的flags
+const (
+	FlagFdCloexec = 1 << 3
+	FlagPidCgroup = 1 << 2
+)
+
+// ioctl请求
+const (
+	ioctlEnable    = 0x2400
+	ioctlDisable   = 0x2401
+	ioctlReset     = 0x2403
+	ioctlSetOutput = 0x2405
+)
+
+// 记录类型
+const (
+	RecordLost   = 2
+	RecordExit   = 4
+	RecordSample = 9
+)
+
+// 对应内核PERF_ATTR_SIZE_VER5的perf_event_attr布局
+type Attr struct {
+	Type             uint32
+	Size             uint32
+	Config           uint64
+	SamplePeriod     uint64 // 设置了Freq标志时为采样频率
+	SampleType       uint64
+	ReadFormat       uint64
+	Bits             uint64
+	WakeupEvents     uint32 // 设置了BitWatermark时为唤醒的字节数
+	BpType           uint32
+	Config1          uint64
+	Config2          uint64
+	BranchSampleType uint64
+	SampleRegsUser   uint64
+	SampleStackUser  uint32
+	ClockID          int32
+	SampleRegsIntr   uint64
+	AuxWatermark     uint32
+	SampleMaxStack   uint16
+	reserved         uint16
+}
+
+// 设置精确采样等级。0为任意偏移，3为必须没有偏移，PEBS采样至少为1
+func (a *Attr) SetPreciseIP(level uint64) {
+	a.Bits = a.Bits&^preciseIPMask | (level<<preciseIPShift)&preciseIPMask
+}
+
+func (a *Attr) PreciseIP() uint64 {
+	return (a.Bits & preciseIPMask) >> preciseIPShift
+}
+
+// 打开一个性能事件。pid为-1时监控所有进程，cpu为-1时监控所有CPU，groupFd为-1时创建新的事件组
+func Open(attr *Attr, pid, cpu, groupFd int, flags uintptr) (int, error) {
+	attr.Size = uint32(unsafe.Sizeof(*attr))
+	fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN, uintptr(unsafe.Pointer(attr)), uintptr(pid),
+		uintptr(cpu), uintptr(groupFd), flags|FlagFdCloexec, 0)
+	if errno != 0 {
+		return -1, errors.Wrap(errno, fmt.Sprintf("perf_event_open(type=%d, config=0x%x, pid=%d, cpu=%d)出错",
+			attr.Type, attr.Config, pid, cpu))
+	}
+	return int(fd), nil
+}
+
+func ioctl(fd int, req uintptr, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// 启用事件，group为true时启用整个事件组
+func Enable(fd int, group bool) error {
+	return ioctl(fd, ioctlEnable, groupArg(group))
+}
+
+// 停用事件，group为true时停用整个事件组
+func Disable(fd int, group bool) error {
+	return ioctl(fd, ioctlDisable, groupArg(group))
+}
+
+// 将计数清零，group为true时清零整个事件组
+func Reset(fd int, group bool) error {
+	return ioctl(fd, ioctlReset, groupArg(group))
+}
+
+// 将fd的采样输出到target的环形缓冲区中。两个事件必须位于同一个线程或者同一个CPU上
+func SetOutput(fd, target int) error {
+	return ioctl(fd, ioctlSetOutput, uintptr(target))
+}
+
+func groupArg(group bool) uintptr {
+	if group {
+		// PERF_IOC_FLAG_GROUP
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package perfevent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// perf_event_mmap_page中data_head与data_tail的偏移
+const (
+	dataHeadOffset = 1024
+	dataTailOffset = 1032
+)
+
+const recordHeaderSize = 8
+
+// 事件的mmap环形缓冲区。第一页为元数据页，之后2^n页为数据区
+type RingBuffer struct {
+	mem     []byte
+	data    []byte
+	scratch []byte // 记录跨越数据区末尾时用于拼接
+}
+
+// 映射事件fd的环形缓冲区。pages为数据区的页数，必须为2的幂
+func NewRingBuffer(fd, pages int) (*RingBuffer, error) {
+	if pages <= 0 || pages&(pages-1) != 0 {
+		return nil, fmt.Errorf("环形缓冲区页数%d不是2的幂", pages)
+	}
+	pageSize := os.Getpagesize()
+	mem, err := syscall.Mmap(fd, 0, (pages+1)*pageSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrap(err, "映射环形缓冲区出错")
+	}
+	return &RingBuffer{
+		mem:  mem,
+		data: mem[pageSize:],
+	}, nil
+}
+
+// 数据区字节数
+func (r *RingBuffer) Size() int {
+	return len(r.data)
+}
+
+// 读取所有新的记录。fn中的body只在回调期间有效
+func (r *RingBuffer) Read(fn func(typ uint32, misc uint16, body []byte)) {
+	headPtr := (*uint64)(unsafe.Pointer(&r.mem[dataHeadOffset]))
+	tailPtr := (*uint64)(unsafe.Pointer(&r.mem[dataTailOffset]))
+	// 读取head需要有acquire语义，写入tail需要有release语义，atomic满足要求
+	head := atomic.LoadUint64(headPtr)
+	tail := atomic.LoadUint64(tailPtr)
+	tail, r.scratch = readRecords(r.data, head, tail, r.scratch, fn)
+	atomic.StoreUint64(tailPtr, tail)
+}
+
+func (r *RingBuffer) Close() error {
+	if r.mem == nil {
+		return nil
+	}
+	err := syscall.Munmap(r.mem)
+	r.mem, r.data = nil, nil
+	return err
+}
+
+// 从环形数据区中读取[tail, head)之间的所有完整记录，返回新的tail。
+// data的长度必须为2的幂，head与tail为单调递增的字节位置。
+func readRecords(data []byte, head, tail uint64, scratch []byte,
+	fn func(typ uint32, misc uint16, body []byte)) (uint64, []byte) {
+	mask := uint64(len(data) - 1)
+	for head-tail >= recordHeaderSize {
+		header := readWrapped(data, tail&mask, recordHeaderSize, scratch[:0])
+		typ := binary.LittleEndian.Uint32(header)
+		misc := binary.LittleEndian.Uint16(header[4:])
+		size := uint64(binary.LittleEndian.Uint16(header[6:]))
+		if size < recordHeaderSize || head-tail < size {
+			// 记录不完整，等待下一次读取
+			break
+		}
+		if cap(scratch) < int(size) {
+			scratch = make([]byte, 0, size*2)
+		}
+		record := readWrapped(data, tail&mask, int(size), scratch[:0])
+		fn(typ, misc, record[recordHeaderSize:])
+		tail += size
+	}
+	return tail, scratch
+}
+
+// 从start开始读取size字节，跨越末尾时拷贝到buf中
+func readWrapped(data []byte, start uint64, size int, buf []byte) []byte {
+	end := int(start) + size
+	if end <= len(data) {
+		return data[start:end]
+	}
+	buf = append(buf, data[start:]...)
+	return append(buf, data[:end-len(data)]...)
+}
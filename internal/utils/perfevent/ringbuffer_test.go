@@ -0,0 +1,64 @@
+package perfevent
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"unsafe"
+)
+
+// 在环形数据区的pos位置写入一条记录，返回新的位置
+func putRecord(data []byte, pos uint64, typ uint32, body []byte) uint64 {
+	record := make([]byte, recordHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(record, typ)
+	binary.LittleEndian.PutUint16(record[6:], uint16(len(record)))
+	copy(record[recordHeaderSize:], body)
+	mask := uint64(len(data) - 1)
+	for i, b := range record {
+		data[(pos+uint64(i))&mask] = b
+	}
+	return pos + uint64(len(record))
+}
+
+func TestReadRecords(t *testing.T) {
+	data := make([]byte, 64)
+	type record struct {
+		typ  uint32
+		body []byte
+	}
+	var got []record
+	fn := func(typ uint32, misc uint16, body []byte) {
+		got = append(got, record{typ: typ, body: append([]byte{}, body...)})
+	}
+
+	// 从40开始写入，第二条记录跨越末尾
+	head := putRecord(data, 40, RecordSample, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	head = putRecord(data, head, RecordLost, []byte{9, 10, 11, 12, 13, 14, 15, 16})
+	tail, scratch := readRecords(data, head, 40, nil, fn)
+	assert.Equal(t, head, tail)
+	assert.Equal(t, []record{
+		{typ: RecordSample, body: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{typ: RecordLost, body: []byte{9, 10, 11, 12, 13, 14, 15, 16}},
+	}, got)
+
+	// 记录还没有写完整时不读取
+	got = nil
+	next := putRecord(data, head, RecordSample, []byte{17, 18, 19, 20, 21, 22, 23, 24})
+	tail, scratch = readRecords(data, next-4, tail, scratch, fn)
+	assert.Equal(t, head, tail)
+	assert.Empty(t, got)
+	tail, _ = readRecords(data, next, tail, scratch, fn)
+	assert.Equal(t, next, tail)
+	assert.Len(t, got, 1)
+}
+
+func TestAttr(t *testing.T) {
+	// PERF_ATTR_SIZE_VER5
+	assert.Equal(t, uintptr(112), unsafe.Sizeof(Attr{}))
+	attr := &Attr{Bits: BitDisabled | BitExcludeKernel}
+	attr.SetPreciseIP(2)
+	assert.Equal(t, uint64(2), attr.PreciseIP())
+	assert.Equal(t, uint64(BitDisabled|BitExcludeKernel), attr.Bits&^preciseIPMask)
+	attr.SetPreciseIP(1)
+	assert.Equal(t, uint64(1), attr.PreciseIP())
+}
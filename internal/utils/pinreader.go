@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"bufio"
 	"encoding/binary"
 	"io"
 	"os"
@@ -18,6 +19,7 @@ type PinBinaryReader struct {
 	f *os.File
 }
 
+// Pin二进制格式的一段记录。List中为原始条目，使用DecodePinEntry解析
 type PinBinaryRecord struct {
 	Tid  int
 	List []uint64
@@ -26,25 +28,24 @@ type PinBinaryRecord struct {
 func (p *PinBinaryReader) AsyncRead() <-chan *PinBinaryRecord {
 	ch := make(chan *PinBinaryRecord, 1024)
 	go func() {
-		buf := make([]byte, 4096)
+		reader := bufio.NewReaderSize(p.f, 1<<16)
+		buf := make([]byte, 8)
 		var tid uint64
 		var list []uint64
-		for n, err := p.f.Read(buf); err == nil || (n != 0 && err == io.EOF); n, err = p.f.Read(buf) {
-			for i := 0; i < len(buf); i += 8 {
-				num := binary.LittleEndian.Uint64(buf[i : i+8])
-				if num == 0 {
-					ch <- &PinBinaryRecord{
-						Tid:  int(tid),
-						List: list,
-					}
-					tid = 0
-					list = nil
+		for _, err := io.ReadFull(reader, buf); err == nil; _, err = io.ReadFull(reader, buf) {
+			num := binary.LittleEndian.Uint64(buf)
+			if num == 0 {
+				ch <- &PinBinaryRecord{
+					Tid:  int(tid),
+					List: list,
+				}
+				tid = 0
+				list = nil
+			} else {
+				if tid == 0 {
+					tid = num
 				} else {
-					if tid == 0 {
-						tid = num
-					} else {
-						list = append(list, num)
-					}
+					list = append(list, num)
 				}
 			}
 		}
@@ -1,19 +1,14 @@
 package utils
 
 import (
+	"github.com/packagewjx/resourcemanager/test"
 	"github.com/stretchr/testify/assert"
 	"path/filepath"
-	"runtime"
-	"strings"
 	"testing"
 )
 
 func TestRead(t *testing.T) {
-	_, file, _, _ := runtime.Caller(0)
-	dirs := strings.Split(filepath.Dir(file), string(filepath.Separator))
-	dirs = append(dirs[:len(dirs)-3], "test", "data", "ls.dat")
-	file = strings.Join(dirs, string(filepath.Separator))
-	reader, err := NewPinBinaryReader(file)
+	reader, err := NewPinBinaryReader(filepath.Join(test.GetTestDataDir(), "ls.dat"))
 	assert.NoError(t, err)
 	ch := reader.AsyncRead()
 	for record := range ch {
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 判断进程是否与本进程处于不同的挂载名称空间，即进程是否运行在容器中
+func InOtherMountNamespace(pid int) (bool, error) {
+	self, err := os.Readlink("/proc/self/ns/mnt")
+	if err != nil {
+		return false, errors.Wrap(err, "读取本进程的名称空间出错")
+	}
+	target, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", pid))
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("读取进程 %d 的名称空间出错", pid))
+	}
+	return self != target, nil
+}
+
+// 进程看到的根目录在本进程中的路径，通过这个路径可以访问容器内的文件
+func ProcessRootPath(pid int) string {
+	return fmt.Sprintf("/proc/%d/root", pid)
+}
+
+// 读取进程所有线程在其最内层PID名称空间中的线程号，返回容器内线程号到本名称空间线程号的映射
+func NamespaceTidMap(pid int) (map[int]int, error) {
+	infos, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("读取进程 %d 的线程出错", pid))
+	}
+	res := make(map[int]int, len(infos))
+	for _, info := range infos {
+		tid, err := strconv.Atoi(info.Name())
+		if err != nil {
+			continue
+		}
+		f, err := os.Open(fmt.Sprintf("/proc/%d/task/%d/status", pid, tid))
+		if err != nil {
+			// 线程已经退出
+			continue
+		}
+		nsPid := parseNSpid(f)
+		_ = f.Close()
+		if len(nsPid) == 0 {
+			// 内核不支持NSpid时认为与本名称空间相同
+			res[tid] = tid
+		} else {
+			res[nsPid[len(nsPid)-1]] = tid
+		}
+	}
+	return res, nil
+}
+
+// 解析/proc/<pid>/status中的NSpid行，依次为从本名称空间到最内层名称空间的线程号
+func parseNSpid(status io.Reader) []int {
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "NSpid:") {
+			continue
+		}
+		var res []int
+		for _, field := range strings.Fields(line[len("NSpid:"):]) {
+			id, err := strconv.Atoi(field)
+			if err != nil {
+				return nil
+			}
+			res = append(res, id)
+		}
+		return res
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseNSpid(t *testing.T) {
+	status := "Name:\tsleep\nTgid:\t4321\nNSpid:\t4321\t12\nPPid:\t1\n"
+	assert.Equal(t, []int{4321, 12}, parseNSpid(strings.NewReader(status)))
+	assert.Nil(t, parseNSpid(strings.NewReader("Name:\tsleep\n")))
+}
+
+func TestNamespaceTidMap(t *testing.T) {
+	inOther, err := InOtherMountNamespace(os.Getpid())
+	assert.NoError(t, err)
+	assert.False(t, inOther)
+
+	tids, err := NamespaceTidMap(os.Getpid())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tids)
+	// 本进程与自己处于同一个名称空间
+	for nsTid, tid := range tids {
+		assert.Equal(t, tid, nsTid)
+	}
+	_, err = NamespaceTidMap(-1)
+	assert.Error(t, err)
+}